@@ -4,51 +4,123 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/papidb/drift-detector/internal/drift-detectors"
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/cloud/aws/awssession"
 	awsRepository "github.com/papidb/drift-detector/pkg/cloud/aws/repository"
+	"github.com/papidb/drift-detector/pkg/cloud/aws/resilience"
 	"github.com/papidb/drift-detector/pkg/common"
 	"github.com/papidb/drift-detector/pkg/file"
 	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/papidb/drift-detector/pkg/matcher"
 	"github.com/papidb/drift-detector/pkg/parser"
 	"github.com/papidb/drift-detector/pkg/printer"
+	"github.com/papidb/drift-detector/pkg/redact"
+	"github.com/papidb/drift-detector/pkg/state"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type CompareOptions struct {
 	InstanceIDs []string
-	TFPath      string
-	AWSPath     string
+	// Tags are Key=Value or Key selectors that narrow the instances compared.
+	Tags              []string
+	IncludeTerminated bool
+	// IncludeOperational reports operational changes, such as a stopped instance, alongside configuration drift.
+	IncludeOperational bool
+	Timeout            time.Duration
+	TFPath             string
+	TFDir              string
+	// TerragruntDir is a Terragrunt live repository whose stacks' remote states are compared.
+	TerragruntDir string
+	AWSPath       string
+	Source        string
+	// CFNStackResources is a describe-stack-resources export used with the cloudformation source.
+	CFNStackResources string
+
+	// Regions are scanned instead of the session's region; AllRegions scans every enabled region.
+	Regions    []string
+	AllRegions bool
+	// AccountsPath is an accounts configuration; each account is scanned through its assumed role.
+	AccountsPath string
+
+	// Profile, Region and EndpointURL configure the AWS session; ConfigPath is a config file that can set them too.
+	Profile     string
+	Region      string
+	EndpointURL string
+	ConfigPath  string
+
+	// MaxRetries, RateLimit and CallTimeout control how AWS calls are retried, throttled and bounded.
+	MaxRetries  int
+	RateLimit   float64
+	CallTimeout time.Duration
+
+	RedactPaths    []string
+	RedactPatterns []string
+
+	// Match lists the matchers (arn, tag:<key>, fuzzy) that pair state resources
+	// missing from the cloud with the resources that replaced them.
+	Match []string
 }
 
 // AppConfig holds dependencies for the command
 type AppConfig struct {
 	Logger         logger.Logger
-	Session        *session.Session
+	Sessions       awssession.Provider
+	RetryStats     *resilience.Stats
 	Options        *CompareOptions
 	OutputType     common.OutputType
 	FileReader     file.FileReader
+	StateSource    state.Source
 	DriftPrinter   printer.Printer
 	Parser         parser.Parser
 	Comparator     drift.DriftComparator
-	EC2RepoFactory func(*session.Session, string, file.FileReader, logger.Logger) awsRepository.EC2Repository
+	Matchers       []matcher.Matcher
+	EC2RepoFactory func(*session.Session, awsRepository.Scope, string, file.FileReader, logger.Logger) awsRepository.EC2Repository
 }
 
-// NewAppConfig creates a new AppConfig with default dependencies
-func NewAppConfig(outputType common.OutputType, log logger.Logger, options *CompareOptions, sess *session.Session) *AppConfig {
+// envConfigFile names the config file when --config is not given.
+const envConfigFile = "DRIFT_DETECTOR_CONFIG"
+
+// NewAppConfig creates a new AppConfig with default dependencies. The AWS
+// session is only created once something needs it.
+func NewAppConfig(outputType common.OutputType, log logger.Logger, options *CompareOptions) *AppConfig {
+	fileReader := &file.OSFileReader{}
+	retryStats := resilience.NewStats()
+	sessions := awssession.NewLazyProvider(func() (awssession.Options, error) {
+		sessOptions, err := sessionOptions(options, fileReader)
+		sessOptions.Resilience.Stats = retryStats
+		return sessOptions, err
+	})
 	return &AppConfig{
-		Logger:       log,
-		Session:      sess,
-		Options:      options,
-		OutputType:   outputType,
-		FileReader:   &file.OSFileReader{},
+		Logger:     log,
+		Sessions:   sessions,
+		RetryStats: retryStats,
+		Options:    options,
+		OutputType: outputType,
+		FileReader: fileReader,
+		StateSource: state.NewSource(fileReader, map[string]state.Source{
+			"s3":    state.NewS3Source(sessions, log),
+			"http":  state.NewHTTPSource(http.DefaultClient, log),
+			"https": state.NewHTTPSource(http.DefaultClient, log),
+			"tfe":   state.NewTFESource(http.DefaultClient, log),
+		}),
 		DriftPrinter: printer.NewPrinter(outputType),
-		Parser:       parser.NewParser(),
+		Parser:       parser.NewParser(parsedResourceTypes()...),
 		Comparator:   drift.NewDriftComparator(),
-		EC2RepoFactory: func(sess *session.Session, awsPath string, reader file.FileReader, log logger.Logger) awsRepository.EC2Repository {
+		EC2RepoFactory: func(sess *session.Session, scope awsRepository.Scope, awsPath string, reader file.FileReader, log logger.Logger) awsRepository.EC2Repository {
 			if awsPath != "" {
 				data, err := reader.ReadFile(awsPath)
 				if err != nil {
@@ -57,7 +129,7 @@ func NewAppConfig(outputType common.OutputType, log logger.Logger, options *Comp
 				}
 				return awsRepository.NewJSONEC2Repo(bytes.NewReader(data))
 			}
-			return awsRepository.NewEC2Repo(sess)
+			return awsRepository.NewScopedEC2Repo(sess, scope)
 		},
 	}
 }
@@ -66,54 +138,299 @@ func NewAppConfig(outputType common.OutputType, log logger.Logger, options *Comp
 func loadConfigs(ctx context.Context, config *AppConfig) ([]types.Resource, []types.Resource, error) {
 	config.Logger.Debug("Loading configs")
 
-	// Read Terraform state file
-	data, err := config.FileReader.ReadFile(config.Options.TFPath)
+	stateFiles := []state.File{{Path: config.Options.TFPath, Workspace: state.DefaultWorkspace}}
+	if config.Options.TFDir != "" {
+		discovered, err := state.Discover(config.Options.TFDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		stateFiles = discovered
+	}
+	if config.Options.TerragruntDir != "" {
+		discovered, err := state.DiscoverTerragrunt(config.Options.TerragruntDir, config.Logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		stateFiles = discovered
+	}
+
+	filter, err := instanceFilter(config.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stateResources, err := loadStates(ctx, config, stateFiles)
 	if err != nil {
 		return nil, nil, err
 	}
+	// Hold the desired side to the same selection so unselected instances are not reported missing
+	stateResources = selectInstances(filter, stateResources)
+	filter.ExpectedImages, filter.ParentExpectedImages = expectedImages(stateResources)
+	filter.Recorded, filter.ParentRecorded = recordedAttributes(stateResources)
+	// A replacement has a new ID, so matchers need every instance to pair it with
+	if len(config.Matchers) > 0 {
+		filter.InstanceIDs = nil
+	}
 
-	stateResources, err := config.Parser.ParseTerraformStateFile(data)
+	// An AWS JSON file stands in for the live account, so no session is needed
+	var sess *session.Session
+	if config.Options.AWSPath == "" && config.Sessions != nil {
+		sess, err = config.Sessions.Session()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	accounts, err := loadAccounts(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse tf config: %w", err)
+		return nil, nil, err
 	}
+	regions, accounts, err := resolveRegions(ctx, config, sess, accounts)
+	if err != nil {
+		return nil, nil, err
+	}
+	scope := awsRepository.Scope{Regions: regions, Accounts: accounts}
 
 	// Create EC2 repository
-	ec2Repo := config.EC2RepoFactory(config.Session, config.Options.AWSPath, config.FileReader, config.Logger)
+	ec2Repo := config.EC2RepoFactory(sess, scope, config.Options.AWSPath, config.FileReader, config.Logger)
 	if ec2Repo == nil {
 		return nil, nil, fmt.Errorf("failed to create EC2 repository")
 	}
 
-	awsResources, err := ec2Repo.ListInstances(ctx)
+	awsResources, err := ec2Repo.ListInstances(ctx, filter)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to fetch AWS instances: %w", err)
 	}
+	// A tag changed out of band drops the instance from the tag-filtered listing,
+	// so describe it by ID to report the tag drift instead of a missing instance
+	if dropped := droppedInstances(stateResources, awsResources); len(filter.Tags) > 0 && len(dropped) > 0 {
+		byID := filter
+		byID.InstanceIDs, byID.Tags = dropped, nil
+		retagged, err := ec2Repo.ListInstances(ctx, byID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch AWS instances: %w", err)
+		}
+		awsResources = append(awsResources, retagged...)
+	}
 
-	return stateResources, awsResources, nil
+	// Resources in regions or accounts that were not scanned can be neither missing nor unmanaged
+	scanned := scannedRegions(config, sess, scope)
+	stateResources = filterByAccounts(filterByRegions(stateResources, scanned), accounts)
+	return stateResources, filterByRegions(awsResources, scanned), nil
 }
 
-// compareResources compares Terraform and AWS resources, returning grouped drifts
-func compareResources(tfResources, awsResources []types.Resource, comparator drift.DriftComparator, logger logger.Logger, instanceIDs []string) map[types.ResourceType][]types.DriftGroup {
-	// Filter resources by instance IDs if provided
-	var filteredTFResources, filteredAWSResources []types.Resource
-	if len(instanceIDs) > 0 {
-		idSet := make(map[string]struct{})
-		for _, id := range instanceIDs {
-			idSet[id] = struct{}{}
-		}
-		for _, res := range tfResources {
-			if _, ok := idSet[res.Name]; ok {
-				filteredTFResources = append(filteredTFResources, res)
+// sessionOptions resolves the AWS session options from the flags, then the
+// DRIFT_DETECTOR_AWS_* environment variables, then the config file.
+func sessionOptions(opts *CompareOptions, reader file.FileReader) (awssession.Options, error) {
+	options := awssession.Options{
+		Profile:     opts.Profile,
+		Region:      opts.Region,
+		EndpointURL: opts.EndpointURL,
+		Resilience: resilience.Config{
+			MaxRetries:  opts.MaxRetries,
+			RateLimit:   opts.RateLimit,
+			CallTimeout: opts.CallTimeout,
+		},
+	}.Or(awssession.FromEnv())
+
+	configPath := opts.ConfigPath
+	if configPath == "" {
+		configPath = os.Getenv(envConfigFile)
+	}
+	if configPath == "" {
+		return options, nil
+	}
+
+	data, err := reader.ReadFile(configPath)
+	if err != nil {
+		return awssession.Options{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	fileOptions, err := awssession.ParseConfigFile(data)
+	if err != nil {
+		return awssession.Options{}, err
+	}
+	return options.Or(fileOptions), nil
+}
+
+// loadAccounts reads the accounts configuration, if one was given.
+func loadAccounts(config *AppConfig) ([]awsRepository.Account, error) {
+	if config.Options.AccountsPath == "" {
+		return nil, nil
+	}
+	data, err := config.FileReader.ReadFile(config.Options.AccountsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts: %w", err)
+	}
+	return awsRepository.ParseAccounts(data)
+}
+
+// resolveRegions returns the regions to list instances in, or nil to use the
+// session's region, and the accounts with the regions each one scans. With
+// --all-regions, every account that lists no regions of its own scans those
+// enabled for it, listed through its assumed role. An AWS JSON file is read
+// as is, so it never triggers a DescribeRegions call.
+func resolveRegions(ctx context.Context, config *AppConfig, sess *session.Session, accounts []awsRepository.Account) ([]string, []awsRepository.Account, error) {
+	if !config.Options.AllRegions || sess == nil {
+		return config.Options.Regions, accounts, nil
+	}
+	if len(accounts) == 0 {
+		regions, err := awsRepository.EnabledRegions(ctx, sess)
+		return regions, nil, err
+	}
+
+	resolved := make([]awsRepository.Account, len(accounts))
+	for i, account := range accounts {
+		if len(account.Regions) == 0 {
+			regions, err := awsRepository.EnabledRegions(ctx, awsRepository.AssumeRole(sess, account))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to list the regions of account %s: %w", account.ID, err)
 			}
+			account.Regions = regions
 		}
-		for _, res := range awsResources {
-			if _, ok := idSet[res.Name]; ok {
-				filteredAWSResources = append(filteredAWSResources, res)
+		resolved[i] = account
+	}
+	return nil, resolved, nil
+}
+
+// scannedRegions returns the regions whose resources take part in the
+// comparison, or nil when every region does.
+func scannedRegions(config *AppConfig, sess *session.Session, scope awsRepository.Scope) []string {
+	if config.Options.AWSPath != "" {
+		return scope.Regions
+	}
+
+	defaults := scope.Regions
+	if len(defaults) == 0 && sess != nil {
+		if region := aws.StringValue(sess.Config.Region); region != "" {
+			defaults = []string{region}
+		}
+	}
+	if len(scope.Accounts) == 0 {
+		return defaults
+	}
+
+	// Every account scans its own regions, or the defaults
+	var regions []string
+	for _, account := range scope.Accounts {
+		if len(account.Regions) == 0 {
+			if len(defaults) == 0 {
+				return nil
 			}
+			regions = append(regions, defaults...)
+			continue
+		}
+		regions = append(regions, account.Regions...)
+	}
+	return regions
+}
+
+// filterByAccounts keeps the resources in one of accounts, and those whose
+// account is unknown, or all of them when no accounts are given.
+func filterByAccounts(resources []types.Resource, accounts []awsRepository.Account) []types.Resource {
+	if len(accounts) == 0 {
+		return resources
+	}
+
+	accountSet := make(map[string]struct{})
+	for _, account := range accounts {
+		accountSet[account.ID] = struct{}{}
+	}
+
+	var filtered []types.Resource
+	for _, res := range resources {
+		if _, ok := accountSet[res.Account]; ok || res.Account == "" {
+			filtered = append(filtered, res)
 		}
-	} else {
-		filteredTFResources = tfResources
-		filteredAWSResources = awsResources
 	}
+	return filtered
+}
+
+// filterByRegions keeps the resources in one of regions, and those whose region is unknown.
+func filterByRegions(resources []types.Resource, regions []string) []types.Resource {
+	if len(regions) == 0 {
+		return resources
+	}
+
+	regionSet := make(map[string]struct{})
+	for _, region := range regions {
+		regionSet[region] = struct{}{}
+	}
+
+	var filtered []types.Resource
+	for _, res := range resources {
+		if _, ok := regionSet[res.Region]; ok || res.Region == "" {
+			filtered = append(filtered, res)
+		}
+	}
+	return filtered
+}
+
+// instanceFilter builds the instance selection from the command options.
+func instanceFilter(opts *CompareOptions) (awsRepository.InstanceFilter, error) {
+	tags, err := awsRepository.ParseTagSelectors(opts.Tags)
+	if err != nil {
+		return awsRepository.InstanceFilter{}, err
+	}
+	return awsRepository.InstanceFilter{
+		InstanceIDs:       opts.InstanceIDs,
+		Tags:              tags,
+		IncludeTerminated: opts.IncludeTerminated,
+	}, nil
+}
+
+// loadStates parses the given state files concurrently and merges their
+// resources, tagging each one with the file and workspace it came from.
+func loadStates(ctx context.Context, config *AppConfig, stateFiles []state.File) ([]types.Resource, error) {
+	results := make([][]types.Resource, len(stateFiles))
+	errs := make([]error, len(stateFiles))
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, stateFile := range stateFiles {
+		wg.Add(1)
+		go func(i int, stateFile state.File) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = loadState(ctx, config, stateFile)
+		}(i, stateFile)
+	}
+	wg.Wait()
+
+	var merged []types.Resource
+	for i := range stateFiles {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		merged = append(merged, results[i]...)
+	}
+	return merged, nil
+}
+
+// loadState streams and parses a single state so large states never sit in memory whole.
+func loadState(ctx context.Context, config *AppConfig, stateFile state.File) ([]types.Resource, error) {
+	reader, err := config.StateSource.Open(ctx, stateFile.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	resources, err := config.Parser.ParseTerraformStateStream(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tf config %q: %w", stateFile.Path, err)
+	}
+
+	for i := range resources {
+		resources[i].Source = stateFile.Name()
+		resources[i].Workspace = stateFile.Workspace
+	}
+	return resources, nil
+}
+
+// compareResources compares Terraform and AWS resources, returning grouped drifts
+func compareResources(tfResources, awsResources []types.Resource, comparator drift.DriftComparator, logger logger.Logger, instanceIDs []string) map[types.ResourceType][]types.DriftGroup {
+	filteredTFResources := filterByInstanceIDs(tfResources, instanceIDs)
+	filteredAWSResources := filterByInstanceIDs(awsResources, instanceIDs)
 
 	groupedTerraform := common.GroupResourcesByType(filteredTFResources)
 	groupedCloud := common.GroupResourcesByType(filteredAWSResources)
@@ -132,13 +449,10 @@ func compareResources(tfResources, awsResources []types.Resource, comparator dri
 		tfResources := groupedTerraform[resourceType]
 		cloudResources := groupedCloud[resourceType]
 
-		cloudMap := make(map[string]types.Resource)
-		for _, r := range cloudResources {
-			cloudMap[r.Name] = r
-		}
+		cloudIndex := indexResources(cloudResources)
 
 		for _, tfRes := range tfResources {
-			cloudRes, ok := cloudMap[tfRes.Name]
+			cloudRes, ok := findMatch(cloudIndex, tfRes)
 			if !ok {
 				continue
 			}
@@ -152,18 +466,505 @@ func compareResources(tfResources, awsResources []types.Resource, comparator dri
 				driftResults[resourceType] = append(driftResults[resourceType], types.DriftGroup{
 					ResourceName: tfRes.Name,
 					Drifts:       result,
+					Source:       tfRes.Source,
+					Workspace:    tfRes.Workspace,
+					Region:       cloudRes.Region,
+					Account:      cloudRes.Account,
+					AccountAlias: cloudRes.AccountAlias,
 				})
 			}
 		}
 	}
 
+	// Parents are looked up among all state resources, since the instance IDs only select instances
+	managedGroups := compareManagedInstances(tfResources, filteredAWSResources, comparator, logger)
+	if len(managedGroups) > 0 {
+		driftResults[types.EC2Instance] = append(driftResults[types.EC2Instance], managedGroups...)
+	}
+
 	return driftResults
 }
 
-// runCompare executes the comparison and prints results
-func runCompare(config *AppConfig) error {
-	ctx := context.Background()
+// compareManagedInstances compares the cloud instances a service resource such
+// as an Auto Scaling group launched against that resource's launch
+// specification, when the state tracks the parent rather than the instance.
+func compareManagedInstances(tfResources, awsResources []types.Resource, comparator drift.DriftComparator, logger logger.Logger) []types.DriftGroup {
+	index := indexResources(tfResources)
+
+	var groups []types.DriftGroup
+	for _, res := range awsResources {
+		if res.Parent == nil {
+			continue
+		}
+		if _, tracked := findMatch(index, res); tracked {
+			continue
+		}
+		parent, ok := findMatch(index, parentOf(res))
+		if !ok {
+			continue
+		}
 
+		result, err := comparator.CompareEC2Configs(launchSpec(parent, index), res)
+		if err != nil {
+			logger.Debug("Failed to compare %s against %s: %s", res.Name, res.Parent, err)
+			continue
+		}
+		if len(result) > 0 {
+			groups = append(groups, types.DriftGroup{
+				ResourceName: res.Name,
+				Drifts:       result,
+				Source:       parent.Source,
+				Workspace:    parent.Workspace,
+				Region:       res.Region,
+				Account:      res.Account,
+				AccountAlias: res.AccountAlias,
+				Parent:       res.Parent,
+			})
+		}
+	}
+	return groups
+}
+
+// parentOf returns a reference to the resource managing res, located where res
+// is, for findMatch.
+func parentOf(res types.Resource) types.Resource {
+	return types.Resource{Type: res.Parent.Type, Name: res.Parent.Name, Region: res.Region, Account: res.Account}
+}
+
+// launchSpec turns a parent resource's launch specification into the desired
+// side of an instance comparison. An Auto Scaling group's launch
+// configuration is looked up in index and merged in.
+func launchSpec(parent types.Resource, index map[string][]types.Resource) types.Resource {
+	data := make(map[string]interface{})
+	parentData, _ := parent.Data.(map[string]interface{})
+	for key, value := range parentData {
+		data[key] = value
+	}
+
+	spec := parent
+	spec.Type = types.EC2Instance
+	spec.Data = data
+	if name, _ := data["launch_configuration"].(string); name != "" {
+		delete(data, "launch_configuration")
+		ref := types.Resource{Type: types.LaunchConfiguration, Name: name, Region: parent.Region, Account: parent.Account}
+		if config, ok := findMatch(index, ref); ok {
+			configData, _ := config.Data.(map[string]interface{})
+			for key, value := range configData {
+				if _, ok := data[key]; !ok {
+					data[key] = value
+				}
+			}
+			spec.Sensitive = append(append([]string{}, parent.Sensitive...), config.Sensitive...)
+		}
+	}
+	return spec
+}
+
+// withoutOperational drops operational drifts, and the groups left without drift.
+func withoutOperational(driftResults map[types.ResourceType][]types.DriftGroup) map[types.ResourceType][]types.DriftGroup {
+	filtered := make(map[types.ResourceType][]types.DriftGroup)
+	for resourceType, groups := range driftResults {
+		for _, group := range groups {
+			group.Drifts = drift.WithoutOperational(group.Drifts)
+			if len(group.Drifts) > 0 {
+				filtered[resourceType] = append(filtered[resourceType], group)
+			}
+		}
+	}
+	return filtered
+}
+
+// selectInstances applies the instance filter to the instances among
+// resources. Resources that launch instances are kept for whichever of their
+// instances are selected.
+func selectInstances(filter awsRepository.InstanceFilter, resources []types.Resource) []types.Resource {
+	var selected []types.Resource
+	for _, res := range resources {
+		if res.Type != types.EC2Instance || filter.Matches(res) {
+			selected = append(selected, res)
+		}
+	}
+	return selected
+}
+
+// droppedInstances returns the IDs of the state instances the cloud listing
+// did not return.
+func droppedInstances(stateResources, awsResources []types.Resource) []string {
+	listed := make(map[string]struct{}, len(awsResources))
+	for _, res := range awsResources {
+		listed[res.Name] = struct{}{}
+	}
+	var dropped []string
+	for _, res := range stateResources {
+		if _, ok := listed[res.Name]; res.Type == types.EC2Instance && !ok {
+			dropped = append(dropped, res.Name)
+			listed[res.Name] = struct{}{}
+		}
+	}
+	return dropped
+}
+
+// findUnmatchedResources reports state resources that were not found in the
+// cloud and cloud resources that no loaded state owns. Only resource types with
+// a registered comparator are considered, since those are the only types the
+// cloud side is listed for. They are not narrowed to the selected instances,
+// so that a replacement can be paired with the instance it replaced; see
+// selectFindings.
+func findUnmatchedResources(tfResources, awsResources []types.Resource) []types.Finding {
+	supported := make(map[types.ResourceType]struct{})
+	for _, resourceType := range drift.SupportedResourceTypes() {
+		supported[resourceType] = struct{}{}
+	}
+
+	stateIndex := indexResources(tfResources)
+	existing := indexResources(awsResources)
+
+	var findings []types.Finding
+	for _, res := range tfResources {
+		if _, ok := supported[res.Type]; !ok {
+			continue
+		}
+		if _, ok := findMatch(existing, res); !ok {
+			findings = append(findings, types.Finding{
+				Kind:         types.FindingMissing,
+				ResourceType: res.Type,
+				ResourceName: res.Name,
+				Region:       res.Region,
+				Account:      res.Account,
+				AccountAlias: res.AccountAlias,
+				Message:      fmt.Sprintf("tracked in %s but not found in the cloud", sourceLabel(res.Source, res.Workspace)),
+				Resources:    []types.Resource{res},
+			})
+		}
+	}
+	// Parents are told apart by location too, since names only need to be unique within a region
+	type parentKey struct {
+		types.ResourceRef
+		region, account string
+	}
+	var unmanagedParents []parentKey
+	launched := make(map[parentKey][]types.Resource)
+	for _, res := range awsResources {
+		if _, ok := findMatch(stateIndex, res); ok {
+			continue
+		}
+		if res.Parent != nil {
+			// Instances belong to their parent; only an unmanaged parent is reported
+			if _, ok := findMatch(stateIndex, parentOf(res)); ok {
+				continue
+			}
+			key := parentKey{ResourceRef: *res.Parent, region: res.Region, account: res.Account}
+			if _, ok := launched[key]; !ok {
+				unmanagedParents = append(unmanagedParents, key)
+			}
+			launched[key] = append(launched[key], res)
+			continue
+		}
+		findings = append(findings, types.Finding{
+			Kind:         types.FindingUnmanaged,
+			ResourceType: res.Type,
+			ResourceName: res.Name,
+			Region:       res.Region,
+			Account:      res.Account,
+			AccountAlias: res.AccountAlias,
+			Message:      "not managed by any loaded state",
+			Resources:    []types.Resource{res},
+		})
+	}
+	for _, parent := range unmanagedParents {
+		instances := launched[parent]
+		names := make([]string, 0, len(instances))
+		for _, res := range instances {
+			names = append(names, res.Name)
+		}
+		findings = append(findings, types.Finding{
+			Kind:         types.FindingUnmanaged,
+			ResourceType: parent.Type,
+			ResourceName: parent.Name,
+			Region:       instances[0].Region,
+			Account:      instances[0].Account,
+			AccountAlias: instances[0].AccountAlias,
+			Message:      "not managed by any loaded state, nor are the instances it launched: " + strings.Join(names, ", "),
+			Resources:    instances,
+		})
+	}
+	return findings
+}
+
+// findReplacements pairs the missing and unmanaged resources among findings
+// using matchers, and reports each pair as a single replaced finding in place
+// of the missing one. Other findings are returned as they are.
+func findReplacements(findings []types.Finding, matchers []matcher.Matcher) []types.Finding {
+	if len(matchers) == 0 {
+		return findings
+	}
+
+	var missing, unmanaged []types.Resource
+	var missingAt, unmanagedAt []int
+	for i, f := range findings {
+		switch f.Kind {
+		case types.FindingMissing:
+			missing, missingAt = append(missing, f.Resources[0]), append(missingAt, i)
+		case types.FindingUnmanaged:
+			// A finding about an unmanaged parent lists its instances, none of which replaced anything alone
+			if f.ResourceType != f.Resources[0].Type {
+				continue
+			}
+			unmanaged, unmanagedAt = append(unmanaged, f.Resources[0]), append(unmanagedAt, i)
+		}
+	}
+
+	replaced := make(map[int]types.Finding)
+	paired := make(map[int]bool)
+	for _, pair := range matcher.Match(matchers, missing, unmanaged) {
+		old, new := missing[pair.Desired], unmanaged[pair.Actual]
+		replaced[missingAt[pair.Desired]] = types.Finding{
+			Kind:         types.FindingReplaced,
+			ResourceType: old.Type,
+			ResourceName: old.Name,
+			Region:       new.Region,
+			Account:      new.Account,
+			AccountAlias: new.AccountAlias,
+			Message: fmt.Sprintf("tracked in %s but replaced out of band by %s (matched by %s, %.0f%% confidence)",
+				sourceLabel(old.Source, old.Workspace), new.Name, pair.Matcher, pair.Confidence*100),
+			Confidence: pair.Confidence,
+			Resources:  []types.Resource{old, new},
+		}
+		paired[unmanagedAt[pair.Actual]] = true
+	}
+
+	var result []types.Finding
+	for i, f := range findings {
+		if paired[i] {
+			continue
+		}
+		if r, ok := replaced[i]; ok {
+			f = r
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// selectFindings keeps the findings about any of the instances in instanceIDs,
+// or all of them when none are given.
+func selectFindings(findings []types.Finding, instanceIDs []string) []types.Finding {
+	if len(instanceIDs) == 0 {
+		return findings
+	}
+
+	var selected []types.Finding
+	for _, f := range findings {
+		if len(filterByInstanceIDs(f.Resources, instanceIDs)) > 0 {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
+
+// expectedImages returns the AMI the desired state expects each instance it
+// tracks to run, by ID, and the instances launched by each resource it
+// tracks, so those AMIs are described too and their names shown in drifts.
+func expectedImages(resources []types.Resource) (map[string]string, map[types.ResourceRef]string) {
+	index := indexResources(resources)
+	expected := make(map[string]string)
+	parentExpected := make(map[types.ResourceRef]string)
+	for _, res := range resources {
+		spec := res
+		if res.Type != types.EC2Instance {
+			spec = launchSpec(res, index)
+		}
+		data, _ := spec.Data.(map[string]interface{})
+		ami, _ := data["ami"].(string)
+		switch {
+		case ami == "":
+		case res.Type == types.EC2Instance:
+			expected[res.Name] = ami
+		default:
+			parentExpected[types.ResourceRef{Type: res.Type, Name: res.Name}] = ami
+		}
+	}
+	return expected, parentExpected
+}
+
+// recordedAttributes returns the attribute keys the desired state records for
+// each instance it tracks, by ID, and for the instances launched by each
+// resource it tracks, so the cloud side only describes what is compared.
+func recordedAttributes(resources []types.Resource) (map[string][]string, map[types.ResourceRef][]string) {
+	index := indexResources(resources)
+	recorded := make(map[string][]string)
+	parentRecorded := make(map[types.ResourceRef][]string)
+	for _, res := range resources {
+		spec := res
+		if res.Type != types.EC2Instance {
+			spec = launchSpec(res, index)
+		}
+		data, _ := spec.Data.(map[string]interface{})
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if res.Type == types.EC2Instance {
+			recorded[res.Name] = keys
+		} else {
+			parentRecorded[types.ResourceRef{Type: res.Type, Name: res.Name}] = keys
+		}
+	}
+	return recorded, parentRecorded
+}
+
+// findImageFindings reports cloud instances running an AMI that is deprecated
+// or disabled as of now, or that was deregistered. Only instances whose AMIs
+// were described are considered.
+func findImageFindings(awsResources []types.Resource, now time.Time) []types.Finding {
+	var findings []types.Finding
+	for _, res := range awsResources {
+		data, ok := res.Data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		image, described := data["image"].(types.Image)
+		if !described {
+			continue
+		}
+		ami := image.ID
+
+		finding := types.Finding{
+			ResourceType: res.Type,
+			ResourceName: res.Name,
+			Region:       res.Region,
+			Account:      res.Account,
+			AccountAlias: res.AccountAlias,
+			Resources:    []types.Resource{res},
+		}
+		deprecated, err := time.Parse(time.RFC3339, image.DeprecationTime)
+		switch {
+		case image.State == "deregistered":
+			finding.Kind = types.FindingDeregisteredImage
+			finding.Message = fmt.Sprintf("runs AMI %s, which was deregistered or is no longer shared with the account", ami)
+		case image.State == "disabled":
+			finding.Kind = types.FindingDeprecatedImage
+			finding.Message = fmt.Sprintf("runs AMI %s, which is disabled", imageName(image))
+		case err == nil && !deprecated.After(now):
+			finding.Kind = types.FindingDeprecatedImage
+			finding.Message = fmt.Sprintf("runs AMI %s, deprecated since %s", imageName(image), deprecated.Format(time.DateOnly))
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// imageName formats an AMI as its ID followed by its name, when it has one.
+func imageName(image types.Image) string {
+	if image.Name == "" {
+		return image.ID
+	}
+	return fmt.Sprintf("%s (%s)", image.ID, image.Name)
+}
+
+// findDoubleManagedResources reports cloud resources claimed by more than one
+// state entry, whose owning stacks will keep overwriting each other on apply.
+// Data sources only read a resource and do not count as owners.
+func findDoubleManagedResources(tfResources []types.Resource, instanceIDs []string) []types.Finding {
+	owners := make(map[string][]types.Resource)
+	var keys []string
+	for _, res := range filterByInstanceIDs(tfResources, instanceIDs) {
+		if isDataSource(res.Address) {
+			continue
+		}
+		key := string(res.Type) + "/" + res.Name
+		if _, ok := owners[key]; !ok {
+			keys = append(keys, key)
+		}
+		owners[key] = append(owners[key], res)
+	}
+
+	var findings []types.Finding
+	for _, key := range keys {
+		resources := owners[key]
+		if len(resources) < 2 {
+			continue
+		}
+
+		claims := make([]string, 0, len(resources))
+		for _, res := range resources {
+			claims = append(claims, fmt.Sprintf("%s (%s)", res.Address, sourceLabel(res.Source, res.Workspace)))
+		}
+		findings = append(findings, types.Finding{
+			Kind:         types.FindingDoubleManaged,
+			ResourceType: resources[0].Type,
+			ResourceName: resources[0].Name,
+			Region:       resources[0].Region,
+			Account:      resources[0].Account,
+			AccountAlias: resources[0].AccountAlias,
+			Message:      fmt.Sprintf("managed by %d state entries: %s", len(resources), strings.Join(claims, ", ")),
+			Resources:    resources,
+		})
+	}
+	return findings
+}
+
+// indexResources indexes resources by type and name for findMatch.
+func indexResources(resources []types.Resource) map[string][]types.Resource {
+	index := make(map[string][]types.Resource)
+	for _, res := range resources {
+		key := string(res.Type) + "/" + res.Name
+		index[key] = append(index[key], res)
+	}
+	return index
+}
+
+// findMatch returns the indexed resource with the same type and name as res
+// in the same account and region. An unknown account or region matches any.
+func findMatch(index map[string][]types.Resource, res types.Resource) (types.Resource, bool) {
+	for _, candidate := range index[string(res.Type)+"/"+res.Name] {
+		if sameLocation(candidate.Region, res.Region) && sameLocation(candidate.Account, res.Account) {
+			return candidate, true
+		}
+	}
+	return types.Resource{}, false
+}
+
+// sameLocation reports whether two regions or accounts may be the same one.
+func sameLocation(a, b string) bool {
+	return a == "" || b == "" || a == b
+}
+
+// isDataSource reports whether a Terraform address refers to a data source.
+func isDataSource(address string) bool {
+	return strings.HasPrefix(address, "data.") || strings.Contains(address, ".data.")
+}
+
+// filterByInstanceIDs keeps the resources named in instanceIDs, or all of them when none are given.
+func filterByInstanceIDs(resources []types.Resource, instanceIDs []string) []types.Resource {
+	if len(instanceIDs) == 0 {
+		return resources
+	}
+
+	idSet := make(map[string]struct{})
+	for _, id := range instanceIDs {
+		idSet[id] = struct{}{}
+	}
+
+	var filtered []types.Resource
+	for _, res := range resources {
+		if _, ok := idSet[res.Name]; ok {
+			filtered = append(filtered, res)
+		}
+	}
+	return filtered
+}
+
+// sourceLabel formats a state source and workspace for display.
+func sourceLabel(source, workspace string) string {
+	return state.File{Path: source, Workspace: workspace}.Label()
+}
+
+// runCompare executes the comparison and prints results
+func runCompare(ctx context.Context, config *AppConfig) error {
 	tfResources, awsResources, err := loadConfigs(ctx, config)
 	if err != nil {
 		fmt.Println(err)
@@ -171,16 +972,83 @@ func runCompare(config *AppConfig) error {
 	}
 
 	driftResults := compareResources(tfResources, awsResources, config.Comparator, config.Logger, config.Options.InstanceIDs)
+	if !config.Options.IncludeOperational {
+		driftResults = withoutOperational(driftResults)
+	}
 
 	for resourceType, groups := range driftResults {
 		for _, group := range groups {
-			config.DriftPrinter.PrintDrifts(resourceType, group.ResourceName, group.Drifts)
+			config.DriftPrinter.PrintDrifts(resourceType, groupLabel(config.Options, group), group.Drifts)
+		}
+	}
+
+	findings := findDoubleManagedResources(tfResources, config.Options.InstanceIDs)
+	unmatched := findReplacements(findUnmatchedResources(tfResources, awsResources), config.Matchers)
+	findings = append(findings, selectFindings(unmatched, config.Options.InstanceIDs)...)
+	findings = append(findings, findImageFindings(filterByInstanceIDs(awsResources, config.Options.InstanceIDs), time.Now())...)
+	if len(findings) > 0 {
+		config.DriftPrinter.PrintFindings(findings)
+	}
+
+	if config.RetryStats != nil {
+		if summary := config.RetryStats.Summary(); len(summary) > 0 {
+			config.DriftPrinter.PrintRetrySummary(summary)
 		}
 	}
 
 	return nil
 }
 
+// groupLabel names a drifted resource, qualified by its account, region and
+// state when several of them are compared at once.
+func groupLabel(opts *CompareOptions, group types.DriftGroup) string {
+	var qualifiers []string
+	if group.Parent != nil {
+		qualifiers = append(qualifiers, "managed by "+group.Parent.String())
+	}
+	if opts.AccountsPath != "" && group.Account != "" {
+		qualifiers = append(qualifiers, common.AccountLabel(group.Account, group.AccountAlias))
+	}
+	if (opts.AllRegions || len(opts.Regions) > 1) && group.Region != "" {
+		qualifiers = append(qualifiers, group.Region)
+	}
+	if opts.TFDir != "" || opts.TerragruntDir != "" {
+		qualifiers = append(qualifiers, sourceLabel(group.Source, group.Workspace))
+	}
+	if len(qualifiers) == 0 {
+		return group.ResourceName
+	}
+	return fmt.Sprintf("%s (%s)", group.ResourceName, strings.Join(qualifiers, ", "))
+}
+
+// parsedResourceTypes are the resource types read from the desired state: the
+// compared ones and those whose launch specification their instances are
+// compared against.
+func parsedResourceTypes() []types.ResourceType {
+	return append(drift.SupportedResourceTypes(), parser.LaunchSpecTypes()...)
+}
+
+// newParser builds the parser for the desired-state source selected in opts.
+func newParser(opts *CompareOptions, reader file.FileReader) (parser.Parser, error) {
+	supported := parsedResourceTypes()
+	if common.SourceType(opts.Source) != common.SourceCloudFormation {
+		return parser.NewSourceParser(common.SourceType(opts.Source), supported...)
+	}
+
+	if opts.CFNStackResources == "" {
+		return nil, fmt.Errorf("the cloudformation source requires --cfn-stack-resources")
+	}
+	data, err := reader.ReadFile(opts.CFNStackResources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stack resources: %w", err)
+	}
+	physicalIDs, err := parser.ParseStackResources(data)
+	if err != nil {
+		return nil, err
+	}
+	return parser.NewCloudFormationParser(physicalIDs, supported...), nil
+}
+
 // NewCompareCmd creates the Cobra command
 func NewCompareCmd() *cobra.Command {
 	opts := &CompareOptions{}
@@ -189,15 +1057,7 @@ func NewCompareCmd() *cobra.Command {
 	log := logger.NewLogger()
 	log.Info("Initializing app")
 
-	awsSession, err := session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Config:            aws.Config{Region: aws.String("us-west-2")},
-	})
-	if err != nil {
-		log.Error("Failed to create AWS session: %w", err)
-	}
-
-	config := NewAppConfig(outputType, log, opts, awsSession)
+	config := NewAppConfig(outputType, log, opts)
 
 	compareCmd := &cobra.Command{
 		Use:   "compare",
@@ -205,16 +1065,68 @@ func NewCompareCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config.OutputType = common.OutputType(cmd.Flag("output").Value.String())
 			config.DriftPrinter = printer.NewPrinter(config.OutputType)
-			return runCompare(config)
+			p, err := newParser(opts, config.FileReader)
+			if err != nil {
+				return err
+			}
+			config.Parser = p
+			config.Matchers, err = matcher.Parse(opts.Match)
+			if err != nil {
+				return err
+			}
+			// Findings carry whole resources, whose sensitive attributes are hashed even without --redact
+			redactor, err := redact.NewRedactor(opts.RedactPaths, opts.RedactPatterns)
+			if err != nil {
+				return err
+			}
+			config.DriftPrinter = printer.NewRedactingPrinter(config.DriftPrinter, redactor)
+			// Cancel in-flight AWS calls on Ctrl-C or when the timeout expires
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if opts.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+				defer cancel()
+			}
+			return runCompare(ctx, config)
 		},
 	}
 
-	compareCmd.Flags().StringSliceVarP(&opts.InstanceIDs, "instance-ids", "i", []string{}, "AWS EC2 instance IDs (comma-separated or multiple flags)")
+	compareCmd.Flags().StringSliceVarP(&opts.InstanceIDs, "instance-ids", "i", []string{}, "AWS EC2 instance IDs (comma-separated or multiple flags); all instances when omitted")
+	compareCmd.Flags().StringArrayVar(&opts.Tags, "tag", []string{}, "Only compare instances with this tag, as Key=Value or Key (repeatable; repeated keys match any value)")
+	compareCmd.Flags().BoolVar(&opts.IncludeTerminated, "include-terminated", false, "Include terminated instances, which AWS keeps listing for a while")
+	compareCmd.Flags().BoolVar(&opts.IncludeOperational, "include-operational", false, "Also report operational changes, such as a stopped instance or a new public IP after a stop/start")
+	compareCmd.Flags().StringSliceVar(&opts.Regions, "regions", []string{}, "AWS regions to scan concurrently (comma-separated); the session's region when omitted")
+	compareCmd.Flags().BoolVar(&opts.AllRegions, "all-regions", false, "Scan every region enabled for the account")
+	compareCmd.Flags().StringVar(&opts.AccountsPath, "accounts", "", "YAML or JSON file of accounts (id, alias, role_arn, external_id, session_name, regions) scanned by assuming their roles")
+	compareCmd.Flags().StringVar(&opts.Profile, "profile", "", "AWS shared config profile (env "+awssession.EnvProfile+")")
+	compareCmd.Flags().StringVar(&opts.Region, "region", "", "AWS region of the session (env "+awssession.EnvRegion+"); defaults to AWS_REGION, the profile's region or "+awssession.DefaultRegion)
+	compareCmd.Flags().StringVar(&opts.EndpointURL, "endpoint-url", "", "Send AWS calls to this endpoint, e.g. a local AWS emulator (env "+awssession.EnvEndpointURL+")")
+	compareCmd.Flags().StringVar(&opts.ConfigPath, "config", "", "YAML config file whose aws section sets profile, region and endpoint_url (env "+envConfigFile+")")
+	compareCmd.Flags().IntVar(&opts.MaxRetries, "max-retries", resilience.DefaultMaxRetries, "Retries of a throttled or transiently failing AWS call, with jittered exponential backoff")
+	compareCmd.Flags().Float64Var(&opts.RateLimit, "rate-limit", resilience.DefaultRateLimit, "Maximum AWS requests per second to each service in each region (0 for no limit)")
+	compareCmd.Flags().DurationVar(&opts.CallTimeout, "call-timeout", resilience.DefaultCallTimeout, "Abort and retry an AWS call attempt whose response takes longer than this to start (0 for no limit)")
+	compareCmd.Flags().DurationVar(&opts.Timeout, "timeout", 0, "Abort the comparison after this long, e.g. 5m (no limit by default)")
+	compareCmd.Flags().StringVar(&opts.TFDir, "tf-dir", "", "Directory to scan for *.tfstate files and terraform.tfstate.d workspaces")
+	compareCmd.Flags().StringVar(&opts.TerragruntDir, "terragrunt-dir", "", "Terragrunt live repository whose stacks' remote_state backends are read")
 	compareCmd.Flags().StringVarP(&opts.AWSPath, "aws-json", "j", "", "Path to sample AWS EC2 JSON file")
-	compareCmd.Flags().StringVarP(&opts.TFPath, "tf-path", "t", "", "Path to Terraform HCL or state file (required)")
+	compareCmd.Flags().StringVarP(&opts.TFPath, "tf-path", "t", "", "Path to Terraform state file, or an s3://, http(s):// or tfe:// state URL")
+	compareCmd.Flags().StringVar(&opts.Source, "source", string(common.SourceTerraform), "Desired-state format of --tf-path (terraform, pulumi, cloudformation)")
+	compareCmd.Flags().StringVar(&opts.CFNStackResources, "cfn-stack-resources", "", "Output of aws cloudformation describe-stack-resources, mapping template logical IDs to physical IDs")
+	compareCmd.Flags().StringSliceVar(&opts.RedactPaths, "redact", []string{}, "Attribute paths whose values are hashed in output, e.g. user_data or tags.*Token")
+	compareCmd.Flags().StringSliceVar(&opts.RedactPatterns, "redact-pattern", []string{}, "Regular expressions whose matches are masked in output values")
+	compareCmd.Flags().StringSliceVar(&opts.Match, "match", []string{}, "Matchers that recognize instances replaced out of band, tried in order: arn, tag:<key> (e.g. tag:Name) or fuzzy[:<threshold>]")
 	compareCmd.Flags().String("output", "console", "Output format (console, json, diff, html, etc)")
-	compareCmd.MarkFlagRequired("instance-ids")
-	compareCmd.MarkFlagRequired("tf-path")
+	compareCmd.Flags().SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		// --tf-state reads better when pointing at a remote backend
+		if name == "tf-state" {
+			name = "tf-path"
+		}
+		return pflag.NormalizedName(name)
+	})
+	compareCmd.MarkFlagsOneRequired("tf-path", "tf-dir", "terragrunt-dir")
+	compareCmd.MarkFlagsMutuallyExclusive("tf-path", "tf-dir", "terragrunt-dir")
+	compareCmd.MarkFlagsMutuallyExclusive("regions", "all-regions")
 
 	return compareCmd
 }
@@ -4,17 +4,27 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/papidb/drift-detector/internal/drift-detectors"
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/cloud/aws/awssession"
 	"github.com/papidb/drift-detector/pkg/cloud/aws/repository"
 	"github.com/papidb/drift-detector/pkg/common"
 	"github.com/papidb/drift-detector/pkg/file"
 	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/papidb/drift-detector/pkg/matcher"
+	"github.com/papidb/drift-detector/pkg/parser"
 	"github.com/papidb/drift-detector/pkg/printer"
+	"github.com/papidb/drift-detector/pkg/state"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,6 +44,14 @@ func (m *MockFileReader) ReadFile(path string) ([]byte, error) {
 	return nil, errors.New("file not found")
 }
 
+func (m *MockFileReader) Open(path string) (io.ReadCloser, error) {
+	data, err := m.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
 // MockPrinter is a mock implementation of printer.Printer
 type MockPrinter struct {
 	Calls []struct {
@@ -41,6 +59,8 @@ type MockPrinter struct {
 		ResourceName string
 		Drifts       []types.Drift
 	}
+	Findings []types.Finding
+	Retries  []types.RetryStat
 }
 
 func (m *MockPrinter) PrintDrifts(resourceType types.ResourceType, resourceName string, drifts []types.Drift) {
@@ -51,6 +71,14 @@ func (m *MockPrinter) PrintDrifts(resourceType types.ResourceType, resourceName
 	}{resourceType, resourceName, drifts})
 }
 
+func (m *MockPrinter) PrintFindings(findings []types.Finding) {
+	m.Findings = append(m.Findings, findings...)
+}
+
+func (m *MockPrinter) PrintRetrySummary(stats []types.RetryStat) {
+	m.Retries = append(m.Retries, stats...)
+}
+
 // MockParser is a mock implementation of parser.Parser
 type MockParser struct {
 	Resources []types.Resource
@@ -61,6 +89,10 @@ func (m *MockParser) ParseTerraformStateFile(data []byte) ([]types.Resource, err
 	return m.Resources, m.Err
 }
 
+func (m *MockParser) ParseTerraformStateStream(r io.Reader) ([]types.Resource, error) {
+	return m.Resources, m.Err
+}
+
 // MockDriftComparator is a mock implementation of drift.DriftComparator
 type MockDriftComparator struct {
 	Drifts []types.Drift
@@ -75,9 +107,11 @@ func (m *MockDriftComparator) CompareEC2Configs(old, new types.Resource) ([]type
 type MockEC2Repository struct {
 	Resources []types.Resource
 	Err       error
+	Filter    repository.InstanceFilter
 }
 
-func (m *MockEC2Repository) ListInstances(ctx context.Context) ([]types.Resource, error) {
+func (m *MockEC2Repository) ListInstances(ctx context.Context, filter repository.InstanceFilter) ([]types.Resource, error) {
+	m.Filter = filter
 	return m.Resources, m.Err
 }
 
@@ -203,8 +237,8 @@ func TestLoadConfigs(t *testing.T) {
 				},
 			},
 			expectedTF: []types.Resource{
-				{Name: "i-123", Type: types.ResourceType("aws_instance")},
-				{Name: "i-456", Type: types.ResourceType("aws_instance")},
+				{Name: "i-123", Type: types.ResourceType("aws_instance"), Source: "terraform.tfstate", Workspace: "default"},
+				{Name: "i-456", Type: types.ResourceType("aws_instance"), Source: "terraform.tfstate", Workspace: "default"},
 			},
 			expectedAWS: []types.Resource{
 				{Name: "i-123", Type: types.ResourceType("aws_instance")},
@@ -233,7 +267,7 @@ func TestLoadConfigs(t *testing.T) {
 				Err: errors.New("invalid state"),
 			},
 			ec2Repo:        &MockEC2Repository{},
-			expectedErrMsg: "failed to parse tf config: invalid state",
+			expectedErrMsg: `failed to parse tf config "terraform.tfstate": invalid state`,
 		},
 		{
 			name: "AWS fetch error",
@@ -255,8 +289,9 @@ func TestLoadConfigs(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			config.FileReader = tt.fileReader
+			config.StateSource = state.NewSource(tt.fileReader, nil)
 			config.Parser = tt.parser
-			config.EC2RepoFactory = func(_ *session.Session, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			config.EC2RepoFactory = func(_ *session.Session, _ repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
 				return tt.ec2Repo
 			}
 
@@ -277,6 +312,632 @@ func TestLoadConfigs(t *testing.T) {
 	}
 }
 
+func TestLoadConfigsWithTagSelectors(t *testing.T) {
+	web := types.NewResource("i-123", types.EC2Instance, map[string]interface{}{"tags": map[string]string{"Team": "web"}})
+	api := types.NewResource("i-456", types.EC2Instance, map[string]interface{}{"tags": map[string]string{"Team": "api"}})
+	reader := &MockFileReader{Data: map[string][]byte{"terraform.tfstate": []byte(`{}`)}}
+	ec2Repo := &MockEC2Repository{Resources: []types.Resource{web}}
+
+	config := &AppConfig{
+		Logger:      &MockLogger{},
+		Options:     &CompareOptions{TFPath: "terraform.tfstate", InstanceIDs: []string{"i-123", "i-456"}, Tags: []string{"Team=web"}},
+		FileReader:  reader,
+		StateSource: state.NewSource(reader, nil),
+		Parser:      &MockParser{Resources: []types.Resource{web, api}},
+		EC2RepoFactory: func(_ *session.Session, _ repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			return ec2Repo
+		},
+	}
+
+	tfResources, _, err := loadConfigs(context.Background(), config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, repository.InstanceFilter{
+		InstanceIDs:          []string{"i-123", "i-456"},
+		Tags:                 map[string][]string{"Team": {"web"}},
+		ExpectedImages:       map[string]string{},
+		ParentExpectedImages: map[types.ResourceRef]string{},
+		Recorded:             map[string][]string{"i-123": {"tags"}},
+		ParentRecorded:       map[types.ResourceRef][]string{},
+	}, ec2Repo.Filter)
+	assert.Len(t, tfResources, 1, "the desired side is held to the same selection")
+	assert.Equal(t, "i-123", tfResources[0].Name)
+
+	config.Matchers = []matcher.Matcher{matcher.NewTagMatcher("Name")}
+	_, _, err = loadConfigs(context.Background(), config)
+	assert.NoError(t, err)
+	assert.Nil(t, ec2Repo.Filter.InstanceIDs, "replacements have IDs of their own")
+
+	config.Options.Tags = []string{"=web"}
+	_, _, err = loadConfigs(context.Background(), config)
+	assert.ErrorContains(t, err, "invalid tag selector")
+}
+
+// selectingEC2Repository lists the instances that pass each filter it is given
+type selectingEC2Repository struct {
+	Resources []types.Resource
+	Filters   []repository.InstanceFilter
+}
+
+func (m *selectingEC2Repository) ListInstances(ctx context.Context, filter repository.InstanceFilter) ([]types.Resource, error) {
+	m.Filters = append(m.Filters, filter)
+	return filter.Select(m.Resources), nil
+}
+
+func TestLoadConfigsDescribesRetaggedInstancesByID(t *testing.T) {
+	tracked := types.NewResource("i-123", types.EC2Instance, map[string]interface{}{"tags": map[string]string{"Env": "prod"}})
+	retagged := types.NewResource("i-123", types.EC2Instance, map[string]interface{}{"tags": map[string]string{"Env": "dev"}})
+	reader := &MockFileReader{Data: map[string][]byte{"terraform.tfstate": []byte(`{}`)}}
+	ec2Repo := &selectingEC2Repository{Resources: []types.Resource{retagged}}
+
+	config := &AppConfig{
+		Logger:      &MockLogger{},
+		Options:     &CompareOptions{TFPath: "terraform.tfstate", Tags: []string{"Env=prod"}},
+		FileReader:  reader,
+		StateSource: state.NewSource(reader, nil),
+		Parser:      &MockParser{Resources: []types.Resource{tracked}},
+		EC2RepoFactory: func(_ *session.Session, _ repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			return ec2Repo
+		},
+	}
+
+	tfResources, awsResources, err := loadConfigs(context.Background(), config)
+
+	assert.NoError(t, err)
+	assert.Len(t, ec2Repo.Filters, 2)
+	assert.Equal(t, []string{"i-123"}, ec2Repo.Filters[1].InstanceIDs)
+	assert.Nil(t, ec2Repo.Filters[1].Tags)
+	assert.Equal(t, []types.Resource{retagged}, awsResources)
+	assert.Empty(t, findUnmatchedResources(tfResources, awsResources), "a changed tag is drift, not a missing instance")
+}
+
+func TestRecordedAttributes(t *testing.T) {
+	resources := []types.Resource{
+		types.NewResource("i-1", types.EC2Instance, map[string]interface{}{"user_data": "", "ami": "ami-1"}),
+		types.NewResource("web-asg", types.AutoScalingGroup, map[string]interface{}{"launch_configuration": "web-lc"}),
+		types.NewResource("web-lc", types.LaunchConfiguration, map[string]interface{}{"instance_type": "t3.micro"}),
+	}
+
+	recorded, parentRecorded := recordedAttributes(resources)
+
+	assert.Equal(t, map[string][]string{"i-1": {"ami", "user_data"}}, recorded)
+	assert.Equal(t, []string{"instance_type"}, parentRecorded[types.ResourceRef{Type: types.AutoScalingGroup, Name: "web-asg"}],
+		"a group using a launch configuration records what the configuration does")
+}
+
+func TestResolveRegionsPerAccount(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "base")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "base")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+
+	// Each set of credentials sees the regions enabled for its account
+	enabled := map[string][]string{"base": {"us-east-1"}, "assumed": {"eu-west-1", "ap-south-1"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "text/xml")
+		if r.PostForm.Get("Action") == "AssumeRole" {
+			_, _ = w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>
+  <Credentials><AccessKeyId>assumed</AccessKeyId><SecretAccessKey>s</SecretAccessKey><SessionToken>t</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials>
+  <AssumedRoleUser><Arn>arn:aws:sts::222222222222:assumed-role/drift-detector/drift-detector</Arn><AssumedRoleId>id</AssumedRoleId></AssumedRoleUser>
+</AssumeRoleResult></AssumeRoleResponse>`))
+			return
+		}
+		accessKey, _, _ := strings.Cut(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="), "/")
+		var items strings.Builder
+		for _, region := range enabled[accessKey] {
+			items.WriteString("<item><regionName>" + region + "</regionName></item>")
+		}
+		_, _ = w.Write([]byte(`<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><regionInfo>` + items.String() + `</regionInfo></DescribeRegionsResponse>`))
+	}))
+	defer server.Close()
+
+	sess, err := awssession.New(awssession.Options{Region: "us-east-1", EndpointURL: server.URL})
+	assert.NoError(t, err)
+	config := &AppConfig{Options: &CompareOptions{AllRegions: true}}
+	accounts := []repository.Account{
+		{ID: "222222222222", RoleARN: "arn:aws:iam::222222222222:role/drift-detector", SessionName: "drift-detector"},
+		{ID: "333333333333", RoleARN: "arn:aws:iam::333333333333:role/drift-detector", SessionName: "drift-detector", Regions: []string{"us-west-2"}},
+	}
+
+	regions, resolved, err := resolveRegions(context.Background(), config, sess, accounts)
+	assert.NoError(t, err)
+	assert.Nil(t, regions, "every account scans its own regions")
+	assert.Equal(t, []string{"ap-south-1", "eu-west-1"}, resolved[0].Regions, "listed with the account's role")
+	assert.Equal(t, []string{"us-west-2"}, resolved[1].Regions, "an account's own regions are kept")
+	assert.Nil(t, accounts[0].Regions, "the loaded accounts are left as they are")
+
+	regions, _, err = resolveRegions(context.Background(), config, sess, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1"}, regions)
+}
+
+func TestLoadConfigsWithRegions(t *testing.T) {
+	east := types.Resource{Name: "i-123", Type: types.EC2Instance, Region: "us-east-1"}
+	west := types.Resource{Name: "i-456", Type: types.EC2Instance, Region: "eu-west-1"}
+	tokyo := types.Resource{Name: "i-789", Type: types.EC2Instance, Region: "ap-northeast-1"}
+	unknown := types.Resource{Name: "i-000", Type: types.EC2Instance}
+	reader := &MockFileReader{Data: map[string][]byte{"terraform.tfstate": []byte(`{}`)}}
+
+	var scanned []string
+	config := &AppConfig{
+		Logger:      &MockLogger{},
+		Options:     &CompareOptions{TFPath: "terraform.tfstate", Regions: []string{"us-east-1", "eu-west-1"}},
+		FileReader:  reader,
+		StateSource: state.NewSource(reader, nil),
+		Parser:      &MockParser{Resources: []types.Resource{east, west, tokyo, unknown}},
+		EC2RepoFactory: func(_ *session.Session, scope repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			scanned = scope.Regions
+			return &MockEC2Repository{Resources: []types.Resource{east, west}}
+		},
+	}
+
+	tfResources, awsResources, err := loadConfigs(context.Background(), config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1", "eu-west-1"}, scanned)
+	var names []string
+	for _, res := range tfResources {
+		names = append(names, res.Name)
+	}
+	assert.Equal(t, []string{"i-123", "i-456", "i-000"}, names, "state in regions that were not scanned is left out")
+	assert.Equal(t, []types.Resource{east, west}, awsResources)
+
+	t.Run("all regions are not enumerated for an AWS JSON file", func(t *testing.T) {
+		config.Options = &CompareOptions{TFPath: "terraform.tfstate", AWSPath: "aws.json", AllRegions: true}
+
+		tfResources, _, err := loadConfigs(context.Background(), config)
+
+		assert.NoError(t, err)
+		assert.Nil(t, scanned)
+		assert.Len(t, tfResources, 4)
+	})
+}
+
+func TestLoadConfigsWithAccounts(t *testing.T) {
+	prod := types.Resource{Name: "i-123", Type: types.EC2Instance, Account: "111111111111", Region: "us-east-1"}
+	staging := types.Resource{Name: "i-456", Type: types.EC2Instance, Account: "222222222222", Region: "eu-west-1"}
+	other := types.Resource{Name: "i-789", Type: types.EC2Instance, Account: "333333333333", Region: "us-east-1"}
+	reader := &MockFileReader{Data: map[string][]byte{
+		"terraform.tfstate": []byte(`{}`),
+		"accounts.yaml": []byte(`
+accounts:
+  - role_arn: arn:aws:iam::111111111111:role/drift-detector
+  - role_arn: arn:aws:iam::222222222222:role/drift-detector
+    regions: [eu-west-1]
+`),
+	}}
+
+	var scope repository.Scope
+	config := &AppConfig{
+		Logger:      &MockLogger{},
+		Options:     &CompareOptions{TFPath: "terraform.tfstate", AccountsPath: "accounts.yaml", Regions: []string{"us-east-1"}},
+		FileReader:  reader,
+		StateSource: state.NewSource(reader, nil),
+		Parser:      &MockParser{Resources: []types.Resource{prod, staging, other}},
+		EC2RepoFactory: func(_ *session.Session, s repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			scope = s
+			return &MockEC2Repository{}
+		},
+	}
+
+	tfResources, _, err := loadConfigs(context.Background(), config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1"}, scope.Regions)
+	assert.Len(t, scope.Accounts, 2)
+	assert.Equal(t, []string{"eu-west-1"}, scope.Accounts[1].Regions)
+	var names []string
+	for _, res := range tfResources {
+		names = append(names, res.Name)
+	}
+	assert.Equal(t, []string{"i-123", "i-456"}, names, "state of accounts that were not scanned is left out")
+
+	config.Options.AccountsPath = "missing.yaml"
+	_, _, err = loadConfigs(context.Background(), config)
+	assert.ErrorContains(t, err, "failed to read accounts")
+}
+
+// failingSessions fails the test if a session is requested.
+type failingSessions struct{ t *testing.T }
+
+func (f failingSessions) Session() (*session.Session, error) {
+	f.t.Error("unexpected AWS session")
+	return nil, errors.New("unexpected AWS session")
+}
+
+func TestLoadConfigsSessionIsLazy(t *testing.T) {
+	reader := &MockFileReader{Data: map[string][]byte{"terraform.tfstate": []byte(`{}`)}}
+	config := &AppConfig{
+		Logger:      &MockLogger{},
+		Options:     &CompareOptions{TFPath: "terraform.tfstate", AWSPath: "aws.json", AllRegions: true},
+		Sessions:    failingSessions{t},
+		FileReader:  reader,
+		StateSource: state.NewSource(reader, nil),
+		Parser:      &MockParser{},
+		EC2RepoFactory: func(sess *session.Session, _ repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			assert.Nil(t, sess)
+			return &MockEC2Repository{}
+		},
+	}
+
+	_, _, err := loadConfigs(context.Background(), config)
+	assert.NoError(t, err)
+
+	config.Options.AWSPath = ""
+	config.Sessions = awssession.NewLazyProvider(func() (awssession.Options, error) {
+		return awssession.Options{}, errors.New("failed to read config file: missing")
+	})
+	_, _, err = loadConfigs(context.Background(), config)
+	assert.EqualError(t, err, "failed to read config file: missing")
+}
+
+func TestSessionOptions(t *testing.T) {
+	t.Setenv(awssession.EnvProfile, "env-profile")
+	t.Setenv(awssession.EnvRegion, "env-region")
+	t.Setenv(awssession.EnvEndpointURL, "")
+	t.Setenv(envConfigFile, "drift.yaml")
+	reader := &MockFileReader{Data: map[string][]byte{
+		"drift.yaml": []byte("aws:\n  region: file-region\n  endpoint_url: http://localhost:4566\n"),
+	}}
+
+	options, err := sessionOptions(&CompareOptions{Profile: "flag-profile"}, reader)
+	assert.NoError(t, err)
+	assert.Equal(t, awssession.Options{
+		Profile:     "flag-profile",
+		Region:      "env-region",
+		EndpointURL: "http://localhost:4566",
+	}, options, "flags win over the environment, which wins over the config file")
+
+	_, err = sessionOptions(&CompareOptions{ConfigPath: "missing.yaml"}, reader)
+	assert.ErrorContains(t, err, "failed to read config file")
+}
+
+func TestLoadConfigsFromDirectory(t *testing.T) {
+	root := t.TempDir()
+	writeState := func(path, instanceID string) {
+		full := filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		state := `{"resources": [{"type": "aws_instance", "instances": [{"attributes": {"id": "` + instanceID + `"}}]}]}`
+		assert.NoError(t, os.WriteFile(full, []byte(state), 0o644))
+	}
+	writeState("network/terraform.tfstate", "i-123")
+	writeState("app/terraform.tfstate.d/staging/terraform.tfstate", "i-456")
+
+	reader := &file.OSFileReader{}
+	config := &AppConfig{
+		Logger:      &MockLogger{},
+		Options:     &CompareOptions{TFDir: root},
+		FileReader:  reader,
+		StateSource: state.NewSource(reader, nil),
+		Parser:      parser.NewParser(types.EC2Instance),
+		EC2RepoFactory: func(_ *session.Session, _ repository.Scope, _ string, _ file.FileReader, _ logger.Logger) repository.EC2Repository {
+			return &MockEC2Repository{}
+		},
+	}
+
+	tfResources, _, err := loadConfigs(context.Background(), config)
+
+	assert.NoError(t, err)
+	sources := map[string]string{}
+	for _, res := range tfResources {
+		sources[res.Name] = res.Source + "|" + res.Workspace
+	}
+	assert.Equal(t, map[string]string{
+		"i-123": filepath.Join(root, "network/terraform.tfstate") + "|default",
+		"i-456": filepath.Join(root, "app/terraform.tfstate.d/staging/terraform.tfstate") + "|staging",
+	}, sources)
+
+	t.Run("broken state fails the run", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "broken.tfstate"), []byte(`{`), 0o644))
+		defer os.Remove(filepath.Join(root, "broken.tfstate"))
+
+		_, _, err := loadConfigs(context.Background(), config)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "broken.tfstate")
+	})
+}
+
+func TestCompareManagedInstances(t *testing.T) {
+	asg := &types.ResourceRef{Type: types.AutoScalingGroup, Name: "web-asg"}
+	tfResources := []types.Resource{
+		{Name: "web-asg", Type: types.AutoScalingGroup, Source: "a.tfstate", Workspace: "default", Data: map[string]interface{}{"launch_configuration": "web-lc"}},
+		{Name: "web-lc", Type: types.LaunchConfiguration, Data: map[string]interface{}{"ami": "ami-1", "instance_type": "t3.micro"}},
+		{Name: "i-tracked", Type: types.EC2Instance, Data: map[string]interface{}{"instance_type": "t3.micro"}},
+	}
+	awsResources := []types.Resource{
+		{Name: "i-1", Type: types.EC2Instance, Parent: asg, Data: map[string]interface{}{"ami": "ami-1", "instance_type": "t3.large"}},
+		{Name: "i-2", Type: types.EC2Instance, Parent: asg, Data: map[string]interface{}{"ami": "ami-1", "instance_type": "t3.micro"}},
+		{Name: "i-3", Type: types.EC2Instance, Parent: &types.ResourceRef{Type: types.AutoScalingGroup, Name: "other-asg"}, Data: map[string]interface{}{"instance_type": "t3.large"}},
+		{Name: "i-tracked", Type: types.EC2Instance, Parent: asg, Data: map[string]interface{}{"ami": "ami-2", "instance_type": "t3.micro"}},
+	}
+
+	groups := compareManagedInstances(tfResources, awsResources, drift.NewDriftComparator(), &MockLogger{})
+	assert.Equal(t, []types.DriftGroup{{
+		ResourceName: "i-1",
+		Drifts:       []types.Drift{{Name: "instance_type", OldValue: "t3.micro", NewValue: "t3.large"}},
+		Source:       "a.tfstate",
+		Workspace:    "default",
+		Parent:       asg,
+	}}, groups, "instances tracked on their own or launched by an untracked parent are left out")
+}
+
+func TestFindUnmatchedResourcesManagedInstances(t *testing.T) {
+	asg := &types.ResourceRef{Type: types.AutoScalingGroup, Name: "web-asg"}
+	pool := &types.ResourceRef{Type: types.AutoScalingGroup, Name: "pool"}
+	tfResources := []types.Resource{{Name: "web-asg", Type: types.AutoScalingGroup}}
+	awsResources := []types.Resource{
+		{Name: "i-1", Type: types.EC2Instance, Parent: asg},
+		{Name: "i-2", Type: types.EC2Instance, Parent: pool, Region: "eu-west-1"},
+		{Name: "i-3", Type: types.EC2Instance, Parent: pool, Region: "eu-west-1"},
+	}
+
+	assert.Equal(t, []types.Finding{{
+		Kind:         types.FindingUnmanaged,
+		ResourceType: types.AutoScalingGroup,
+		ResourceName: "pool",
+		Region:       "eu-west-1",
+		Message:      "not managed by any loaded state, nor are the instances it launched: i-2, i-3",
+		Resources:    awsResources[1:],
+	}}, findUnmatchedResources(tfResources, awsResources))
+
+	assert.Empty(t, selectFindings(findUnmatchedResources(tfResources, awsResources), []string{"i-1"}), "parents are found whichever instances are selected")
+
+	t.Run("same parent name in another region", func(t *testing.T) {
+		other := types.Resource{Name: "i-4", Type: types.EC2Instance, Parent: pool, Region: "us-east-1"}
+		findings := findUnmatchedResources(tfResources, append(awsResources[1:], other))
+		if assert.Len(t, findings, 2) {
+			assert.Equal(t, "eu-west-1", findings[0].Region)
+			assert.Equal(t, awsResources[1:], findings[0].Resources)
+			assert.Equal(t, "us-east-1", findings[1].Region)
+			assert.Equal(t, []types.Resource{other}, findings[1].Resources)
+		}
+	})
+}
+
+func TestSelectInstances(t *testing.T) {
+	resources := []types.Resource{
+		{Name: "i-1", Type: types.EC2Instance},
+		{Name: "i-2", Type: types.EC2Instance},
+		{Name: "web-asg", Type: types.AutoScalingGroup},
+	}
+	filter := repository.InstanceFilter{InstanceIDs: []string{"i-2"}}
+
+	assert.Equal(t, resources[1:], selectInstances(filter, resources))
+}
+
+func TestFindUnmatchedResources(t *testing.T) {
+	tfResources := []types.Resource{
+		{Name: "i-123", Type: types.EC2Instance, Source: "a.tfstate", Workspace: "default"},
+		{Name: "i-456", Type: types.EC2Instance, Source: "b.tfstate", Workspace: "staging"},
+		{Name: "sg-1", Type: types.ResourceType("aws_security_group"), Source: "a.tfstate"},
+	}
+	awsResources := []types.Resource{
+		{Name: "i-123", Type: types.EC2Instance},
+		{Name: "i-789", Type: types.EC2Instance},
+	}
+
+	tests := []struct {
+		name        string
+		instanceIDs []string
+		expected    []types.Finding
+	}{
+		{
+			name: "missing and unmanaged",
+			expected: []types.Finding{
+				{
+					Kind:         types.FindingMissing,
+					ResourceType: types.EC2Instance,
+					ResourceName: "i-456",
+					Message:      "tracked in b.tfstate [staging] but not found in the cloud",
+					Resources:    []types.Resource{tfResources[1]},
+				},
+				{
+					Kind:         types.FindingUnmanaged,
+					ResourceType: types.EC2Instance,
+					ResourceName: "i-789",
+					Message:      "not managed by any loaded state",
+					Resources:    []types.Resource{awsResources[1]},
+				},
+			},
+		},
+		{
+			name:        "filtered to a matched instance",
+			instanceIDs: []string{"i-123"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, selectFindings(findUnmatchedResources(tfResources, awsResources), tt.instanceIDs))
+		})
+	}
+}
+
+func TestFindReplacements(t *testing.T) {
+	old := types.Resource{
+		Name:   "i-123",
+		Type:   types.EC2Instance,
+		Data:   map[string]interface{}{"tags": map[string]string{"Name": "web"}},
+		Source: "a.tfstate",
+	}
+	gone := types.Resource{Name: "i-456", Type: types.EC2Instance, Data: map[string]interface{}{}, Source: "a.tfstate"}
+	replacement := types.Resource{
+		Name:   "i-999",
+		Type:   types.EC2Instance,
+		Data:   map[string]interface{}{"tags": map[string]string{"Name": "web"}},
+		Region: "us-east-1",
+	}
+	stray := types.Resource{Name: "i-789", Type: types.EC2Instance, Data: map[string]interface{}{}}
+	findings := findUnmatchedResources([]types.Resource{old, gone}, []types.Resource{replacement, stray})
+	assert.Len(t, findings, 4)
+
+	assert.Equal(t, findings, findReplacements(findings, nil), "no matchers, no replacements")
+
+	assert.Equal(t, []types.Finding{
+		{
+			Kind:         types.FindingReplaced,
+			ResourceType: types.EC2Instance,
+			ResourceName: "i-123",
+			Region:       "us-east-1",
+			Message:      "tracked in a.tfstate but replaced out of band by i-999 (matched by tag:Name, 90% confidence)",
+			Confidence:   0.9,
+			Resources:    []types.Resource{old, replacement},
+		},
+		findings[1],
+		findings[3],
+	}, findReplacements(findings, []matcher.Matcher{matcher.NewTagMatcher("Name")}))
+
+	selected := selectFindings(findReplacements(findings, []matcher.Matcher{matcher.NewTagMatcher("Name")}), []string{"i-123"})
+	assert.Len(t, selected, 1, "the replacement is paired before the instance IDs narrow the findings")
+	assert.Equal(t, types.FindingReplaced, selected[0].Kind)
+}
+
+func TestFindImageFindings(t *testing.T) {
+	images := map[string]types.Image{
+		"ami-current":    {ID: "ami-current", Name: "web-2024-06-01", DeprecationTime: "2026-06-01T00:00:00.000Z", State: "available"},
+		"ami-deprecated": {ID: "ami-deprecated", Name: "web-2023-01-01", DeprecationTime: "2025-01-01T00:00:00.000Z", State: "available"},
+		"ami-disabled":   {ID: "ami-disabled", State: "disabled"},
+	}
+	running := func(id, ami string) types.Resource {
+		image, ok := images[ami]
+		if !ok {
+			image = types.Image{ID: ami, State: "deregistered"}
+		}
+		return types.NewResource(id, types.EC2Instance, map[string]interface{}{"ami": ami, "image": image})
+	}
+	resources := []types.Resource{
+		running("i-1", "ami-current"),
+		running("i-2", "ami-deprecated"),
+		running("i-3", "ami-disabled"),
+		running("i-4", "ami-gone"),
+		types.NewResource("i-5", types.EC2Instance, map[string]interface{}{"ami": "ami-gone"}),
+	}
+
+	findings := findImageFindings(resources, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, []types.Finding{
+		{
+			Kind:         types.FindingDeprecatedImage,
+			ResourceType: types.EC2Instance,
+			ResourceName: "i-2",
+			Message:      "runs AMI ami-deprecated (web-2023-01-01), deprecated since 2025-01-01",
+			Resources:    []types.Resource{resources[1]},
+		},
+		{
+			Kind:         types.FindingDeprecatedImage,
+			ResourceType: types.EC2Instance,
+			ResourceName: "i-3",
+			Message:      "runs AMI ami-disabled, which is disabled",
+			Resources:    []types.Resource{resources[2]},
+		},
+		{
+			Kind:         types.FindingDeregisteredImage,
+			ResourceType: types.EC2Instance,
+			ResourceName: "i-4",
+			Message:      "runs AMI ami-gone, which was deregistered or is no longer shared with the account",
+			Resources:    []types.Resource{resources[3]},
+		},
+	}, findings)
+}
+
+func TestExpectedImages(t *testing.T) {
+	resources := []types.Resource{
+		types.NewResource("i-1", types.EC2Instance, map[string]interface{}{"ami": "ami-1"}),
+		types.NewResource("i-2", types.EC2Instance, map[string]interface{}{}),
+		types.NewResource("web-asg", types.AutoScalingGroup, map[string]interface{}{"launch_configuration": "web-lc"}),
+		types.NewResource("web-lc", types.LaunchConfiguration, map[string]interface{}{"ami": "ami-2"}),
+	}
+
+	expected, parentExpected := expectedImages(resources)
+
+	assert.Equal(t, map[string]string{"i-1": "ami-1"}, expected)
+	assert.Equal(t, "ami-2", parentExpected[types.ResourceRef{Type: types.AutoScalingGroup, Name: "web-asg"}])
+}
+
+func TestFindUnmatchedResourcesAcrossRegions(t *testing.T) {
+	tfResources := []types.Resource{
+		{Name: "i-123", Type: types.EC2Instance, Source: "a.tfstate", Region: "us-east-1"},
+		{Name: "i-456", Type: types.EC2Instance, Source: "a.tfstate"},
+	}
+	awsResources := []types.Resource{
+		{Name: "i-123", Type: types.EC2Instance, Region: "eu-west-1"},
+		{Name: "i-456", Type: types.EC2Instance, Region: "eu-west-1"},
+	}
+
+	assert.Equal(t, []types.Finding{
+		{
+			Kind:         types.FindingMissing,
+			ResourceType: types.EC2Instance,
+			ResourceName: "i-123",
+			Region:       "us-east-1",
+			Message:      "tracked in a.tfstate but not found in the cloud",
+			Resources:    []types.Resource{tfResources[0]},
+		},
+		{
+			Kind:         types.FindingUnmanaged,
+			ResourceType: types.EC2Instance,
+			ResourceName: "i-123",
+			Region:       "eu-west-1",
+			Message:      "not managed by any loaded state",
+			Resources:    []types.Resource{awsResources[0]},
+		},
+	}, findUnmatchedResources(tfResources, awsResources), "a state entry without a region matches any region")
+
+	t.Run("accounts", func(t *testing.T) {
+		tfResources := []types.Resource{{Name: "i-123", Type: types.EC2Instance, Source: "a.tfstate", Account: "111111111111"}}
+		awsResources := []types.Resource{{Name: "i-123", Type: types.EC2Instance, Account: "222222222222", AccountAlias: "staging"}}
+
+		findings := findUnmatchedResources(tfResources, awsResources)
+
+		assert.Len(t, findings, 2)
+		assert.Equal(t, types.FindingMissing, findings[0].Kind)
+		assert.Equal(t, "111111111111", findings[0].Account)
+		assert.Equal(t, types.FindingUnmanaged, findings[1].Kind)
+		assert.Equal(t, "staging", findings[1].AccountAlias)
+	})
+}
+
+func TestFindDoubleManagedResources(t *testing.T) {
+	stackA := types.Resource{Name: "i-123", Type: types.EC2Instance, Address: "aws_instance.web", Source: "a.tfstate", Workspace: "default"}
+	stackB := types.Resource{Name: "i-123", Type: types.EC2Instance, Address: "module.app.aws_instance.this[0]", Source: "b.tfstate", Workspace: "prod"}
+	lookup := types.Resource{Name: "i-123", Type: types.EC2Instance, Address: "data.aws_instance.web", Source: "c.tfstate"}
+	single := types.Resource{Name: "i-456", Type: types.EC2Instance, Address: "aws_instance.api", Source: "a.tfstate"}
+
+	tests := []struct {
+		name        string
+		resources   []types.Resource
+		instanceIDs []string
+		expected    []types.Finding
+	}{
+		{
+			name:      "same instance in two states",
+			resources: []types.Resource{stackA, single, stackB},
+			expected: []types.Finding{{
+				Kind:         types.FindingDoubleManaged,
+				ResourceType: types.EC2Instance,
+				ResourceName: "i-123",
+				Message:      "managed by 2 state entries: aws_instance.web (a.tfstate), module.app.aws_instance.this[0] (b.tfstate [prod])",
+				Resources:    []types.Resource{stackA, stackB},
+			}},
+		},
+		{
+			name:      "data sources do not own resources",
+			resources: []types.Resource{stackA, lookup, single},
+		},
+		{
+			name:        "filtered out by instance IDs",
+			resources:   []types.Resource{stackA, stackB},
+			instanceIDs: []string{"i-456"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, findDoubleManagedResources(tt.resources, tt.instanceIDs))
+		})
+	}
+}
+
 func TestCompareResources(t *testing.T) {
 	log := &MockLogger{}
 	tfResources := []types.Resource{
@@ -357,6 +1018,24 @@ func TestCompareResources(t *testing.T) {
 			comparator:     &MockDriftComparator{},
 			expectedDrifts: map[types.ResourceType][]types.DriftGroup{},
 		},
+		{
+			name: "matched within the same region",
+			tfResources: []types.Resource{
+				{Name: "i-123", Type: types.EC2Instance, Region: "us-east-1", Data: map[string]interface{}{"instance_type": "t2.micro"}},
+			},
+			awsResources: []types.Resource{
+				{Name: "i-123", Type: types.EC2Instance, Region: "eu-west-1", Data: map[string]interface{}{"instance_type": "t3.micro"}},
+				{Name: "i-123", Type: types.EC2Instance, Region: "us-east-1", Data: map[string]interface{}{"instance_type": "t3.micro"}},
+			},
+			comparator: &MockDriftComparator{
+				Drifts: []types.Drift{{Name: "instance_type", OldValue: "t2.micro", NewValue: "t3.micro"}},
+			},
+			expectedDrifts: map[types.ResourceType][]types.DriftGroup{
+				types.EC2Instance: {
+					{ResourceName: "i-123", Region: "us-east-1", Drifts: []types.Drift{{Name: "instance_type", OldValue: "t2.micro", NewValue: "t3.micro"}}},
+				},
+			},
+		},
 		{
 			name:         "compare error",
 			tfResources:  tfResources,
@@ -486,7 +1165,7 @@ func TestRunCompare(t *testing.T) {
 			config.Parser = &MockParser{
 				Resources: tt.tfResources,
 			}
-			config.EC2RepoFactory = func(_ *session.Session, _ string, reader file.FileReader, _ logger.Logger) repository.EC2Repository {
+			config.EC2RepoFactory = func(_ *session.Session, _ repository.Scope, _ string, reader file.FileReader, _ logger.Logger) repository.EC2Repository {
 				return &MockEC2Repository{
 					Resources: tt.awsResources,
 				}
@@ -509,9 +1188,10 @@ func TestRunCompare(t *testing.T) {
 					},
 				}
 			}
+			config.StateSource = state.NewSource(config.FileReader, nil)
 
 			output := captureOutput(func() {
-				err := runCompare(config)
+				err := runCompare(context.Background(), config)
 				if tt.expectedErrMsg != "" {
 					assert.Error(t, err)
 					assert.Contains(t, err.Error(), tt.expectedErrMsg)
@@ -545,14 +1225,103 @@ func TestNewCompareCmd(t *testing.T) {
 	assert.NotNil(t, flags.Lookup("instance-ids"))
 	assert.NotNil(t, flags.Lookup("aws-json"))
 	assert.NotNil(t, flags.Lookup("tf-path"))
+	assert.NotNil(t, flags.Lookup("tf-dir"))
+	assert.NotNil(t, flags.Lookup("terragrunt-dir"))
+	assert.Equal(t, flags.Lookup("tf-path"), flags.Lookup("tf-state"))
+	assert.NotNil(t, flags.Lookup("redact"))
+	source, _ := flags.GetString("source")
+	assert.Equal(t, "terraform", source)
+	assert.NotNil(t, flags.Lookup("redact-pattern"))
 	assert.NotNil(t, flags.Lookup("output"))
 
 	// Verify default output
 	outputFlag, _ := flags.GetString("output")
 	assert.Equal(t, "console", outputFlag)
 
-	// Verify required flags
-	err := cmd.ValidateRequiredFlags()
-	assert.Error(t, err, "expected error when required flags are not set")
-	assert.Contains(t, err.Error(), "required flag(s) \"instance-ids\", \"tf-path\"")
+	// Instance IDs are optional; tag selectors or nothing select the instances instead
+	assert.NoError(t, cmd.ValidateRequiredFlags())
+	assert.NotNil(t, flags.Lookup("tag"))
+	includeTerminated, _ := flags.GetBool("include-terminated")
+	assert.False(t, includeTerminated)
+	assert.NotNil(t, flags.Lookup("match"))
+	includeOperational, _ := flags.GetBool("include-operational")
+	assert.False(t, includeOperational)
+	assert.NotNil(t, flags.Lookup("timeout"))
+	assert.NotNil(t, flags.Lookup("accounts"))
+	for _, name := range []string{"profile", "region", "endpoint-url", "config"} {
+		assert.NotNil(t, flags.Lookup(name), name)
+	}
+
+	// Exactly one state input is required
+	err := cmd.ValidateFlagGroups()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[tf-path tf-dir terragrunt-dir]")
+
+	assert.NoError(t, cmd.Flags().Set("tf-path", "terraform.tfstate"))
+	assert.NoError(t, cmd.ValidateFlagGroups())
+	assert.NoError(t, cmd.Flags().Set("tf-dir", "states"))
+	assert.Error(t, cmd.ValidateFlagGroups())
+	assert.NoError(t, cmd.Flags().Set("tf-dir", ""))
+
+	// Regions are either listed or all enumerated
+	cmd = NewCompareCmd()
+	assert.NoError(t, cmd.Flags().Set("tf-path", "terraform.tfstate"))
+	assert.NoError(t, cmd.Flags().Set("regions", "us-east-1,eu-west-1"))
+	assert.NoError(t, cmd.ValidateFlagGroups())
+	assert.NoError(t, cmd.Flags().Set("all-regions", "true"))
+	assert.Error(t, cmd.ValidateFlagGroups())
+}
+
+func TestWithoutOperational(t *testing.T) {
+	stopped := types.Drift{Name: "state", OldValue: "running", NewValue: "stopped", Category: types.DriftOperational}
+	resized := types.Drift{Name: "instance_type", OldValue: "t2.micro", NewValue: "t3.micro"}
+	driftResults := map[types.ResourceType][]types.DriftGroup{
+		types.EC2Instance: {
+			{ResourceName: "i-123", Drifts: []types.Drift{stopped}},
+			{ResourceName: "i-456", Drifts: []types.Drift{stopped, resized}},
+		},
+	}
+
+	assert.Equal(t, map[types.ResourceType][]types.DriftGroup{
+		types.EC2Instance: {{ResourceName: "i-456", Drifts: []types.Drift{resized}}},
+	}, withoutOperational(driftResults))
+}
+
+func TestGroupLabel(t *testing.T) {
+	group := types.DriftGroup{ResourceName: "i-123", Source: "live/app", Workspace: "default", Region: "eu-west-1"}
+
+	assert.Equal(t, "i-123", groupLabel(&CompareOptions{}, group))
+	assert.Equal(t, "i-123", groupLabel(&CompareOptions{Regions: []string{"eu-west-1"}}, group))
+	assert.Equal(t, "i-123 (eu-west-1)", groupLabel(&CompareOptions{AllRegions: true}, group))
+	assert.Equal(t, "i-123 (eu-west-1, live/app)", groupLabel(&CompareOptions{Regions: []string{"us-east-1", "eu-west-1"}, TerragruntDir: "live"}, group))
+
+	group.Account, group.AccountAlias = "111111111111", "prod"
+	assert.Equal(t, "i-123 (prod/111111111111)", groupLabel(&CompareOptions{AccountsPath: "accounts.yaml"}, group))
+	assert.Equal(t, "i-123 (prod/111111111111, eu-west-1)", groupLabel(&CompareOptions{AccountsPath: "accounts.yaml", AllRegions: true}, group))
+
+	group.Parent = &types.ResourceRef{Type: types.AutoScalingGroup, Name: "web-asg"}
+	assert.Equal(t, "i-123 (managed by aws_autoscaling_group web-asg, eu-west-1)", groupLabel(&CompareOptions{AllRegions: true}, group))
+}
+
+func TestNewParser(t *testing.T) {
+	reader := &MockFileReader{Data: map[string][]byte{
+		"resources.json": []byte(`{"StackResources": [{"LogicalResourceId": "Web", "PhysicalResourceId": "i-123"}]}`),
+	}}
+
+	p, err := newParser(&CompareOptions{Source: "terraform"}, reader)
+	assert.NoError(t, err)
+	assert.IsType(t, &parser.DefaultParser{}, p)
+
+	p, err = newParser(&CompareOptions{Source: "cloudformation", CFNStackResources: "resources.json"}, reader)
+	assert.NoError(t, err)
+	resources, err := p.ParseTerraformStateFile([]byte("Resources:\n  Web:\n    Type: AWS::EC2::Instance\n"))
+	assert.NoError(t, err)
+	assert.Len(t, resources, 1)
+	assert.Equal(t, "i-123", resources[0].Name)
+
+	_, err = newParser(&CompareOptions{Source: "cloudformation"}, reader)
+	assert.EqualError(t, err, "the cloudformation source requires --cfn-stack-resources")
+
+	_, err = newParser(&CompareOptions{Source: "cloudformation", CFNStackResources: "missing.json"}, reader)
+	assert.ErrorContains(t, err, "failed to read stack resources")
 }
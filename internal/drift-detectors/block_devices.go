@@ -0,0 +1,85 @@
+package drift
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// blockDeviceFields are the compared fields of a block device, in report order.
+var blockDeviceFields = []string{
+	"device_name",
+	"volume_id",
+	"volume_size",
+	"volume_type",
+	"iops",
+	"throughput",
+	"encrypted",
+	"kms_key_id",
+	"delete_on_termination",
+}
+
+// compareBlockDevices compares the root volume and the EBS volumes, which are
+// matched by device name. Each differing field is its own drift, e.g.
+// root_block_device.volume_size or ebs_block_device[/dev/sdf].iops, and
+// volumes attached or detached out of band are reported whole.
+func compareBlockDevices(oldData, newData map[string]interface{}) []types.Drift {
+	var drifts []types.Drift
+
+	oldRoot, oldOk := oldData["root_block_device"].(map[string]interface{})
+	newRoot, newOk := newData["root_block_device"].(map[string]interface{})
+	if oldOk && newOk {
+		drifts = append(drifts, compareBlockDevice("root_block_device", oldRoot, newRoot)...)
+	}
+
+	oldEBS, oldOk := oldData["ebs_block_device"].(map[string]map[string]interface{})
+	newEBS, newOk := newData["ebs_block_device"].(map[string]map[string]interface{})
+	if !oldOk || !newOk {
+		return drifts
+	}
+
+	for _, deviceName := range deviceNames(oldEBS, newEBS) {
+		name := fmt.Sprintf("ebs_block_device[%s]", deviceName)
+		oldDevice, inOld := oldEBS[deviceName]
+		newDevice, inNew := newEBS[deviceName]
+		switch {
+		case !inNew:
+			drifts = append(drifts, types.Drift{Name: name, OldValue: oldDevice, NewValue: nil})
+		case !inOld:
+			drifts = append(drifts, types.Drift{Name: name, OldValue: nil, NewValue: newDevice})
+		default:
+			drifts = append(drifts, compareBlockDevice(name, oldDevice, newDevice)...)
+		}
+	}
+	return drifts
+}
+
+// compareBlockDevice compares the fields both sides record.
+func compareBlockDevice(name string, oldDevice, newDevice map[string]interface{}) []types.Drift {
+	var drifts []types.Drift
+	for _, field := range blockDeviceFields {
+		oldValue, declared := oldDevice[field]
+		newValue, observed := newDevice[field]
+		if !declared || !observed || oldValue == newValue {
+			continue
+		}
+		drifts = append(drifts, types.Drift{Name: name + "." + field, OldValue: oldValue, NewValue: newValue})
+	}
+	return drifts
+}
+
+// deviceNames returns the device names of both sides, sorted.
+func deviceNames(oldDevices, newDevices map[string]map[string]interface{}) []string {
+	var names []string
+	for name := range oldDevices {
+		names = append(names, name)
+	}
+	for name := range newDevices {
+		if _, ok := oldDevices[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -17,3 +17,17 @@ func (c *DefaultDriftComparator) CompareEC2Configs(old, new types.Resource) ([]t
 func NewDriftComparator() DriftComparator {
 	return &DefaultDriftComparator{}
 }
+
+// comparators lists the comparison function registered for each resource type.
+var comparators = map[types.ResourceType]func(old, new types.Resource) ([]types.Drift, error){
+	types.EC2Instance: CompareEC2Configs,
+}
+
+// SupportedResourceTypes returns the resource types that have a registered comparator.
+func SupportedResourceTypes() []types.ResourceType {
+	supported := make([]types.ResourceType, 0, len(comparators))
+	for resourceType := range comparators {
+		supported = append(supported, resourceType)
+	}
+	return supported
+}
@@ -2,6 +2,7 @@ package drift
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/papidb/drift-detector/internal/types"
 )
@@ -18,10 +19,10 @@ func CompareEC2Configs(old, new types.Resource) ([]types.Drift, error) {
 	}
 
 	var drifts []types.Drift
+	sensitive := sensitivePaths(old, new)
 
 	fields := []string{
 		"instance_id",
-		"ami",
 		"key_name",
 		"instance_type",
 		"subnet_id",
@@ -29,37 +30,89 @@ func CompareEC2Configs(old, new types.Resource) ([]types.Drift, error) {
 		"state",
 		"private_ip",
 		"public_ip",
+		"source_dest_check",
+		"monitoring",
+		"ebs_optimized",
+		"iam_instance_profile",
+		"disable_api_termination",
+		"disable_api_stop",
+		"hibernation",
+		"tenancy",
+		"placement_group",
+		"metadata_options.http_endpoint",
+		"metadata_options.http_tokens",
+		"metadata_options.http_put_response_hop_limit",
+		"metadata_options.http_protocol_ipv6",
+		"metadata_options.instance_metadata_tags",
+		"cpu_options.core_count",
+		"cpu_options.threads_per_core",
+		"credit_specification.cpu_credits",
 	}
 
 	for _, field := range fields {
+		// Attributes the desired state does not declare are left to the cloud
+		if _, declared := oldData[field]; !declared {
+			continue
+		}
+		// Attributes the cloud data lacks, e.g. in an export made without
+		// instance attributes, cannot be compared
+		if _, observed := newData[field]; !observed {
+			continue
+		}
 		if oldData[field] != newData[field] {
-			drifts = append(drifts, types.Drift{
+			drifts = append(drifts, sensitiveDrift(types.Drift{
 				Name:     field,
 				OldValue: oldData[field],
 				NewValue: newData[field],
-			})
+			}, sensitive))
 		}
 	}
-	// Compare tags
-	oldTags, oldOk := oldData["tags"].(map[string]string)
+	for _, d := range compareImage(oldData, newData) {
+		drifts = append(drifts, sensitiveDrift(d, sensitive))
+	}
+	for _, d := range compareBlockDevices(oldData, newData) {
+		drifts = append(drifts, sensitiveDrift(d, sensitive))
+	}
+	for _, d := range compareNetworkInterfaces(oldData, newData) {
+		drifts = append(drifts, sensitiveDrift(d, sensitive))
+	}
+	for _, d := range compareLaunchTemplate(oldData, newData, sensitive) {
+		drifts = append(drifts, sensitiveDrift(d, sensitive))
+	}
+	drifts = append(drifts, compareUserData(old, new, sensitive)...)
+	_, tagsDeclared := oldData["tags"]
+	_, tagsAllDeclared := oldData["tags_all"]
+	if !tagsDeclared && !tagsAllDeclared {
+		return Classify(old.Type, drifts), nil
+	}
+
+	// Compare tags, one drift per key
+	oldTags, oldOk := expectedTags(oldData)
 	newTags, newOk := newData["tags"].(map[string]string)
 	if oldOk && newOk {
-		if !areTagsEqual(oldTags, newTags) {
-			drifts = append(drifts, types.Drift{
-				Name:     "tags",
-				OldValue: oldTags,
-				NewValue: newTags,
-			})
-		}
+		resourceTags, _ := oldData["tags"].(map[string]string)
+		drifts = append(drifts, compareTags(withoutReservedTags(oldTags), withoutReservedTags(newTags), resourceTags, sensitive)...)
 	} else if oldData["tags"] != newData["tags"] {
 		// Fallback for when tags are not map[string]string or one is nil
-		drifts = append(drifts, types.Drift{
+		drifts = append(drifts, sensitiveDrift(types.Drift{
 			Name:     "tags",
 			OldValue: oldData["tags"],
 			NewValue: newData["tags"],
-		})
+		}, sensitive))
+	}
+	return Classify(old.Type, drifts), nil
+}
+
+// withoutReservedTags drops tags under the aws: prefix. AWS sets those itself
+// (e.g. aws:cloudformation:stack-name) and no IaC tool can declare them.
+func withoutReservedTags(tags map[string]string) map[string]string {
+	filtered := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if !strings.HasPrefix(k, "aws:") {
+			filtered[k] = v
+		}
 	}
-	return drifts, nil
+	return filtered
 }
 
 // areTagsEqual compares two tag maps for semantic equality
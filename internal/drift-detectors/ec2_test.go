@@ -2,9 +2,12 @@ package drift
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/redact"
+	"github.com/papidb/drift-detector/pkg/userdata"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -76,9 +79,9 @@ func TestCompareEC2Configs(t *testing.T) {
 					NewValue: "t3.micro",
 				},
 				{
-					Name:     "tags",
-					OldValue: map[string]string{"Name": "test-instance", "Env": "prod"},
-					NewValue: map[string]string{"Name": "test-instance", "Env": "dev"},
+					Name:     "tags.Env",
+					OldValue: "prod",
+					NewValue: "dev",
 				},
 			},
 		},
@@ -253,3 +256,530 @@ func TestAreTagsEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareEC2ConfigsSensitive(t *testing.T) {
+	old := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"key_name": "old-key",
+			"tags":     map[string]string{"Name": "web", "ApiKey": "secret-1"},
+		},
+		Sensitive: []string{"key_name", "tags.ApiKey"},
+	}
+	new := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"key_name": "new-key",
+			"tags":     map[string]string{"Name": "web", "ApiKey": "secret-2"},
+		},
+	}
+
+	drifts, err := CompareEC2Configs(old, new)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []types.Drift{
+		{
+			Name:      "key_name",
+			OldValue:  redact.Hash("old-key"),
+			NewValue:  redact.Hash("new-key"),
+			Sensitive: true,
+		},
+		{
+			Name:      "tags.ApiKey",
+			OldValue:  redact.Hash("secret-1"),
+			NewValue:  redact.Hash("secret-2"),
+			Sensitive: true,
+		},
+	}, drifts)
+	assert.NotEqual(t, drifts[0].OldValue, drifts[0].NewValue, "differing secrets must hash differently")
+}
+
+func TestCompareEC2ConfigsNestedSensitive(t *testing.T) {
+	// The state marks root_block_device.0.kms_key_id and metadata_options.0.http_tokens
+	old := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"metadata_options.http_tokens": "required",
+			"root_block_device":            map[string]interface{}{"kms_key_id": "key-1", "volume_size": int64(8)},
+			"ebs_block_device":             map[string]map[string]interface{}{"/dev/sdf": {"kms_key_id": "key-2"}},
+		},
+		Sensitive: []string{"metadata_options.http_tokens", "root_block_device.kms_key_id", "ebs_block_device.kms_key_id"},
+	}
+	new := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"metadata_options.http_tokens": "optional",
+			"root_block_device":            map[string]interface{}{"kms_key_id": "key-3", "volume_size": int64(16)},
+			"ebs_block_device":             map[string]map[string]interface{}{"/dev/sdf": {"kms_key_id": "key-4"}},
+		},
+	}
+
+	drifts, err := CompareEC2Configs(old, new)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []types.Drift{
+		{Name: "metadata_options.http_tokens", OldValue: redact.Hash("required"), NewValue: redact.Hash("optional"), Sensitive: true},
+		{Name: "root_block_device.volume_size", OldValue: int64(8), NewValue: int64(16)},
+		{Name: "root_block_device.kms_key_id", OldValue: redact.Hash("key-1"), NewValue: redact.Hash("key-3"), Sensitive: true},
+		{Name: "ebs_block_device[/dev/sdf].kms_key_id", OldValue: redact.Hash("key-2"), NewValue: redact.Hash("key-4"), Sensitive: true},
+	}, drifts)
+}
+
+func TestCompareEC2ConfigsPartialDesiredState(t *testing.T) {
+	// A CloudFormation template only declares some attributes and AWS adds its own tags
+	desired := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"instance_id":   "i-123",
+			"instance_type": "t2.micro",
+			"tags":          map[string]string{"Name": "web"},
+		},
+	}
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"instance_id":   "i-123",
+			"instance_type": "t3.micro",
+			"public_ip":     "203.0.113.1",
+			"state":         "running",
+			"tags": map[string]string{
+				"Name":                          "web",
+				"aws:cloudformation:stack-name": "web",
+			},
+		},
+	}
+
+	drifts, err := CompareEC2Configs(desired, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Drift{{Name: "instance_type", OldValue: "t2.micro", NewValue: "t3.micro"}}, drifts)
+}
+
+func TestCompareEC2ConfigsOperational(t *testing.T) {
+	// A stop/start cycle stops the instance and releases its public IP
+	desired := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{"state": "running", "public_ip": "203.0.113.1", "instance_type": "t2.micro"},
+	}
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{"state": "stopped", "public_ip": "", "instance_type": "t3.micro"},
+	}
+
+	drifts, err := CompareEC2Configs(desired, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Drift{
+		{Name: "instance_type", OldValue: "t2.micro", NewValue: "t3.micro"},
+		{Name: "state", Category: types.DriftOperational, OldValue: "running", NewValue: "stopped"},
+		{Name: "public_ip", Category: types.DriftOperational, OldValue: "203.0.113.1", NewValue: ""},
+	}, drifts)
+	assert.Equal(t, drifts[:1], WithoutOperational(drifts))
+}
+
+func TestCompareEC2ConfigsLaunchTemplate(t *testing.T) {
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"tags":                            map[string]string{},
+			"launch_template.id":              "lt-1",
+			"launch_template.version":         "2",
+			"launch_template.latest_version":  "3",
+			"launch_template.default_version": "2",
+			"launch_template.versions": map[string]map[string]string{
+				"2": {"InstanceType": "t3.micro", "ImageId": "ami-1", "KeyName": "ops"},
+				"3": {"InstanceType": "t3.large", "ImageId": "ami-1", "EbsOptimized": "true"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		desired  map[string]interface{}
+		expected []types.Drift
+	}{
+		{
+			name:    "latest moved",
+			desired: map[string]interface{}{"launch_template.id": "lt-1", "launch_template.version": "$Latest"},
+			expected: []types.Drift{{
+				Name:     "launch_template.version",
+				OldValue: "$Latest (3)",
+				NewValue: "2",
+				Diff: []string{
+					"- EbsOptimized: true",
+					"- InstanceType: t3.large",
+					"+ InstanceType: t3.micro",
+					"+ KeyName: ops",
+				},
+			}},
+		},
+		{
+			name:    "default unchanged",
+			desired: map[string]interface{}{"launch_template.id": "lt-1", "launch_template.version": "$Default"},
+		},
+		{
+			name:     "pinned version",
+			desired:  map[string]interface{}{"launch_template.id": "lt-1", "launch_template.version": "1"},
+			expected: []types.Drift{{Name: "launch_template.version", OldValue: "1", NewValue: "2"}},
+		},
+		{
+			name:     "other template",
+			desired:  map[string]interface{}{"launch_template.id": "lt-9", "launch_template.version": "$Latest"},
+			expected: []types.Drift{{Name: "launch_template.id", OldValue: "lt-9", NewValue: "lt-1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drifts, err := CompareEC2Configs(types.Resource{Type: types.EC2Instance, Data: tt.desired}, actual)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, drifts)
+		})
+	}
+
+	t.Run("launched without a template", func(t *testing.T) {
+		desired := types.Resource{Type: types.EC2Instance, Data: map[string]interface{}{"launch_template.id": "lt-1", "launch_template.version": "$Latest"}}
+		plain := types.Resource{Type: types.EC2Instance, Data: map[string]interface{}{"tags": map[string]string{}}}
+		drifts, err := CompareEC2Configs(desired, plain)
+		assert.NoError(t, err)
+		assert.Equal(t, []types.Drift{{Name: "launch_template.id", OldValue: "lt-1", NewValue: ""}}, drifts)
+	})
+
+	t.Run("sensitive fields", func(t *testing.T) {
+		desired := types.Resource{
+			Type:      types.EC2Instance,
+			Data:      map[string]interface{}{"launch_template.id": "lt-1", "launch_template.version": "$Latest"},
+			Sensitive: []string{"tags.ApiKey", "metadata_options.http_tokens"},
+		}
+		tagged := types.Resource{Type: types.EC2Instance, Data: map[string]interface{}{
+			"tags":                           map[string]string{},
+			"launch_template.id":             "lt-1",
+			"launch_template.version":        "2",
+			"launch_template.latest_version": "3",
+			"launch_template.versions": map[string]map[string]string{
+				"2": {
+					"TagSpecifications[0].Tags[0].Key":   "ApiKey",
+					"TagSpecifications[0].Tags[0].Value": "secret-2",
+					"TagSpecifications[0].Tags[1].Key":   "Name",
+					"TagSpecifications[0].Tags[1].Value": "web-2",
+					"MetadataOptions.HttpTokens":         "optional",
+				},
+				"3": {
+					"TagSpecifications[0].Tags[0].Key":   "ApiKey",
+					"TagSpecifications[0].Tags[0].Value": "secret-3",
+					"TagSpecifications[0].Tags[1].Key":   "Name",
+					"TagSpecifications[0].Tags[1].Value": "web-3",
+					"MetadataOptions.HttpTokens":         "required",
+				},
+			},
+		}}
+
+		drifts, err := CompareEC2Configs(desired, tagged)
+		assert.NoError(t, err)
+		if assert.Len(t, drifts, 1) {
+			assert.Equal(t, []string{
+				fmt.Sprintf("- MetadataOptions.HttpTokens: %s", redact.Hash("required")),
+				fmt.Sprintf("+ MetadataOptions.HttpTokens: %s", redact.Hash("optional")),
+				fmt.Sprintf("- TagSpecifications[0].Tags[0].Value: %s", redact.Hash("secret-3")),
+				fmt.Sprintf("+ TagSpecifications[0].Tags[0].Value: %s", redact.Hash("secret-2")),
+				"- TagSpecifications[0].Tags[1].Value: web-3",
+				"+ TagSpecifications[0].Tags[1].Value: web-2",
+			}, drifts[0].Diff)
+		}
+	})
+}
+
+func TestCompareEC2ConfigsImage(t *testing.T) {
+	desired := types.Resource{Type: types.EC2Instance, Data: map[string]interface{}{"ami": "ami-old"}}
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"ami":            "ami-new",
+			"expected_image": types.Image{ID: "ami-old", Name: "web-2024-01-01", Owner: "111111111111", CreationDate: "2024-01-01T10:00:00.000Z"},
+			"image":          types.Image{ID: "ami-new", Name: "web-2024-06-01", Owner: "111111111111", CreationDate: "2024-06-01T10:00:00.000Z"},
+		},
+	}
+
+	drifts, err := CompareEC2Configs(desired, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Drift{{
+		Name:     "ami",
+		OldValue: "ami-old (web-2024-01-01, owner 111111111111, created 2024-01-01)",
+		NewValue: "ami-new (web-2024-06-01, owner 111111111111, created 2024-06-01)",
+	}}, drifts)
+
+	t.Run("unknown AMIs", func(t *testing.T) {
+		actual := types.Resource{Type: types.EC2Instance, Data: map[string]interface{}{"ami": "ami-new"}}
+		drifts, err := CompareEC2Configs(desired, actual)
+		assert.NoError(t, err)
+		assert.Equal(t, []types.Drift{{Name: "ami", OldValue: "ami-old", NewValue: "ami-new"}}, drifts)
+	})
+}
+
+func TestCompareEC2ConfigsTags(t *testing.T) {
+	desired := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"tags": map[string]string{"Name": "web", "Env": "prod", "Team": "infra"},
+			"tags_all": map[string]string{
+				"Name":       "web",
+				"Env":        "prod",
+				"Team":       "infra",
+				"CostCenter": "1234",
+				"Owner":      "platform",
+			},
+		},
+	}
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"tags": map[string]string{
+				"Name":                      "web",
+				"Env":                       "staging",
+				"CostCenter":                "5678",
+				"Owner":                     "platform",
+				"Patch":                     "weekly",
+				"aws:autoscaling:groupName": "web",
+			},
+		},
+	}
+
+	drifts, err := CompareEC2Configs(desired, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Drift{
+		{Name: "default_tags.CostCenter", OldValue: "1234", NewValue: "5678"},
+		{Name: "tags.Env", OldValue: "prod", NewValue: "staging"},
+		{Name: "tags.Patch", OldValue: nil, NewValue: "weekly"},
+		{Name: "tags.Team", OldValue: "infra", NewValue: nil},
+	}, drifts)
+
+	t.Run("without tags_all", func(t *testing.T) {
+		desired := types.Resource{
+			Type: types.EC2Instance,
+			Data: map[string]interface{}{"tags": map[string]string{"Name": "web"}},
+		}
+		drifts, err := CompareEC2Configs(desired, actual)
+		assert.NoError(t, err)
+		var names []string
+		for _, d := range drifts {
+			names = append(names, d.Name)
+		}
+		assert.Equal(t, []string{"tags.CostCenter", "tags.Env", "tags.Owner", "tags.Patch"}, names)
+	})
+
+	t.Run("sensitive default tag", func(t *testing.T) {
+		desired := desired
+		desired.Sensitive = []string{"tags_all.CostCenter"}
+		drifts, err := CompareEC2Configs(desired, actual)
+		assert.NoError(t, err)
+		assert.Equal(t, types.Drift{
+			Name:      "default_tags.CostCenter",
+			OldValue:  redact.Hash("1234"),
+			NewValue:  redact.Hash("5678"),
+			Sensitive: true,
+		}, drifts[0])
+	})
+}
+
+func TestCompareEC2ConfigsInstanceSettings(t *testing.T) {
+	// The changes scripts/drift.sh makes
+	desired := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"source_dest_check":                            true,
+			"metadata_options.http_tokens":                 "optional",
+			"metadata_options.http_put_response_hop_limit": int64(1),
+			"disable_api_termination":                      false,
+			"credit_specification.cpu_credits":             "standard",
+		},
+	}
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"source_dest_check":                            false,
+			"metadata_options.http_tokens":                 "required",
+			"metadata_options.http_put_response_hop_limit": int64(1),
+			"disable_api_termination":                      false,
+		},
+	}
+
+	drifts, err := CompareEC2Configs(desired, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Drift{
+		{Name: "source_dest_check", OldValue: true, NewValue: false},
+		{Name: "metadata_options.http_tokens", OldValue: "optional", NewValue: "required"},
+	}, drifts, "attributes the cloud data lacks are not compared")
+}
+
+func TestCompareEC2ConfigsBlockDevices(t *testing.T) {
+	desired := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"root_block_device": map[string]interface{}{
+				"device_name": "/dev/xvda",
+				"volume_size": int64(20),
+				"volume_type": "gp3",
+				"throughput":  int64(0),
+			},
+			"ebs_block_device": map[string]map[string]interface{}{
+				"/dev/sdf": {"device_name": "/dev/sdf", "volume_size": int64(100), "iops": int64(3000)},
+				"/dev/sdg": {"device_name": "/dev/sdg", "volume_size": int64(10)},
+			},
+		},
+	}
+	actual := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			// Resized in the console; gp2-style volumes report no throughput
+			"root_block_device": map[string]interface{}{
+				"device_name": "/dev/xvda",
+				"volume_size": int64(40),
+				"volume_type": "gp3",
+			},
+			"ebs_block_device": map[string]map[string]interface{}{
+				"/dev/sdf": {"device_name": "/dev/sdf", "volume_size": int64(100), "iops": int64(6000)},
+				"/dev/sdh": {"device_name": "/dev/sdh", "volume_size": int64(5)},
+			},
+		},
+	}
+
+	drifts, err := CompareEC2Configs(desired, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Drift{
+		{Name: "root_block_device.volume_size", OldValue: int64(20), NewValue: int64(40)},
+		{Name: "ebs_block_device[/dev/sdf].iops", OldValue: int64(3000), NewValue: int64(6000)},
+		{Name: "ebs_block_device[/dev/sdg]", OldValue: map[string]interface{}{"device_name": "/dev/sdg", "volume_size": int64(10)}, NewValue: nil},
+		{Name: "ebs_block_device[/dev/sdh]", OldValue: nil, NewValue: map[string]interface{}{"device_name": "/dev/sdh", "volume_size": int64(5)}},
+	}, drifts)
+}
+
+func TestCompareEC2ConfigsNetworkInterfaces(t *testing.T) {
+	desired := types.Resource{
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"public_ip":       "203.0.113.1",
+			"security_groups": []string{"sg-2", "sg-1"},
+			"network_interface": map[string]map[string]interface{}{
+				"0": {"network_interface_id": "eni-primary", "secondary_private_ips": []string{}},
+			},
+		},
+	}
+	eni := func(id string, secondary, groups []string, elasticIP string) map[string]interface{} {
+		return map[string]interface{}{
+			"network_interface_id":  id,
+			"secondary_private_ips": secondary,
+			"security_groups":       groups,
+			"elastic_ip":            elasticIP,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		actual   map[string]map[string]interface{}
+		expected []types.Drift
+	}{
+		{
+			name: "no drift",
+			actual: map[string]map[string]interface{}{
+				"0": eni("eni-primary", nil, []string{"sg-1", "sg-2"}, "203.0.113.1"),
+			},
+		},
+		{
+			name: "secondary IP, security group and extra interface",
+			actual: map[string]map[string]interface{}{
+				"0": eni("eni-primary", []string{"10.0.0.5"}, []string{"sg-1", "sg-3"}, ""),
+				"1": eni("eni-extra", []string{}, []string{"sg-1"}, ""),
+			},
+			expected: []types.Drift{
+				{Name: "security_groups", OldValue: []string{"sg-2", "sg-1"}, NewValue: []string{"sg-1", "sg-3"}},
+				{Name: "network_interface[0].secondary_private_ips", OldValue: []string{}, NewValue: []string{"10.0.0.5"}},
+				{Name: "network_interface[1]", OldValue: nil, NewValue: eni("eni-extra", []string{}, []string{"sg-1"}, "")},
+			},
+		},
+		{
+			name: "elastic IP unknown to the state",
+			actual: map[string]map[string]interface{}{
+				"0": eni("eni-primary", []string{}, []string{"sg-1", "sg-2"}, "198.51.100.7"),
+			},
+			expected: []types.Drift{
+				{Name: "network_interface[0].elastic_ip", OldValue: nil, NewValue: "198.51.100.7"},
+			},
+		},
+		{
+			name:   "primary interface detached",
+			actual: map[string]map[string]interface{}{},
+			expected: []types.Drift{
+				{Name: "network_interface[0]", OldValue: map[string]interface{}{"network_interface_id": "eni-primary", "secondary_private_ips": []string{}}, NewValue: nil},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := types.Resource{Type: types.EC2Instance, Data: map[string]interface{}{
+				"public_ip":         "203.0.113.1",
+				"network_interface": tt.actual,
+			}}
+
+			drifts, err := CompareEC2Configs(desired, actual)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, drifts)
+		})
+	}
+}
+
+func TestCompareEC2ConfigsUserData(t *testing.T) {
+	script := "#!/bin/bash\nexport DB_PASSWORD=hunter2\nyum install -y nginx\nsystemctl start nginx\n"
+	edited := "#!/bin/bash\r\nexport DB_PASSWORD=hunter3\r\nyum install -y nginx\r\nsystemctl start nginx\r\n"
+	instance := func(userData string, sensitive ...string) types.Resource {
+		return types.Resource{
+			Type:      types.EC2Instance,
+			Data:      map[string]interface{}{"user_data": userData},
+			Sensitive: sensitive,
+		}
+	}
+
+	t.Run("matching digest", func(t *testing.T) {
+		drifts, err := CompareEC2Configs(instance(userdata.Hash([]byte(script))), instance(script))
+		assert.NoError(t, err)
+		assert.Empty(t, drifts)
+	})
+
+	t.Run("differing digest", func(t *testing.T) {
+		digest := userdata.Hash([]byte(script))
+		drifts, err := CompareEC2Configs(instance(digest), instance(edited))
+		assert.NoError(t, err)
+		assert.Equal(t, []types.Drift{{
+			Name:      "user_data",
+			OldValue:  redact.Hash(digest),
+			NewValue:  redact.Hash(edited),
+			Sensitive: true,
+		}}, drifts, "the state holds no content to diff")
+	})
+
+	t.Run("same script, other line endings", func(t *testing.T) {
+		drifts, err := CompareEC2Configs(instance(script), instance(strings.ReplaceAll(script, "\n", "\r\n")))
+		assert.NoError(t, err)
+		assert.Empty(t, drifts)
+	})
+
+	t.Run("edited script", func(t *testing.T) {
+		drifts, err := CompareEC2Configs(instance(script), instance(edited+"echo done\n"))
+		assert.NoError(t, err)
+		if assert.Len(t, drifts, 1) {
+			assert.True(t, drifts[0].Sensitive)
+			assert.Equal(t, []string{
+				"  #!/bin/bash",
+				"- export DB_PASSWORD=[REDACTED]",
+				"+ export DB_PASSWORD=[REDACTED]",
+				"  yum install -y nginx",
+				"  systemctl start nginx",
+				"+ echo done",
+			}, drifts[0].Diff)
+		}
+	})
+
+	t.Run("marked sensitive by the state", func(t *testing.T) {
+		drifts, err := CompareEC2Configs(instance(script, "user_data"), instance("#!/bin/bash\n"))
+		assert.NoError(t, err)
+		if assert.Len(t, drifts, 1) {
+			assert.Nil(t, drifts[0].Diff)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package drift
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// compareImage compares the instance's AMI. When the AMIs were described,
+// both sides are labelled with the AMI's name, owner and creation date, so a
+// patch bump within an image family reads differently from a wrong image.
+func compareImage(oldData, newData map[string]interface{}) []types.Drift {
+	oldAMI, declared := oldData["ami"]
+	newAMI, observed := newData["ami"]
+	if !declared || !observed || oldAMI == newAMI {
+		return nil
+	}
+
+	expected, _ := newData["expected_image"].(types.Image)
+	actual, _ := newData["image"].(types.Image)
+	return []types.Drift{{Name: "ami", OldValue: imageLabel(oldAMI, expected), NewValue: imageLabel(newAMI, actual)}}
+}
+
+// imageLabel describes an AMI ID as "ami-1 (name, owner amazon, created
+// 2024-06-01)", or returns it as it is when image describes another AMI or
+// nothing is known about it.
+func imageLabel(ami interface{}, image types.Image) interface{} {
+	id, ok := ami.(string)
+	if !ok || image.ID != id {
+		return ami
+	}
+
+	var details []string
+	if image.Name != "" {
+		details = append(details, image.Name)
+	}
+	if image.Owner != "" {
+		details = append(details, "owner "+image.Owner)
+	}
+	if date, _, _ := strings.Cut(image.CreationDate, "T"); date != "" {
+		details = append(details, "created "+date)
+	}
+	if len(details) == 0 {
+		return ami
+	}
+	return fmt.Sprintf("%s (%s)", id, strings.Join(details, ", "))
+}
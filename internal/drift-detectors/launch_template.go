@@ -0,0 +1,137 @@
+package drift
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/redact"
+)
+
+// compareLaunchTemplate compares the launch template the instance was
+// launched from with the one the state records. A symbolic $Latest or
+// $Default version is resolved against the template's current versions, so a
+// version that moved since launch is reported, with the template fields that
+// differ between the expected version and the instance's. Fields on sensitive
+// attributes have their values hashed.
+func compareLaunchTemplate(oldData, newData map[string]interface{}, sensitive map[string]map[string]struct{}) []types.Drift {
+	oldID, _ := oldData["launch_template.id"].(string)
+	newID, observed := newData["launch_template.id"]
+	if oldID == "" {
+		return nil
+	}
+	if !observed {
+		// The instance was not launched from a template, or its tags were not read
+		if _, tagged := newData["tags"]; !tagged {
+			return nil
+		}
+		newID = ""
+	}
+	if oldID != newID {
+		return []types.Drift{{Name: "launch_template.id", OldValue: oldID, NewValue: newID}}
+	}
+
+	oldVersion, _ := oldData["launch_template.version"].(string)
+	newVersion, _ := newData["launch_template.version"].(string)
+	expected, label := oldVersion, oldVersion
+	switch oldVersion {
+	case "$Latest":
+		expected, _ = newData["launch_template.latest_version"].(string)
+	case "$Default":
+		expected, _ = newData["launch_template.default_version"].(string)
+	}
+	if expected == "" || newVersion == "" || expected == newVersion {
+		return nil
+	}
+	if expected != oldVersion {
+		label = fmt.Sprintf("%s (%s)", oldVersion, expected)
+	}
+
+	versions, _ := newData["launch_template.versions"].(map[string]map[string]string)
+	return []types.Drift{{
+		Name:     "launch_template.version",
+		OldValue: label,
+		NewValue: newVersion,
+		Diff:     templateDiff(versions[expected], versions[newVersion], sensitive),
+	}}
+}
+
+// templateDiff lists the launch template fields that differ between two
+// versions, as "- field: value" for the old version and "+ field: value" for
+// the new one. It is nil when either version is unknown.
+func templateDiff(oldFields, newFields map[string]string, sensitive map[string]map[string]struct{}) []string {
+	if oldFields == nil || newFields == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(oldFields)+len(newFields))
+	for key := range oldFields {
+		keys = append(keys, key)
+	}
+	for key := range newFields {
+		if _, ok := oldFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		oldValue, inOld := oldFields[key]
+		newValue, inNew := newFields[key]
+		if inOld && inNew && oldValue == newValue {
+			continue
+		}
+		if inOld {
+			lines = append(lines, fmt.Sprintf("- %s: %v", key, templateValue(oldFields, key, sensitive)))
+		}
+		if inNew {
+			lines = append(lines, fmt.Sprintf("+ %s: %v", key, templateValue(newFields, key, sensitive)))
+		}
+	}
+	return lines
+}
+
+// templateTagValue matches the value of a tag in a launch template, whose key
+// is the sibling Key field.
+var templateTagValue = regexp.MustCompile(`^TagSpecifications\[\d+\]\.Tags\[\d+\]\.Value$`)
+
+// templateValue returns a launch template field's value, hashed when it
+// holds a sensitive tag or the instance attribute it sets is sensitive, e.g.
+// MetadataOptions.HttpTokens for metadata_options.http_tokens.
+func templateValue(fields map[string]string, key string, sensitive map[string]map[string]struct{}) interface{} {
+	value := fields[key]
+	if templateTagValue.MatchString(key) {
+		if tagSensitive(fields[strings.TrimSuffix(key, "Value")+"Key"], sensitive) {
+			return redact.Hash(value)
+		}
+		return value
+	}
+	if sensitiveAttribute(attributePath(key), sensitive) {
+		return redact.Hash(value)
+	}
+	return value
+}
+
+// attributePath names a launch template field the way instance attributes
+// are named, e.g. metadata_options.http_tokens for MetadataOptions.HttpTokens.
+func attributePath(key string) string {
+	segments := strings.Split(withoutSelectors(key), ".")
+	for i, segment := range segments {
+		var b strings.Builder
+		for j, r := range segment {
+			if unicode.IsUpper(r) {
+				if j > 0 {
+					b.WriteByte('_')
+				}
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, ".")
+}
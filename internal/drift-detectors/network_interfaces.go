@@ -0,0 +1,106 @@
+package drift
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// networkInterfaceFields are the compared fields of a network interface, in report order.
+var networkInterfaceFields = []string{
+	"network_interface_id",
+	"subnet_id",
+	"private_ip",
+	"secondary_private_ips",
+	"ipv6_addresses",
+	"security_groups",
+	"delete_on_termination",
+	"elastic_ip",
+}
+
+// compareNetworkInterfaces compares the instance's network interfaces, matched
+// by device index. Interfaces attached or detached out of band are reported
+// whole, and differing fields as e.g. network_interface[1].security_groups.
+// The instance's security groups are compared with its primary interface's.
+// IaC state does not link Elastic IPs to instances, so one is reported when
+// the state does not know its address as the instance's public IP.
+func compareNetworkInterfaces(oldData, newData map[string]interface{}) []types.Drift {
+	newENIs, ok := newData["network_interface"].(map[string]map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var drifts []types.Drift
+	if groups, ok := oldData["security_groups"].([]string); ok && groups != nil {
+		if primary, ok := newENIs["0"]; ok && !equalValues(sortedCopy(groups), primary["security_groups"]) {
+			drifts = append(drifts, types.Drift{Name: "security_groups", OldValue: groups, NewValue: primary["security_groups"]})
+		}
+	}
+
+	oldENIs, declared := oldData["network_interface"].(map[string]map[string]interface{})
+	for _, index := range deviceIndexes(oldENIs, newENIs) {
+		name := fmt.Sprintf("network_interface[%s]", index)
+		oldENI, inOld := oldENIs[index]
+		newENI, inNew := newENIs[index]
+		switch {
+		case declared && !inNew:
+			drifts = append(drifts, types.Drift{Name: name, OldValue: oldENI, NewValue: nil})
+			continue
+		case declared && !inOld:
+			drifts = append(drifts, types.Drift{Name: name, OldValue: nil, NewValue: newENI})
+			continue
+		}
+
+		for _, field := range networkInterfaceFields {
+			oldValue, fieldDeclared := oldENI[field]
+			newValue, observed := newENI[field]
+			if fieldDeclared && observed && !equalValues(oldValue, newValue) {
+				drifts = append(drifts, types.Drift{Name: name + "." + field, OldValue: oldValue, NewValue: newValue})
+			}
+		}
+		if _, fieldDeclared := oldENI["elastic_ip"]; !fieldDeclared {
+			if eip, _ := newENI["elastic_ip"].(string); eip != "" && eip != oldData["public_ip"] {
+				drifts = append(drifts, types.Drift{Name: name + ".elastic_ip", OldValue: nil, NewValue: eip})
+			}
+		}
+	}
+	return drifts
+}
+
+// equalValues compares attribute values, treating nil and empty lists alike.
+func equalValues(a, b interface{}) bool {
+	aList, aOk := a.([]string)
+	bList, bOk := b.([]string)
+	if aOk && bOk && len(aList) == 0 && len(bList) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func sortedCopy(strs []string) []string {
+	sorted := append([]string{}, strs...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// deviceIndexes returns the device indexes of both sides in numeric order.
+func deviceIndexes(oldENIs, newENIs map[string]map[string]interface{}) []string {
+	var indexes []string
+	for index := range newENIs {
+		indexes = append(indexes, index)
+	}
+	for index := range oldENIs {
+		if _, ok := newENIs[index]; !ok {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		a, _ := strconv.Atoi(indexes[i])
+		b, _ := strconv.Atoi(indexes[j])
+		return a < b
+	})
+	return indexes
+}
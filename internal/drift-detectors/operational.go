@@ -0,0 +1,37 @@
+package drift
+
+import "github.com/papidb/drift-detector/internal/types"
+
+// operationalAttributes lists, per resource type, the volatile attributes that
+// change through normal operation rather than through configuration. A
+// stopped instance reports its state, and a stop/start cycle without an
+// Elastic IP gives the instance a new public IP.
+var operationalAttributes = map[types.ResourceType]map[string]struct{}{
+	types.EC2Instance: {
+		"state":     {},
+		"public_ip": {},
+	},
+}
+
+// Classify marks the drifts in operational attributes of a resource type as
+// operational, leaving the rest as configuration drift.
+func Classify(resourceType types.ResourceType, drifts []types.Drift) []types.Drift {
+	attributes := operationalAttributes[resourceType]
+	for i := range drifts {
+		if _, ok := attributes[drifts[i].Name]; ok {
+			drifts[i].Category = types.DriftOperational
+		}
+	}
+	return drifts
+}
+
+// WithoutOperational returns the configuration drifts among drifts.
+func WithoutOperational(drifts []types.Drift) []types.Drift {
+	var configuration []types.Drift
+	for _, d := range drifts {
+		if d.Category != types.DriftOperational {
+			configuration = append(configuration, d)
+		}
+	}
+	return configuration
+}
@@ -0,0 +1,100 @@
+package drift
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/redact"
+)
+
+// sensitivePaths collects the sensitive attribute paths of both sides of a comparison.
+// A path is either a whole attribute ("user_data") or one key of a map ("tags.ApiKey").
+// A nested attribute ("metadata_options.http_tokens") is also recorded whole
+// under its full path, which is how its drift is named.
+func sensitivePaths(old, new types.Resource) map[string]map[string]struct{} {
+	paths := make(map[string]map[string]struct{})
+	set := func(attribute, key string) {
+		if _, ok := paths[attribute]; !ok {
+			paths[attribute] = make(map[string]struct{})
+		}
+		// An empty key stands for the whole attribute
+		paths[attribute][key] = struct{}{}
+	}
+	add := func(p string) {
+		attribute, key := redact.SplitPath(p)
+		set(attribute, key)
+		if key != "" {
+			set(p, "")
+		}
+	}
+
+	for _, p := range redact.AlwaysSensitive[old.Type] {
+		add(p)
+	}
+	for _, p := range old.Sensitive {
+		add(p)
+	}
+	for _, p := range new.Sensitive {
+		add(p)
+	}
+	return paths
+}
+
+// sensitiveDrift replaces the values of a drift on a sensitive attribute with
+// hashes, so differing secrets are still reported without being revealed.
+func sensitiveDrift(d types.Drift, sensitive map[string]map[string]struct{}) types.Drift {
+	name := withoutSelectors(d.Name)
+	if sensitiveAttribute(name, sensitive) {
+		d.OldValue, d.NewValue = redact.Hash(d.OldValue), redact.Hash(d.NewValue)
+		d.Sensitive = true
+		return d
+	}
+	keys, ok := sensitive[name]
+	if !ok {
+		return d
+	}
+
+	oldTags, oldOk := d.OldValue.(map[string]string)
+	newTags, newOk := d.NewValue.(map[string]string)
+	if !oldOk && !newOk {
+		// Not a map, so per-key sensitivity cannot be honoured; hide the whole value.
+		d.OldValue, d.NewValue = redact.Hash(d.OldValue), redact.Hash(d.NewValue)
+		d.Sensitive = true
+		return d
+	}
+	if oldOk {
+		d.OldValue = redact.HashKeys(oldTags, keys)
+	}
+	if newOk {
+		d.NewValue = redact.HashKeys(newTags, keys)
+	}
+	d.Sensitive = true
+	return d
+}
+
+// deviceSelector matches the device name or index that drifts on block
+// devices and network interfaces carry, e.g. ebs_block_device[/dev/sdf].
+var deviceSelector = regexp.MustCompile(`\[[^\]]*\]`)
+
+// withoutSelectors names a drift the way the state names its attribute. The
+// state indexes list items by position, so a sensitive field of one device
+// is taken to be sensitive on all of them.
+func withoutSelectors(name string) string {
+	return deviceSelector.ReplaceAllString(name, "")
+}
+
+// sensitiveAttribute reports whether an attribute, or one it is nested in,
+// is sensitive as a whole.
+func sensitiveAttribute(name string, sensitive map[string]map[string]struct{}) bool {
+	for {
+		if _, whole := sensitive[name][""]; whole {
+			return true
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
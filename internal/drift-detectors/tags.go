@@ -0,0 +1,87 @@
+package drift
+
+import (
+	"sort"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/redact"
+)
+
+// expectedTags returns the tags the desired state expects on the instance:
+// tags_all, which adds the provider's default_tags, when the state records
+// it, and tags otherwise.
+func expectedTags(data map[string]interface{}) (map[string]string, bool) {
+	if tagsAll, ok := data["tags_all"].(map[string]string); ok && tagsAll != nil {
+		return tagsAll, true
+	}
+	tags, ok := data["tags"].(map[string]string)
+	return tags, ok
+}
+
+// compareTags reports each added, removed or changed tag as its own drift.
+// Keys the resource sets itself, and keys added out of band, are named
+// tags.<key>; keys inherited from the provider's default tags are named
+// default_tags.<key>. An added tag has no old value and a removed tag no new value.
+func compareTags(oldTags, newTags, resourceTags map[string]string, sensitive map[string]map[string]struct{}) []types.Drift {
+	if areTagsEqual(oldTags, newTags) {
+		return nil
+	}
+
+	keys := make([]string, 0, len(oldTags)+len(newTags))
+	for key := range oldTags {
+		keys = append(keys, key)
+	}
+	for key := range newTags {
+		if _, ok := oldTags[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var drifts []types.Drift
+	for _, key := range keys {
+		oldValue, inOld := oldTags[key]
+		newValue, inNew := newTags[key]
+		if inOld && inNew && oldValue == newValue {
+			continue
+		}
+
+		attribute := "tags"
+		if _, own := resourceTags[key]; inOld && !own {
+			attribute = "default_tags"
+		}
+		d := types.Drift{Name: attribute + "." + key}
+		if inOld {
+			d.OldValue = oldValue
+		}
+		if inNew {
+			d.NewValue = newValue
+		}
+		drifts = append(drifts, sensitiveTag(d, key, sensitive))
+	}
+	return drifts
+}
+
+// sensitiveTag hashes the values of a tag drift when the tag, or all tags,
+// are sensitive on either tags or tags_all.
+func sensitiveTag(d types.Drift, key string, sensitive map[string]map[string]struct{}) types.Drift {
+	if tagSensitive(key, sensitive) {
+		d.OldValue, d.NewValue = redact.Hash(d.OldValue), redact.Hash(d.NewValue)
+		d.Sensitive = true
+	}
+	return d
+}
+
+// tagSensitive reports whether the tag, or all tags, are sensitive on either
+// tags or tags_all.
+func tagSensitive(key string, sensitive map[string]map[string]struct{}) bool {
+	for _, attribute := range []string{"tags", "tags_all"} {
+		keys := sensitive[attribute]
+		_, whole := keys[""]
+		_, tag := keys[key]
+		if whole || tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package drift
+
+import (
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/userdata"
+)
+
+// compareUserData compares user_data. Terraform usually stores only a digest
+// of the raw user data, which is checked against the instance's. When the
+// state records the content, both sides are decoded (gzip, MIME multipart)
+// and normalized before comparing, and a differing script comes with a
+// redacted line-level diff, unless the state marks user_data sensitive itself.
+func compareUserData(old, new types.Resource, sensitive map[string]map[string]struct{}) []types.Drift {
+	oldData := old.Data.(map[string]interface{})
+	newData := new.Data.(map[string]interface{})
+	oldValue, declared := oldData["user_data"].(string)
+	newValue, observed := newData["user_data"].(string)
+	if !declared || !observed {
+		return nil
+	}
+
+	if userdata.IsHash(oldValue) {
+		if userdata.Hash([]byte(newValue)) == oldValue {
+			return nil
+		}
+		return []types.Drift{sensitiveDrift(types.Drift{Name: "user_data", OldValue: oldValue, NewValue: newValue}, sensitive)}
+	}
+
+	if userdata.NormalizedHash([]byte(oldValue)) == userdata.NormalizedHash([]byte(newValue)) {
+		return nil
+	}
+	d := sensitiveDrift(types.Drift{Name: "user_data", OldValue: oldValue, NewValue: newValue}, sensitive)
+	if !markedSensitive(old, "user_data") && !markedSensitive(new, "user_data") {
+		d.Diff = userdata.Diff(
+			userdata.Normalize(userdata.Decode([]byte(oldValue))),
+			userdata.Normalize(userdata.Decode([]byte(newValue))),
+		)
+	}
+	return []types.Drift{d}
+}
+
+// markedSensitive reports whether a resource's own source marks an attribute sensitive.
+func markedSensitive(r types.Resource, attribute string) bool {
+	for _, p := range r.Sensitive {
+		if p == attribute {
+			return true
+		}
+	}
+	return false
+}
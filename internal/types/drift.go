@@ -1,13 +1,41 @@
 package types
 
+// DriftCategory tells configuration drift apart from operational changes.
+type DriftCategory string
+
+const (
+	// DriftConfiguration is a change to how a resource is configured; it is the zero value.
+	DriftConfiguration DriftCategory = ""
+	// DriftOperational is a change of a volatile attribute through normal
+	// operation, such as an instance being stopped or getting a new public IP.
+	DriftOperational DriftCategory = "operational"
+)
+
 type Drift struct {
 	Name     string
 	Type     ResourceType
+	Category DriftCategory
 	OldValue interface{}
 	NewValue interface{}
+	// Sensitive marks drifts whose values were replaced by hashes before leaving the comparator.
+	Sensitive bool
+	// Diff is a line-level diff of text values, with lines prefixed "- ", "+ "
+	// or "  ". It is set when the values themselves are too long or too
+	// sensitive to show, and is redacted already.
+	Diff []string
 }
 
 type DriftGroup struct {
 	ResourceName string
 	Drifts       []Drift
+	// Source and Workspace identify the state the drifted resource came from.
+	Source    string
+	Workspace string
+	// Region, Account and AccountAlias locate the drifted cloud resource.
+	Region       string
+	Account      string
+	AccountAlias string
+	// Parent is the resource that manages the drifted instance, whose launch
+	// specification it was compared against.
+	Parent *ResourceRef
 }
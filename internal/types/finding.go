@@ -0,0 +1,39 @@
+package types
+
+// FindingKind classifies a finding about a resource as a whole, as opposed to
+// the attribute-level differences recorded in a Drift.
+type FindingKind string
+
+const (
+	// FindingMissing marks a resource tracked in state that was not found in the cloud.
+	FindingMissing FindingKind = "missing"
+	// FindingUnmanaged marks a cloud resource that no loaded state owns.
+	FindingUnmanaged FindingKind = "unmanaged"
+	// FindingDoubleManaged marks a cloud resource owned by more than one state entry.
+	FindingDoubleManaged FindingKind = "double_managed"
+	// FindingReplaced marks a resource tracked in state that was replaced
+	// outside of the IaC by a cloud resource with another ID.
+	FindingReplaced FindingKind = "replaced"
+	// FindingDeprecatedImage marks an instance running an AMI that is deprecated or disabled.
+	FindingDeprecatedImage FindingKind = "deprecated_ami"
+	// FindingDeregisteredImage marks an instance running an AMI that was
+	// deregistered or is no longer shared with the account.
+	FindingDeregisteredImage FindingKind = "deregistered_ami"
+)
+
+// Finding reports a resource-level problem such as a resource existing on only one side.
+type Finding struct {
+	Kind         FindingKind
+	ResourceType ResourceType
+	ResourceName string
+	// Region, Account and AccountAlias locate the resource, when known.
+	Region       string
+	Account      string
+	AccountAlias string
+	Message      string
+	// Confidence is how sure the matcher that paired a replaced resource with
+	// its replacement is, from 0 to 1.
+	Confidence float64
+	// Resources holds the resources involved, e.g. the state entry of a missing resource.
+	Resources []Resource
+}
@@ -0,0 +1,14 @@
+package types
+
+// Image describes an AMI.
+type Image struct {
+	ID   string
+	Name string
+	// Owner is the owner's alias, such as amazon, or else its account ID.
+	Owner        string
+	CreationDate string
+	// DeprecationTime is when the AMI is deprecated, in RFC 3339, or "" when it is not scheduled to be.
+	DeprecationTime string
+	// State is the AMI's state, such as available, disabled or deregistered.
+	State string
+}
@@ -1,17 +1,56 @@
 package types
 
+import "fmt"
+
 type ResourceType string
 
 const (
 	EC2Instance ResourceType = "aws_instance"
+
+	// Resources that launch and manage EC2 instances, or describe how they are launched.
+	AutoScalingGroup     ResourceType = "aws_autoscaling_group"
+	LaunchConfiguration  ResourceType = "aws_launch_configuration"
+	EKSNodeGroup         ResourceType = "aws_eks_node_group"
+	SpotFleetRequest     ResourceType = "aws_spot_fleet_request"
+	EC2Fleet             ResourceType = "aws_ec2_fleet"
+	SpotInstanceRequest  ResourceType = "aws_spot_instance_request"
+	BeanstalkEnvironment ResourceType = "aws_elastic_beanstalk_environment"
 )
 
+// ResourceRef names a resource by type and ID.
+type ResourceRef struct {
+	Type ResourceType
+	Name string
+}
+
+func (r ResourceRef) String() string {
+	return fmt.Sprintf("%s %s", r.Type, r.Name)
+}
+
 // Resource represents a resource in the cloud provider
 // eg AWS EC2 instance, GCP compute instance
 type Resource struct {
 	Name string
 	Type ResourceType
 	Data interface{}
+	// Address is the resource's address in its source, e.g. module.app.aws_instance.web[0].
+	Address string
+	// Sensitive lists the attribute paths (e.g. "user_data", "tags.ApiKey") whose values must never be shown.
+	Sensitive []string
+	// Source is the state file or URL a desired-state resource was read from.
+	Source string
+	// Workspace is the Terraform workspace the source state belongs to.
+	Workspace string
+	// Region is the cloud region the resource lives in, or "" when unknown.
+	Region string
+	// ARN is the resource's ARN as its source records it, or "" when unknown.
+	ARN string
+	// Parent is the resource that launched and manages a cloud resource, such
+	// as an Auto Scaling group, or nil for a resource launched on its own.
+	Parent *ResourceRef
+	// Account is the ID of the AWS account the resource lives in, and AccountAlias its alias, when known.
+	Account      string
+	AccountAlias string
 }
 
 func NewResource(name string, ResourceType ResourceType, data interface{}) Resource {
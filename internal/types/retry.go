@@ -0,0 +1,12 @@
+package types
+
+// RetryStat summarises the retried calls to one cloud service in one region.
+type RetryStat struct {
+	Service string
+	Region  string
+	Retries int
+	// Codes counts the retried errors by code, e.g. RequestLimitExceeded.
+	Codes map[string]int
+	// Failed counts the calls that still failed after retrying.
+	Failed int
+}
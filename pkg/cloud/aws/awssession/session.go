@@ -0,0 +1,146 @@
+package awssession
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/papidb/drift-detector/pkg/cloud/aws/resilience"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables that configure the session when the matching flag is not given.
+const (
+	EnvProfile     = "DRIFT_DETECTOR_AWS_PROFILE"
+	EnvRegion      = "DRIFT_DETECTOR_AWS_REGION"
+	EnvEndpointURL = "DRIFT_DETECTOR_AWS_ENDPOINT_URL"
+)
+
+// DefaultRegion is used when neither the options nor the AWS environment or shared config name a region.
+const DefaultRegion = "us-west-2"
+
+// Options selects the credentials, region and endpoint of the AWS session.
+// Empty fields defer to the AWS SDK's own environment variables and shared
+// config, e.g. AWS_PROFILE and AWS_REGION.
+type Options struct {
+	Profile string `yaml:"profile"`
+	Region  string `yaml:"region"`
+	// EndpointURL sends every AWS call to one endpoint, e.g. a local AWS emulator.
+	EndpointURL string `yaml:"endpoint_url"`
+	// Resilience controls retries, rate limiting and timeouts of every call made with the session.
+	Resilience resilience.Config `yaml:"-"`
+}
+
+// configFile is the layout of the tool's configuration file.
+type configFile struct {
+	AWS Options `yaml:"aws"`
+}
+
+// ParseConfigFile reads the aws section of a configuration file:
+//
+//	aws:
+//	  profile: drift
+//	  region: eu-west-1
+//	  endpoint_url: http://localhost:4566
+func ParseConfigFile(data []byte) (Options, error) {
+	var file configFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return Options{}, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return file.AWS, nil
+}
+
+// FromEnv reads options from the DRIFT_DETECTOR_AWS_* environment variables.
+func FromEnv() Options {
+	return Options{
+		Profile:     os.Getenv(EnvProfile),
+		Region:      os.Getenv(EnvRegion),
+		EndpointURL: os.Getenv(EnvEndpointURL),
+	}
+}
+
+// Or returns o with its empty fields taken from fallback.
+func (o Options) Or(fallback Options) Options {
+	if o.Profile == "" {
+		o.Profile = fallback.Profile
+	}
+	if o.Region == "" {
+		o.Region = fallback.Region
+	}
+	if o.EndpointURL == "" {
+		o.EndpointURL = fallback.EndpointURL
+	}
+	return o
+}
+
+// New creates a session from options.
+func New(options Options) (*session.Session, error) {
+	cfg := aws.NewConfig()
+	if options.Region != "" {
+		cfg.WithRegion(options.Region)
+	}
+	if options.EndpointURL != "" {
+		// Emulators serve every bucket from the one endpoint
+		cfg.WithEndpoint(options.EndpointURL).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		Profile:           options.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(DefaultRegion)
+	}
+	resilience.Apply(sess, options.Resilience)
+	return sess, nil
+}
+
+// Provider hands out the AWS session.
+type Provider interface {
+	Session() (*session.Session, error)
+}
+
+type lazyProvider struct {
+	options func() (Options, error)
+	once    sync.Once
+	session *session.Session
+	err     error
+}
+
+// NewLazyProvider creates the session on first use, so runs that make no AWS
+// calls never need credentials. options is only called then, once flags have
+// been parsed.
+func NewLazyProvider(options func() (Options, error)) Provider {
+	return &lazyProvider{options: options}
+}
+
+func (p *lazyProvider) Session() (*session.Session, error) {
+	p.once.Do(func() {
+		options, err := p.options()
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.session, p.err = New(options)
+	})
+	return p.session, p.err
+}
+
+type staticProvider struct {
+	session *session.Session
+}
+
+// NewStaticProvider hands out an existing session.
+func NewStaticProvider(session *session.Session) Provider {
+	return &staticProvider{session: session}
+}
+
+func (p *staticProvider) Session() (*session.Session, error) {
+	return p.session, nil
+}
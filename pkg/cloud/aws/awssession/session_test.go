@@ -0,0 +1,76 @@
+package awssession
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	options, err := ParseConfigFile([]byte(`
+aws:
+  profile: drift
+  region: eu-west-1
+  endpoint_url: http://localhost:4566
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, Options{Profile: "drift", Region: "eu-west-1", EndpointURL: "http://localhost:4566"}, options)
+
+	options, err = ParseConfigFile([]byte(`other: true`))
+	assert.NoError(t, err)
+	assert.Equal(t, Options{}, options)
+
+	_, err = ParseConfigFile([]byte(`aws: [`))
+	assert.ErrorContains(t, err, "failed to parse config file")
+}
+
+func TestOptionsOr(t *testing.T) {
+	t.Setenv(EnvProfile, "env-profile")
+	t.Setenv(EnvRegion, "")
+	t.Setenv(EnvEndpointURL, "http://env:4566")
+
+	options := Options{Region: "us-east-1", EndpointURL: "http://flag:4566"}.
+		Or(FromEnv()).
+		Or(Options{Profile: "file-profile", Region: "eu-west-1"})
+
+	assert.Equal(t, Options{Profile: "env-profile", Region: "us-east-1", EndpointURL: "http://flag:4566"}, options)
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+
+	sess, err := New(Options{EndpointURL: "http://localhost:4566"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRegion, aws.StringValue(sess.Config.Region))
+	assert.Equal(t, "http://localhost:4566", aws.StringValue(sess.Config.Endpoint))
+	assert.True(t, aws.BoolValue(sess.Config.S3ForcePathStyle))
+
+	sess, err = New(Options{Region: "eu-west-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", aws.StringValue(sess.Config.Region))
+	assert.Nil(t, sess.Config.Endpoint)
+}
+
+func TestLazyProvider(t *testing.T) {
+	calls := 0
+	provider := NewLazyProvider(func() (Options, error) {
+		calls++
+		return Options{Region: "eu-west-1"}, nil
+	})
+	assert.Equal(t, 0, calls, "nothing is resolved until the session is needed")
+
+	first, err := provider.Session()
+	assert.NoError(t, err)
+	second, _ := provider.Session()
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, calls)
+
+	failing := NewLazyProvider(func() (Options, error) { return Options{}, errors.New("failed to read config file") })
+	_, err = failing.Session()
+	assert.EqualError(t, err, "failed to read config file")
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/common"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSessionName is the role session name used when an account does not set one.
+const defaultSessionName = "drift-detector"
+
+var accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+// Account is an AWS account scanned by assuming a role in it.
+type Account struct {
+	ID          string   `yaml:"id"`
+	Alias       string   `yaml:"alias"`
+	RoleARN     string   `yaml:"role_arn"`
+	ExternalID  string   `yaml:"external_id"`
+	SessionName string   `yaml:"session_name"`
+	Regions     []string `yaml:"regions"`
+}
+
+// accountsFile is the layout of an accounts configuration.
+type accountsFile struct {
+	Accounts []Account `yaml:"accounts"`
+}
+
+// ParseAccounts reads an accounts configuration, in YAML or JSON:
+//
+//	accounts:
+//	  - id: "111111111111"
+//	    alias: prod
+//	    role_arn: arn:aws:iam::111111111111:role/drift-detector
+//	    external_id: drift
+//	    regions: [us-east-1, eu-west-1]
+//
+// The account ID defaults to the one in the role ARN.
+func ParseAccounts(data []byte) ([]Account, error) {
+	var file accountsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts: %w", err)
+	}
+	if len(file.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts found")
+	}
+
+	seen := make(map[string]struct{})
+	for i := range file.Accounts {
+		account := &file.Accounts[i]
+		if account.RoleARN == "" {
+			return nil, fmt.Errorf("account %d: role_arn is required", i+1)
+		}
+		if account.ID == "" {
+			account.ID = common.AccountFromARN(account.RoleARN)
+		}
+		if !accountIDPattern.MatchString(account.ID) {
+			return nil, fmt.Errorf("account %d: invalid account ID %q", i+1, account.ID)
+		}
+		if _, ok := seen[account.ID]; ok {
+			return nil, fmt.Errorf("account %s is listed more than once", account.ID)
+		}
+		seen[account.ID] = struct{}{}
+		if account.SessionName == "" {
+			account.SessionName = defaultSessionName
+		}
+	}
+	return file.Accounts, nil
+}
+
+// AssumeRole returns a copy of session whose credentials come from assuming
+// the account's role. Credentials are fetched on first use and refreshed
+// before they expire.
+func AssumeRole(session *session.Session, account Account) *session.Session {
+	creds := stscreds.NewCredentials(session, account.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = account.SessionName
+		if account.ExternalID != "" {
+			p.ExternalID = aws.String(account.ExternalID)
+		}
+	})
+	return session.Copy(&aws.Config{Credentials: creds})
+}
+
+// NewAccountEC2Repos creates one repository per account, each listing
+// instances with the account's assumed role in the account's regions, or in
+// regions when the account lists none. Nil regions use the session's region.
+func NewAccountEC2Repos(session *session.Session, accounts []Account, regions []string) []EC2Repository {
+	repos := make([]EC2Repository, 0, len(accounts))
+	for _, account := range accounts {
+		accountSession := AssumeRole(session, account)
+
+		accountRegions := account.Regions
+		if len(accountRegions) == 0 {
+			accountRegions = regions
+		}
+
+		var repo EC2Repository = NewEC2Repo(accountSession)
+		if len(accountRegions) > 0 {
+			repo = NewMultiRegionEC2Repo(NewRegionalEC2Repos(accountSession, accountRegions))
+		}
+		repos = append(repos, &accountEC2Repo{repo: repo, account: account, iam: iam.New(accountSession)})
+	}
+	return repos
+}
+
+type accountEC2Repo struct {
+	repo    EC2Repository
+	account Account
+	// iam looks up the account alias when the configuration does not give one
+	iam iamiface.IAMAPI
+}
+
+func (r *accountEC2Repo) ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error) {
+	instances, err := r.repo.ListInstances(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %w", r.account.ID, err)
+	}
+
+	alias := r.account.Alias
+	if alias == "" && r.iam != nil {
+		// Best effort: the role may not be allowed to list aliases
+		alias, _ = accountAlias(ctx, r.iam)
+	}
+	for i := range instances {
+		instances[i].Account = r.account.ID
+		instances[i].AccountAlias = alias
+	}
+	return instances, nil
+}
+
+// accountAlias returns the account's alias, or "" if it has none.
+func accountAlias(ctx context.Context, client iamiface.IAMAPI) (string, error) {
+	output, err := client.ListAccountAliasesWithContext(ctx, &iam.ListAccountAliasesInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up account alias: %w", err)
+	}
+	if len(output.AccountAliases) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(output.AccountAliases[0]), nil
+}
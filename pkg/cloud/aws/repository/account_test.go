@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockIAMClient is a mock implementation of iamiface.IAMAPI for testing
+type mockIAMClient struct {
+	iamiface.IAMAPI
+	aliases []string
+	err     error
+}
+
+func (m *mockIAMClient) ListAccountAliasesWithContext(ctx aws.Context, input *iam.ListAccountAliasesInput, opts ...request.Option) (*iam.ListAccountAliasesOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &iam.ListAccountAliasesOutput{AccountAliases: aws.StringSlice(m.aliases)}, nil
+}
+
+// staticEC2Repo returns fixed instances.
+type staticEC2Repo struct {
+	instances []types.Resource
+	err       error
+}
+
+func (r *staticEC2Repo) ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error) {
+	return r.instances, r.err
+}
+
+func TestParseAccounts(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expected       []Account
+		expectedErrMsg string
+	}{
+		{
+			name: "yaml",
+			input: `
+accounts:
+  - id: "111111111111"
+    alias: prod
+    role_arn: arn:aws:iam::111111111111:role/drift-detector
+    external_id: drift
+    session_name: nightly
+    regions: [us-east-1, eu-west-1]
+  - role_arn: arn:aws:iam::222222222222:role/drift-detector
+`,
+			expected: []Account{
+				{
+					ID:          "111111111111",
+					Alias:       "prod",
+					RoleARN:     "arn:aws:iam::111111111111:role/drift-detector",
+					ExternalID:  "drift",
+					SessionName: "nightly",
+					Regions:     []string{"us-east-1", "eu-west-1"},
+				},
+				{
+					ID:          "222222222222",
+					RoleARN:     "arn:aws:iam::222222222222:role/drift-detector",
+					SessionName: "drift-detector",
+				},
+			},
+		},
+		{
+			name:  "json",
+			input: `{"accounts": [{"role_arn": "arn:aws:iam::333333333333:role/x"}]}`,
+			expected: []Account{
+				{ID: "333333333333", RoleARN: "arn:aws:iam::333333333333:role/x", SessionName: "drift-detector"},
+			},
+		},
+		{
+			name:           "missing role",
+			input:          `{"accounts": [{"id": "111111111111"}]}`,
+			expectedErrMsg: "account 1: role_arn is required",
+		},
+		{
+			name:           "invalid account ID",
+			input:          `{"accounts": [{"id": "prod", "role_arn": "arn:aws:iam::111111111111:role/x"}]}`,
+			expectedErrMsg: `account 1: invalid account ID "prod"`,
+		},
+		{
+			name: "duplicate account",
+			input: `{"accounts": [{"role_arn": "arn:aws:iam::111111111111:role/a"},
+				{"role_arn": "arn:aws:iam::111111111111:role/b"}]}`,
+			expectedErrMsg: "account 111111111111 is listed more than once",
+		},
+		{
+			name:           "no accounts",
+			input:          `{"accounts": []}`,
+			expectedErrMsg: "no accounts found",
+		},
+		{
+			name:           "invalid document",
+			input:          `accounts: [`,
+			expectedErrMsg: "failed to parse accounts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts, err := ParseAccounts([]byte(tt.input))
+
+			if tt.expectedErrMsg != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMsg)
+				assert.Nil(t, accounts)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, accounts)
+		})
+	}
+}
+
+func TestAccountEC2Repo_ListInstances(t *testing.T) {
+	instances := func() []types.Resource {
+		return []types.Resource{{Name: "i-1", Type: types.EC2Instance, Region: "us-east-1"}}
+	}
+
+	t.Run("configured alias", func(t *testing.T) {
+		repo := &accountEC2Repo{
+			repo:    &staticEC2Repo{instances: instances()},
+			account: Account{ID: "111111111111", Alias: "prod"},
+			iam:     &mockIAMClient{err: errors.New("should not be called")},
+		}
+
+		result, err := repo.ListInstances(context.Background(), InstanceFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, "111111111111", result[0].Account)
+		assert.Equal(t, "prod", result[0].AccountAlias)
+	})
+
+	t.Run("alias looked up", func(t *testing.T) {
+		repo := &accountEC2Repo{
+			repo:    &staticEC2Repo{instances: instances()},
+			account: Account{ID: "111111111111"},
+			iam:     &mockIAMClient{aliases: []string{"staging"}},
+		}
+
+		result, err := repo.ListInstances(context.Background(), InstanceFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, "staging", result[0].AccountAlias)
+	})
+
+	t.Run("alias lookup denied", func(t *testing.T) {
+		repo := &accountEC2Repo{
+			repo:    &staticEC2Repo{instances: instances()},
+			account: Account{ID: "111111111111"},
+			iam:     &mockIAMClient{err: errors.New("AccessDenied")},
+		}
+
+		result, err := repo.ListInstances(context.Background(), InstanceFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, "111111111111", result[0].Account)
+		assert.Empty(t, result[0].AccountAlias)
+	})
+
+	t.Run("listing error names the account", func(t *testing.T) {
+		repo := &accountEC2Repo{
+			repo:    &staticEC2Repo{err: errors.New("failed to fetch EC2 instances in us-east-1: AccessDenied")},
+			account: Account{ID: "111111111111"},
+		}
+
+		_, err := repo.ListInstances(context.Background(), InstanceFilter{})
+		assert.EqualError(t, err, "account 111111111111: failed to fetch EC2 instances in us-east-1: AccessDenied")
+	})
+}
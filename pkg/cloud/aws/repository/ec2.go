@@ -5,47 +5,150 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/common"
+	"golang.org/x/sync/errgroup"
 )
 
 // EC2Repository is an interface that allows us do what ever we want t
 type EC2Repository interface {
-	ListInstances(ctx context.Context) ([]types.Resource, error)
+	ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error)
 }
 
 type ec2Repo struct {
 	client ec2iface.EC2API
+	region string
 }
 
 func NewEC2Repo(session *session.Session) *ec2Repo {
 	client := ec2.New(session)
 	return &ec2Repo{
 		client: client,
+		region: aws.StringValue(session.Config.Region),
 	}
 }
 
-func (r *ec2Repo) ListInstances(ctx context.Context) ([]types.Resource, error) {
-	output, err := r.client.DescribeInstances(&ec2.DescribeInstancesInput{})
+// NewRegionalEC2Repos creates one repository per region, all sharing the session's credentials.
+func NewRegionalEC2Repos(session *session.Session, regions []string) []EC2Repository {
+	repos := make([]EC2Repository, 0, len(regions))
+	for _, region := range regions {
+		repos = append(repos, &ec2Repo{
+			client: ec2.New(session, aws.NewConfig().WithRegion(region)),
+			region: region,
+		})
+	}
+	return repos
+}
+
+// EnabledRegions lists the regions enabled for the account, for scanning all of them.
+func EnabledRegions(ctx context.Context, session *session.Session) ([]string, error) {
+	return enabledRegions(ctx, ec2.New(session))
+}
+
+func enabledRegions(ctx context.Context, client ec2iface.EC2API) ([]string, error) {
+	output, err := client.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch EC2 instances: %w", err)
+		return nil, fmt.Errorf("failed to list enabled regions: %w", err)
+	}
+
+	regions := make([]string, 0, len(output.Regions))
+	for _, region := range output.Regions {
+		regions = append(regions, aws.StringValue(region.RegionName))
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// describeInstancesPageSize is the largest page DescribeInstances accepts.
+const describeInstancesPageSize = 1000
+
+func (r *ec2Repo) ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters:    filter.ec2Filters(),
+		MaxResults: aws.Int64(describeInstancesPageSize),
 	}
 
-	// do a transformation
+	// do a transformation, page by page
 	var instances []types.Resource
-	for _, reservation := range output.Reservations {
-		for _, instance := range reservation.Instances {
-			instances = append(instances, eC2InstanceToResource(instance))
+	err := r.client.DescribeInstancesPagesWithContext(ctx, input, func(output *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				resource := eC2InstanceToResource(instance, awsString(reservation.OwnerId))
+				if r.region != "" {
+					resource.Region = r.region
+				}
+				instances = append(instances, resource)
+			}
 		}
+		return true
+	})
+	if err == nil {
+		err = r.describeInstanceAttributes(ctx, instances, filter)
+	}
+	if err == nil {
+		err = r.describeVolumes(ctx, instances)
+	}
+	if err == nil {
+		err = r.describeLaunchTemplates(ctx, instances)
+	}
+	if err == nil {
+		err = r.describeImages(ctx, instances, filter)
+	}
+	if err != nil {
+		if r.region != "" {
+			return nil, fmt.Errorf("failed to fetch EC2 instances in %s: %w", r.region, err)
+		}
+		return nil, fmt.Errorf("failed to fetch EC2 instances: %w", err)
 	}
 	// return the transformed resource
 	return instances, nil
 }
 
+type multiRegionEC2Repo struct {
+	repos []EC2Repository
+}
+
+// listRegionsConcurrency bounds the repositories listed at once.
+const listRegionsConcurrency = 8
+
+// NewMultiRegionEC2Repo lists instances from every given repository
+// concurrently, typically one per region, and merges the results in order.
+// The first failure cancels the listings still running.
+func NewMultiRegionEC2Repo(repos []EC2Repository) EC2Repository {
+	return &multiRegionEC2Repo{repos: repos}
+}
+
+func (r *multiRegionEC2Repo) ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error) {
+	results := make([][]types.Resource, len(r.repos))
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(listRegionsConcurrency)
+	for i, repo := range r.repos {
+		i, repo := i, repo
+		group.Go(func() error {
+			instances, err := repo.ListInstances(groupCtx, filter)
+			results[i] = instances
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	var instances []types.Resource
+	for _, result := range results {
+		instances = append(instances, result...)
+	}
+	return instances, nil
+}
+
 type jsonEC2Repo struct {
 	reader io.Reader
 }
@@ -54,7 +157,7 @@ func NewJSONEC2Repo(reader io.Reader) *jsonEC2Repo {
 	return &jsonEC2Repo{reader: reader}
 }
 
-func (r *jsonEC2Repo) ListInstances(ctx context.Context) ([]types.Resource, error) {
+func (r *jsonEC2Repo) ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error) {
 	var output ec2.DescribeInstancesOutput
 	if err := json.NewDecoder(r.reader).Decode(&output); err != nil {
 		return nil, fmt.Errorf("failed to decode JSON EC2 data: %w", err)
@@ -63,7 +166,9 @@ func (r *jsonEC2Repo) ListInstances(ctx context.Context) ([]types.Resource, erro
 	var instances []types.Resource
 	for _, reservation := range output.Reservations {
 		for _, instance := range reservation.Instances {
-			instances = append(instances, eC2InstanceToResource(instance))
+			if resource := eC2InstanceToResource(instance, awsString(reservation.OwnerId)); filter.Matches(resource) {
+				instances = append(instances, resource)
+			}
 		}
 	}
 
@@ -72,7 +177,9 @@ func (r *jsonEC2Repo) ListInstances(ctx context.Context) ([]types.Resource, erro
 
 // helpers
 
-func eC2InstanceToResource(instance *ec2.Instance) types.Resource {
+// eC2InstanceToResource normalizes an instance of the reservation owned by
+// ownerID, the account the instance belongs to.
+func eC2InstanceToResource(instance *ec2.Instance, ownerID string) types.Resource {
 	data := map[string]interface{}{
 		"instance_id":       awsString(instance.InstanceId),
 		"instance_type":     awsString(instance.InstanceType),
@@ -86,10 +193,29 @@ func eC2InstanceToResource(instance *ec2.Instance) types.Resource {
 		"public_ip":         awsString(instance.PublicIpAddress),
 		"security_groups":   securityGroupsToSlice(instance.SecurityGroups),
 	}
+	addInstanceSettings(data, instance)
+	addBlockDevices(data, instance)
+	addNetworkInterfaces(data, instance)
+	addLaunchTemplate(data, instance)
 
-	// name := awsString(findTag(instance.Tags, "Name"))
+	resource := types.NewResource(awsString(instance.InstanceId), types.EC2Instance, data)
+	resource.Region = common.RegionFromAvailabilityZone(awsString(instance.Placement.AvailabilityZone))
+	resource.ARN = instanceARN(resource.Region, ownerID, resource.Name)
+	resource.Parent = instanceParent(instance)
+	return resource
+}
 
-	return types.NewResource(awsString(instance.InstanceId), types.EC2Instance, data)
+// instanceARN builds an instance's ARN, which DescribeInstances leaves out, or
+// returns "" when the region or account is unknown.
+func instanceARN(region, account, id string) string {
+	if region == "" || account == "" {
+		return ""
+	}
+	partition := endpoints.AwsPartitionID
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		partition = p.ID()
+	}
+	return fmt.Sprintf("arn:%s:ec2:%s:%s:instance/%s", partition, region, account, id)
 }
 
 // awsString safely dereferences an AWS SDK *string value.
@@ -5,12 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/cloud/aws/awssession"
+	"github.com/papidb/drift-detector/pkg/userdata"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,10 +28,170 @@ type mockEC2Client struct {
 	ec2iface.EC2API
 	describeInstancesOutput *ec2.DescribeInstancesOutput
 	describeInstancesErr    error
+	// pages, when set, are returned one per page instead of describeInstancesOutput
+	pages  []*ec2.DescribeInstancesOutput
+	inputs []*ec2.DescribeInstancesInput
+
+	regions []string
+
+	// attributes are the DescribeInstanceAttribute values of every instance
+	attributes   map[string]bool
+	attributeErr error
+	// attributeCalls counts DescribeInstanceAttribute calls by instance ID and attribute
+	attributeMu    sync.Mutex
+	attributeCalls map[string]int
+	// userData is the base64-encoded user data of every instance
+	userData string
+	// cpuCredits are the credit specifications by instance ID
+	cpuCredits   map[string]string
+	creditInputs []*ec2.DescribeInstanceCreditSpecificationsInput
+
+	volumes []*ec2.Volume
+
+	// launchTemplates are returned by ID; a missing ID is not found
+	launchTemplates        map[string]*ec2.LaunchTemplate
+	launchTemplateVersions []*ec2.LaunchTemplateVersion
+	versionInputs          []*ec2.DescribeLaunchTemplateVersionsInput
+
+	images      []*ec2.Image
+	imageInputs []*ec2.DescribeImagesInput
+}
+
+func (m *mockEC2Client) DescribeImagesPagesWithContext(ctx aws.Context, input *ec2.DescribeImagesInput, fn func(*ec2.DescribeImagesOutput, bool) bool, opts ...request.Option) error {
+	m.imageInputs = append(m.imageInputs, input)
+	requested := make(map[string]bool)
+	for _, filter := range input.Filters {
+		for _, id := range filter.Values {
+			requested[aws.StringValue(id)] = true
+		}
+	}
+	output := &ec2.DescribeImagesOutput{}
+	for _, image := range m.images {
+		if requested[aws.StringValue(image.ImageId)] {
+			output.Images = append(output.Images, image)
+		}
+	}
+	fn(output, true)
+	return nil
 }
 
-func (m *mockEC2Client) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-	return m.describeInstancesOutput, m.describeInstancesErr
+func (m *mockEC2Client) DescribeLaunchTemplatesWithContext(ctx aws.Context, input *ec2.DescribeLaunchTemplatesInput, opts ...request.Option) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	output := &ec2.DescribeLaunchTemplatesOutput{}
+	for _, id := range input.LaunchTemplateIds {
+		template, ok := m.launchTemplates[aws.StringValue(id)]
+		if !ok {
+			return nil, awserr.New("InvalidLaunchTemplateId.NotFound", "not found", nil)
+		}
+		output.LaunchTemplates = append(output.LaunchTemplates, template)
+	}
+	return output, nil
+}
+
+func (m *mockEC2Client) DescribeLaunchTemplateVersionsPagesWithContext(ctx aws.Context, input *ec2.DescribeLaunchTemplateVersionsInput, fn func(*ec2.DescribeLaunchTemplateVersionsOutput, bool) bool, opts ...request.Option) error {
+	m.versionInputs = append(m.versionInputs, input)
+	requested := make(map[string]bool)
+	for _, version := range input.Versions {
+		requested[aws.StringValue(version)] = true
+	}
+	output := &ec2.DescribeLaunchTemplateVersionsOutput{}
+	for _, version := range m.launchTemplateVersions {
+		if aws.StringValue(version.LaunchTemplateId) == aws.StringValue(input.LaunchTemplateId) &&
+			requested[strconv.FormatInt(aws.Int64Value(version.VersionNumber), 10)] {
+			output.LaunchTemplateVersions = append(output.LaunchTemplateVersions, version)
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+func (m *mockEC2Client) DescribeVolumesPagesWithContext(ctx aws.Context, input *ec2.DescribeVolumesInput, fn func(*ec2.DescribeVolumesOutput, bool) bool, opts ...request.Option) error {
+	requested := make(map[string]bool)
+	for _, id := range input.VolumeIds {
+		requested[aws.StringValue(id)] = true
+	}
+	output := &ec2.DescribeVolumesOutput{}
+	for _, volume := range m.volumes {
+		if requested[aws.StringValue(volume.VolumeId)] {
+			output.Volumes = append(output.Volumes, volume)
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+func (m *mockEC2Client) DescribeInstanceAttributeWithContext(ctx aws.Context, input *ec2.DescribeInstanceAttributeInput, opts ...request.Option) (*ec2.DescribeInstanceAttributeOutput, error) {
+	m.attributeMu.Lock()
+	if m.attributeCalls == nil {
+		m.attributeCalls = make(map[string]int)
+	}
+	m.attributeCalls[aws.StringValue(input.InstanceId)+"/"+aws.StringValue(input.Attribute)]++
+	m.attributeMu.Unlock()
+	if m.attributeErr != nil {
+		return nil, m.attributeErr
+	}
+	output := &ec2.DescribeInstanceAttributeOutput{InstanceId: input.InstanceId}
+	if aws.StringValue(input.Attribute) == ec2.InstanceAttributeNameUserData {
+		output.UserData = &ec2.AttributeValue{Value: aws.String(m.userData)}
+		return output, nil
+	}
+	value, ok := m.attributes[aws.StringValue(input.Attribute)]
+	if !ok {
+		return output, nil
+	}
+	switch aws.StringValue(input.Attribute) {
+	case ec2.InstanceAttributeNameDisableApiTermination:
+		output.DisableApiTermination = &ec2.AttributeBooleanValue{Value: aws.Bool(value)}
+	case ec2.InstanceAttributeNameDisableApiStop:
+		output.DisableApiStop = &ec2.AttributeBooleanValue{Value: aws.Bool(value)}
+	}
+	return output, nil
+}
+
+func (m *mockEC2Client) DescribeInstanceCreditSpecificationsPagesWithContext(ctx aws.Context, input *ec2.DescribeInstanceCreditSpecificationsInput, fn func(*ec2.DescribeInstanceCreditSpecificationsOutput, bool) bool, opts ...request.Option) error {
+	m.creditInputs = append(m.creditInputs, input)
+	output := &ec2.DescribeInstanceCreditSpecificationsOutput{}
+	for _, id := range input.InstanceIds {
+		if credits, ok := m.cpuCredits[aws.StringValue(id)]; ok {
+			output.InstanceCreditSpecifications = append(output.InstanceCreditSpecifications, &ec2.InstanceCreditSpecification{
+				InstanceId: id,
+				CpuCredits: aws.String(credits),
+			})
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+func (m *mockEC2Client) DescribeRegionsWithContext(ctx aws.Context, input *ec2.DescribeRegionsInput, opts ...request.Option) (*ec2.DescribeRegionsOutput, error) {
+	if m.describeInstancesErr != nil {
+		return nil, m.describeInstancesErr
+	}
+	output := &ec2.DescribeRegionsOutput{}
+	for _, region := range m.regions {
+		output.Regions = append(output.Regions, &ec2.Region{RegionName: aws.String(region)})
+	}
+	return output, nil
+}
+
+func (m *mockEC2Client) DescribeInstancesPagesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	m.inputs = append(m.inputs, input)
+	if m.describeInstancesErr != nil {
+		return m.describeInstancesErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pages := m.pages
+	if pages == nil {
+		pages = []*ec2.DescribeInstancesOutput{m.describeInstancesOutput}
+	}
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
 }
 
 func TestEC2Repo_ListInstances(t *testing.T) {
@@ -46,7 +215,20 @@ func TestEC2Repo_ListInstances(t *testing.T) {
 		SecurityGroups: []*ec2.GroupIdentifier{
 			{GroupId: aws.String("sg-12345678")},
 		},
+		SourceDestCheck:    aws.Bool(false),
+		EbsOptimized:       aws.Bool(true),
+		Monitoring:         &ec2.Monitoring{State: aws.String("pending")},
+		HibernationOptions: &ec2.HibernationOptions{Configured: aws.Bool(false)},
+		IamInstanceProfile: &ec2.IamInstanceProfile{Arn: aws.String("arn:aws:iam::111111111111:instance-profile/app/web")},
+		MetadataOptions: &ec2.InstanceMetadataOptionsResponse{
+			HttpEndpoint:            aws.String("enabled"),
+			HttpTokens:              aws.String("required"),
+			HttpPutResponseHopLimit: aws.Int64(2),
+		},
+		CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(1), ThreadsPerCore: aws.Int64(2)},
 	}
+	instance.Placement.Tenancy = aws.String("default")
+	instance.Placement.GroupName = aws.String("cluster-a")
 
 	tests := []struct {
 		name           string
@@ -63,7 +245,7 @@ func TestEC2Repo_ListInstances(t *testing.T) {
 				},
 			},
 			expected: []types.Resource{
-				types.NewResource(
+				withRegion(types.NewResource(
 					"i-1234567890abcdef0",
 					types.EC2Instance,
 					map[string]interface{}{
@@ -78,11 +260,28 @@ func TestEC2Repo_ListInstances(t *testing.T) {
 							"Name":        "test-instance",
 							"Environment": "prod",
 						},
-						"private_ip":      "10.0.0.1",
-						"public_ip":       "203.0.113.1",
-						"security_groups": []string{"sg-12345678"},
+						"private_ip":                     "10.0.0.1",
+						"public_ip":                      "203.0.113.1",
+						"security_groups":                []string{"sg-12345678"},
+						"source_dest_check":              false,
+						"ebs_optimized":                  true,
+						"monitoring":                     true,
+						"hibernation":                    false,
+						"iam_instance_profile":           "web",
+						"image":                          types.Image{ID: "ami-12345678", State: "deregistered"},
+						"tenancy":                        "default",
+						"placement_group":                "cluster-a",
+						"metadata_options.http_endpoint": "enabled",
+						"metadata_options.http_tokens":   "required",
+						"metadata_options.http_put_response_hop_limit": int64(2),
+						"cpu_options.core_count":                       int64(1),
+						"cpu_options.threads_per_core":                 int64(2),
+						"disable_api_termination":                      true,
+						"disable_api_stop":                             false,
+						"credit_specification.cpu_credits":             "unlimited",
+						"user_data":                                    "#!/bin/bash\necho hi\n",
 					},
-				),
+				), "us-west-2"),
 			},
 		},
 		{
@@ -103,13 +302,19 @@ func TestEC2Repo_ListInstances(t *testing.T) {
 			mockClient := &mockEC2Client{
 				describeInstancesOutput: tt.output,
 				describeInstancesErr:    tt.err,
+				attributes: map[string]bool{
+					ec2.InstanceAttributeNameDisableApiTermination: true,
+					ec2.InstanceAttributeNameDisableApiStop:        false,
+				},
+				cpuCredits: map[string]string{"i-1234567890abcdef0": "unlimited"},
+				userData:   "IyEvYmluL2Jhc2gKZWNobyBoaQo=",
 			}
 
 			// Create repository
 			repo := &ec2Repo{client: mockClient}
 
 			// Call ListInstances
-			result, err := repo.ListInstances(ctx)
+			result, err := repo.ListInstances(ctx, InstanceFilter{})
 
 			// Check error
 			if tt.expectedErrMsg != "" {
@@ -125,6 +330,320 @@ func TestEC2Repo_ListInstances(t *testing.T) {
 	}
 }
 
+func TestEC2Repo_ListInstancesRecordedAttributes(t *testing.T) {
+	instance := func(id, instanceType string, tags ...*ec2.Tag) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String(instanceType),
+			State:        &ec2.InstanceState{Name: aws.String("running")},
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-west-2a")},
+			Tags:         tags,
+		}
+	}
+	mockClient := &mockEC2Client{
+		describeInstancesOutput: &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+				instance("i-tracked", "t3.micro"),
+				instance("i-unmanaged", "t3.micro"),
+				instance("i-asg", "m5.large", &ec2.Tag{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("web-asg")}),
+			}}},
+		},
+		attributes: map[string]bool{ec2.InstanceAttributeNameDisableApiTermination: true},
+		cpuCredits: map[string]string{"i-tracked": "unlimited", "i-unmanaged": "standard"},
+	}
+	filter := InstanceFilter{
+		Recorded:       map[string][]string{"i-tracked": {"instance_type", "disable_api_termination", "credit_specification.cpu_credits"}},
+		ParentRecorded: map[types.ResourceRef][]string{{Type: types.AutoScalingGroup, Name: "web-asg"}: {"user_data"}},
+	}
+
+	result, err := (&ec2Repo{client: mockClient}).ListInstances(context.Background(), filter)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"i-tracked/disableApiTermination": 1,
+		"i-asg/userData":                  1,
+	}, mockClient.attributeCalls, "only the attributes the state records, of the instances it tracks")
+	assert.Len(t, mockClient.creditInputs, 1)
+	assert.Equal(t, []*string{aws.String("i-tracked")}, mockClient.creditInputs[0].InstanceIds)
+	assert.Equal(t, true, result[0].Data.(map[string]interface{})["disable_api_termination"])
+	assert.NotContains(t, result[1].Data, "disable_api_termination")
+}
+
+func TestEC2Repo_ListInstancesBlockDevices(t *testing.T) {
+	instance := &ec2.Instance{
+		InstanceId:     aws.String("i-1"),
+		InstanceType:   aws.String("m5.large"),
+		State:          &ec2.InstanceState{Name: aws.String("running")},
+		Placement:      &ec2.Placement{AvailabilityZone: aws.String("us-west-2a")},
+		RootDeviceName: aws.String("/dev/xvda"),
+		BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root"), DeleteOnTermination: aws.Bool(true)}},
+			{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data"), DeleteOnTermination: aws.Bool(false)}},
+		},
+	}
+	mockClient := &mockEC2Client{
+		describeInstancesOutput: &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance}}},
+		},
+		volumes: []*ec2.Volume{
+			{VolumeId: aws.String("vol-root"), Size: aws.Int64(50), VolumeType: aws.String("gp3"), Iops: aws.Int64(3000), Throughput: aws.Int64(125), Encrypted: aws.Bool(false)},
+			{VolumeId: aws.String("vol-data"), Size: aws.Int64(100), VolumeType: aws.String("io2"), Iops: aws.Int64(4000), Encrypted: aws.Bool(true), KmsKeyId: aws.String("arn:aws:kms:us-west-2:111111111111:key/k")},
+		},
+	}
+
+	result, err := (&ec2Repo{client: mockClient}).ListInstances(context.Background(), InstanceFilter{})
+
+	assert.NoError(t, err)
+	data := result[0].Data.(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"device_name":           "/dev/xvda",
+		"volume_id":             "vol-root",
+		"delete_on_termination": true,
+		"volume_size":           int64(50),
+		"volume_type":           "gp3",
+		"iops":                  int64(3000),
+		"throughput":            int64(125),
+		"encrypted":             false,
+		"kms_key_id":            "",
+	}, data["root_block_device"])
+	assert.Equal(t, map[string]map[string]interface{}{
+		"/dev/sdf": {
+			"device_name":           "/dev/sdf",
+			"volume_id":             "vol-data",
+			"delete_on_termination": false,
+			"volume_size":           int64(100),
+			"volume_type":           "io2",
+			"iops":                  int64(4000),
+			"encrypted":             true,
+			"kms_key_id":            "arn:aws:kms:us-west-2:111111111111:key/k",
+		},
+	}, data["ebs_block_device"])
+}
+
+func TestEC2Repo_ListInstancesLaunchTemplates(t *testing.T) {
+	launched := func(id, template, version string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId: aws.String(id),
+			State:      &ec2.InstanceState{Name: aws.String("running")},
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-west-2a")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("aws:ec2launchtemplate:id"), Value: aws.String(template)},
+				{Key: aws.String("aws:ec2launchtemplate:version"), Value: aws.String(version)},
+			},
+		}
+	}
+	mockClient := &mockEC2Client{
+		describeInstancesOutput: &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+				launched("i-1", "lt-1", "1"),
+				launched("i-2", "lt-deleted", "4"),
+				{InstanceId: aws.String("i-3"), State: &ec2.InstanceState{Name: aws.String("running")}, Placement: &ec2.Placement{}},
+			}}},
+		},
+		launchTemplates: map[string]*ec2.LaunchTemplate{
+			"lt-1": {LaunchTemplateId: aws.String("lt-1"), LatestVersionNumber: aws.Int64(3), DefaultVersionNumber: aws.Int64(1)},
+		},
+		launchTemplateVersions: []*ec2.LaunchTemplateVersion{
+			{LaunchTemplateId: aws.String("lt-1"), VersionNumber: aws.Int64(1), LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+				InstanceType:    aws.String("t3.micro"),
+				MetadataOptions: &ec2.LaunchTemplateInstanceMetadataOptions{HttpTokens: aws.String("required")},
+			}},
+			{LaunchTemplateId: aws.String("lt-1"), VersionNumber: aws.Int64(3), LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+				InstanceType:    aws.String("t3.large"),
+				MetadataOptions: &ec2.LaunchTemplateInstanceMetadataOptions{HttpTokens: aws.String("required")},
+				UserData:        aws.String("aGk="),
+				BlockDeviceMappings: []*ec2.LaunchTemplateBlockDeviceMapping{
+					{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.LaunchTemplateEbsBlockDevice{VolumeSize: aws.Int64(20)}},
+				},
+			}},
+		},
+	}
+
+	result, err := (&ec2Repo{client: mockClient}).ListInstances(context.Background(), InstanceFilter{})
+
+	assert.NoError(t, err)
+	assert.Len(t, mockClient.versionInputs, 1)
+	assert.Equal(t, []*string{aws.String("1"), aws.String("3")}, mockClient.versionInputs[0].Versions)
+
+	data := result[0].Data.(map[string]interface{})
+	assert.Equal(t, "lt-1", data["launch_template.id"])
+	assert.Equal(t, "1", data["launch_template.version"])
+	assert.Equal(t, "3", data["launch_template.latest_version"])
+	assert.Equal(t, "1", data["launch_template.default_version"])
+	assert.Equal(t, map[string]map[string]string{
+		"1": {"InstanceType": "t3.micro", "MetadataOptions.HttpTokens": "required"},
+		"3": {
+			"InstanceType":                          "t3.large",
+			"MetadataOptions.HttpTokens":            "required",
+			"UserData":                              "sha1:" + userdata.Hash([]byte("hi")),
+			"BlockDeviceMappings[0].DeviceName":     "/dev/xvda",
+			"BlockDeviceMappings[0].Ebs.VolumeSize": "20",
+		},
+	}, data["launch_template.versions"])
+
+	deleted := result[1].Data.(map[string]interface{})
+	assert.Equal(t, "lt-deleted", deleted["launch_template.id"])
+	assert.NotContains(t, deleted, "launch_template.latest_version")
+
+	assert.NotContains(t, result[2].Data, "launch_template.id")
+}
+
+func TestEC2Repo_ListInstancesImages(t *testing.T) {
+	mockClient := &mockEC2Client{
+		describeInstancesOutput: &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+				{InstanceId: aws.String("i-1"), ImageId: aws.String("ami-new"), State: &ec2.InstanceState{Name: aws.String("running")}, Placement: &ec2.Placement{}},
+				{InstanceId: aws.String("i-2"), ImageId: aws.String("ami-gone"), State: &ec2.InstanceState{Name: aws.String("running")}, Placement: &ec2.Placement{}},
+			}}},
+		},
+		images: []*ec2.Image{
+			{ImageId: aws.String("ami-new"), Name: aws.String("web-2024-06-01"), ImageOwnerAlias: aws.String("amazon"), OwnerId: aws.String("137112412989"), CreationDate: aws.String("2024-06-01T10:00:00.000Z"), State: aws.String("available")},
+			{ImageId: aws.String("ami-old"), Name: aws.String("web-2024-01-01"), OwnerId: aws.String("111111111111"), DeprecationTime: aws.String("2024-07-01T00:00:00.000Z"), State: aws.String("available")},
+		},
+	}
+
+	filter := InstanceFilter{ExpectedImages: map[string]string{"i-1": "ami-old", "i-2": "ami-gone"}}
+	result, err := (&ec2Repo{client: mockClient}).ListInstances(context.Background(), filter)
+
+	assert.NoError(t, err)
+	if assert.Len(t, mockClient.imageInputs, 1) {
+		input := mockClient.imageInputs[0]
+		assert.Equal(t, aws.StringSlice([]string{"ami-gone", "ami-new", "ami-old"}), input.Filters[0].Values)
+		assert.True(t, aws.BoolValue(input.IncludeDeprecated))
+	}
+	first := result[0].Data.(map[string]interface{})
+	assert.Equal(t, types.Image{ID: "ami-new", Name: "web-2024-06-01", Owner: "amazon", CreationDate: "2024-06-01T10:00:00.000Z", State: "available"}, first["image"])
+	assert.Equal(t, types.Image{ID: "ami-old", Name: "web-2024-01-01", Owner: "111111111111", DeprecationTime: "2024-07-01T00:00:00.000Z", State: "available"}, first["expected_image"])
+	second := result[1].Data.(map[string]interface{})
+	assert.Equal(t, types.Image{ID: "ami-gone", State: "deregistered"}, second["image"], "an AMI that is not found was deregistered")
+	assert.NotContains(t, second, "expected_image", "the instance runs the expected AMI")
+}
+
+func TestAddNetworkInterfaces(t *testing.T) {
+	instance := &ec2.Instance{
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{
+				NetworkInterfaceId: aws.String("eni-primary"),
+				SubnetId:           aws.String("subnet-1"),
+				PrivateIpAddress:   aws.String("10.0.0.1"),
+				PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+					{PrivateIpAddress: aws.String("10.0.0.1"), Primary: aws.Bool(true)},
+					{PrivateIpAddress: aws.String("10.0.0.9"), Primary: aws.Bool(false)},
+					{PrivateIpAddress: aws.String("10.0.0.5"), Primary: aws.Bool(false)},
+				},
+				Groups:      []*ec2.GroupIdentifier{{GroupId: aws.String("sg-2")}, {GroupId: aws.String("sg-1")}},
+				Association: &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("203.0.113.1"), IpOwnerId: aws.String("amazon")},
+				Attachment:  &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0), DeleteOnTermination: aws.Bool(true)},
+			},
+			{
+				NetworkInterfaceId: aws.String("eni-extra"),
+				SubnetId:           aws.String("subnet-2"),
+				PrivateIpAddress:   aws.String("10.0.1.1"),
+				Ipv6Addresses:      []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::1")}},
+				Association:        &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("198.51.100.7"), IpOwnerId: aws.String("111111111111")},
+				Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1), DeleteOnTermination: aws.Bool(false)},
+			},
+		},
+	}
+
+	data := map[string]interface{}{}
+	addNetworkInterfaces(data, instance)
+
+	assert.Equal(t, map[string]map[string]interface{}{
+		"0": {
+			"network_interface_id":  "eni-primary",
+			"subnet_id":             "subnet-1",
+			"private_ip":            "10.0.0.1",
+			"secondary_private_ips": []string{"10.0.0.5", "10.0.0.9"},
+			"ipv6_addresses":        []string{},
+			"security_groups":       []string{"sg-1", "sg-2"},
+			"elastic_ip":            "",
+			"delete_on_termination": true,
+		},
+		"1": {
+			"network_interface_id":  "eni-extra",
+			"subnet_id":             "subnet-2",
+			"private_ip":            "10.0.1.1",
+			"secondary_private_ips": []string{},
+			"ipv6_addresses":        []string{"2001:db8::1"},
+			"security_groups":       []string{},
+			"elastic_ip":            "198.51.100.7",
+			"delete_on_termination": false,
+		},
+	}, data["network_interface"])
+
+	data = map[string]interface{}{}
+	addNetworkInterfaces(data, &ec2.Instance{})
+	assert.NotContains(t, data, "network_interface", "exports without interfaces are not compared")
+}
+
+func TestInstanceParent(t *testing.T) {
+	tag := func(key, value string) *ec2.Tag {
+		return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+	}
+
+	tests := []struct {
+		name     string
+		instance *ec2.Instance
+		expected *types.ResourceRef
+	}{
+		{
+			name:     "launched on its own",
+			instance: &ec2.Instance{Tags: []*ec2.Tag{tag("Name", "web")}},
+		},
+		{
+			name:     "auto scaling group",
+			instance: &ec2.Instance{Tags: []*ec2.Tag{tag("aws:autoscaling:groupName", "web-asg")}},
+			expected: &types.ResourceRef{Type: types.AutoScalingGroup, Name: "web-asg"},
+		},
+		{
+			name: "eks node group wins over its auto scaling group",
+			instance: &ec2.Instance{Tags: []*ec2.Tag{
+				tag("aws:autoscaling:groupName", "eks-workers-1234"),
+				tag("eks:cluster-name", "prod"),
+				tag("eks:nodegroup-name", "workers"),
+			}},
+			expected: &types.ResourceRef{Type: types.EKSNodeGroup, Name: "prod:workers"},
+		},
+		{
+			name: "elastic beanstalk environment",
+			instance: &ec2.Instance{Tags: []*ec2.Tag{
+				tag("aws:autoscaling:groupName", "awseb-e-abc123-stack-AWSEBAutoScalingGroup"),
+				tag("elasticbeanstalk:environment-id", "e-abc123"),
+			}},
+			expected: &types.ResourceRef{Type: types.BeanstalkEnvironment, Name: "e-abc123"},
+		},
+		{
+			name: "spot fleet instance",
+			instance: &ec2.Instance{
+				InstanceLifecycle:     aws.String(ec2.InstanceLifecycleTypeSpot),
+				SpotInstanceRequestId: aws.String("sir-1"),
+				Tags:                  []*ec2.Tag{tag("aws:ec2spot:fleet-request-id", "sfr-1")},
+			},
+			expected: &types.ResourceRef{Type: types.SpotFleetRequest, Name: "sfr-1"},
+		},
+		{
+			name:     "ec2 fleet",
+			instance: &ec2.Instance{Tags: []*ec2.Tag{tag("aws:ec2:fleet-id", "fleet-1")}},
+			expected: &types.ResourceRef{Type: types.EC2Fleet, Name: "fleet-1"},
+		},
+		{
+			name: "spot instance request",
+			instance: &ec2.Instance{
+				InstanceLifecycle:     aws.String(ec2.InstanceLifecycleTypeSpot),
+				SpotInstanceRequestId: aws.String("sir-1"),
+			},
+			expected: &types.ResourceRef{Type: types.SpotInstanceRequest, Name: "sir-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, instanceParent(tt.instance))
+		})
+	}
+}
+
 func TestJSONEC2Repo_ListInstances(t *testing.T) {
 	ctx := context.Background()
 
@@ -166,7 +685,7 @@ func TestJSONEC2Repo_ListInstances(t *testing.T) {
 			name:  "valid JSON",
 			input: string(jsonData),
 			expected: []types.Resource{
-				types.NewResource(
+				withRegion(types.NewResource(
 					"i-1234567890abcdef0",
 					types.EC2Instance,
 					map[string]interface{}{
@@ -181,8 +700,11 @@ func TestJSONEC2Repo_ListInstances(t *testing.T) {
 						"private_ip":        "10.0.0.1",
 						"public_ip":         "203.0.113.1",
 						"security_groups":   []string{"sg-12345678"},
+						// No profile or placement group in the export means there is none
+						"iam_instance_profile": "",
+						"placement_group":      "",
 					},
-				),
+				), "us-west-2"),
 			},
 		},
 		{
@@ -199,7 +721,7 @@ func TestJSONEC2Repo_ListInstances(t *testing.T) {
 			repo := NewJSONEC2Repo(reader)
 
 			// Call ListInstances
-			result, err := repo.ListInstances(ctx)
+			result, err := repo.ListInstances(ctx, InstanceFilter{})
 
 			// Check error
 			if tt.expectedErrMsg != "" {
@@ -245,6 +767,21 @@ func TestHelperFunctions(t *testing.T) {
 		assert.Nil(t, findTag(nil, "Name"))
 	})
 
+	t.Run("isBurstable", func(t *testing.T) {
+		for _, instanceType := range []string{"t2.micro", "t3.large", "t3a.nano", "t4g.small"} {
+			assert.True(t, isBurstable(instanceType), instanceType)
+		}
+		for _, instanceType := range []string{"trn1.2xlarge", "trn2.48xlarge", "m5.large", "t3"} {
+			assert.False(t, isBurstable(instanceType), instanceType)
+		}
+	})
+
+	t.Run("instanceARN", func(t *testing.T) {
+		assert.Equal(t, "arn:aws:ec2:us-east-1:111111111111:instance/i-1", instanceARN("us-east-1", "111111111111", "i-1"))
+		assert.Equal(t, "arn:aws-cn:ec2:cn-north-1:111111111111:instance/i-1", instanceARN("cn-north-1", "111111111111", "i-1"))
+		assert.Empty(t, instanceARN("us-east-1", "", "i-1"), "unknown account")
+	})
+
 	t.Run("securityGroupsToSlice", func(t *testing.T) {
 		groups := []*ec2.GroupIdentifier{
 			{GroupId: aws.String("sg-1")},
@@ -256,3 +793,237 @@ func TestHelperFunctions(t *testing.T) {
 		assert.Empty(t, securityGroupsToSlice(nil))
 	})
 }
+
+func TestEC2Repo_ListInstancesPaginatesAndFilters(t *testing.T) {
+	page := func(ids ...string) *ec2.DescribeInstancesOutput {
+		var instances []*ec2.Instance
+		for _, id := range ids {
+			instances = append(instances, &ec2.Instance{
+				InstanceId: aws.String(id),
+				State:      &ec2.InstanceState{Name: aws.String("running")},
+				Placement:  &ec2.Placement{},
+			})
+		}
+		return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: instances}}}
+	}
+
+	mockClient := &mockEC2Client{pages: []*ec2.DescribeInstancesOutput{page("i-1", "i-2"), page("i-3")}}
+	repo := &ec2Repo{client: mockClient}
+
+	result, err := repo.ListInstances(context.Background(), InstanceFilter{
+		InstanceIDs: []string{"i-1", "i-3"},
+		Tags:        map[string][]string{"Env": {"prod", "staging"}, "Team": nil},
+	})
+
+	assert.NoError(t, err)
+	var names []string
+	for _, res := range result {
+		names = append(names, res.Name)
+	}
+	assert.Equal(t, []string{"i-1", "i-2", "i-3"}, names, "every page is collected")
+
+	assert.Len(t, mockClient.inputs, 1)
+	assert.Equal(t, &ec2.DescribeInstancesInput{
+		MaxResults: aws.Int64(1000),
+		Filters: []*ec2.Filter{
+			{Name: aws.String("instance-id"), Values: aws.StringSlice([]string{"i-1", "i-3"})},
+			{Name: aws.String("tag:Env"), Values: aws.StringSlice([]string{"prod", "staging"})},
+			{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{"Team"})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running", "shutting-down", "stopping", "stopped"})},
+		},
+	}, mockClient.inputs[0])
+
+	t.Run("terminated included on request", func(t *testing.T) {
+		mockClient := &mockEC2Client{describeInstancesOutput: page()}
+		_, err := (&ec2Repo{client: mockClient}).ListInstances(context.Background(), InstanceFilter{IncludeTerminated: true})
+		assert.NoError(t, err)
+		assert.Empty(t, mockClient.inputs[0].Filters)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := (&ec2Repo{client: &mockEC2Client{describeInstancesOutput: page()}}).ListInstances(ctx, InstanceFilter{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestMultiRegionEC2Repo_ListInstances(t *testing.T) {
+	page := func(id, zone string) *ec2.DescribeInstancesOutput {
+		return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{
+			InstanceId: aws.String(id),
+			State:      &ec2.InstanceState{Name: aws.String("running")},
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String(zone)},
+		}}}}}
+	}
+
+	repo := NewMultiRegionEC2Repo([]EC2Repository{
+		&ec2Repo{client: &mockEC2Client{describeInstancesOutput: page("i-east", "us-east-1a")}, region: "us-east-1"},
+		&ec2Repo{client: &mockEC2Client{describeInstancesOutput: page("i-west", "eu-west-1b")}, region: "eu-west-1"},
+	})
+
+	result, err := repo.ListInstances(context.Background(), InstanceFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, "i-east", result[0].Name)
+	assert.Equal(t, "us-east-1", result[0].Region)
+	assert.Equal(t, "i-west", result[1].Name)
+	assert.Equal(t, "eu-west-1", result[1].Region)
+
+	t.Run("a failing region fails the listing", func(t *testing.T) {
+		repo := NewMultiRegionEC2Repo([]EC2Repository{
+			&ec2Repo{client: &mockEC2Client{describeInstancesOutput: page("i-east", "us-east-1a")}, region: "us-east-1"},
+			&ec2Repo{client: &mockEC2Client{describeInstancesErr: errors.New("UnauthorizedOperation")}, region: "ap-east-1"},
+		})
+
+		result, err := repo.ListInstances(context.Background(), InstanceFilter{})
+		assert.Nil(t, result)
+		assert.EqualError(t, err, "failed to fetch EC2 instances in ap-east-1: UnauthorizedOperation")
+	})
+
+	t.Run("a failing region cancels the others", func(t *testing.T) {
+		repo := NewMultiRegionEC2Repo([]EC2Repository{
+			blockingEC2Repo{},
+			&ec2Repo{client: &mockEC2Client{describeInstancesErr: errors.New("UnauthorizedOperation")}, region: "ap-east-1"},
+		})
+
+		_, err := repo.ListInstances(context.Background(), InstanceFilter{})
+		assert.EqualError(t, err, "failed to fetch EC2 instances in ap-east-1: UnauthorizedOperation")
+	})
+}
+
+// blockingEC2Repo lists nothing until its context is cancelled.
+type blockingEC2Repo struct{}
+
+func (blockingEC2Repo) ListInstances(ctx context.Context, filter InstanceFilter) ([]types.Resource, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestEnabledRegions(t *testing.T) {
+	regions, err := enabledRegions(context.Background(), &mockEC2Client{regions: []string{"us-west-2", "eu-west-1", "us-east-1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1", "us-east-1", "us-west-2"}, regions)
+
+	_, err = enabledRegions(context.Background(), &mockEC2Client{describeInstancesErr: errors.New("denied")})
+	assert.EqualError(t, err, "failed to list enabled regions: denied")
+}
+
+// TestEC2Repo_ListInstancesAgainstEndpoint runs the live repository against
+// a stand-in EC2 endpoint, the way it would run against a local AWS emulator.
+func TestEC2Repo_ListInstancesAgainstEndpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.PostForm.Get("Action") {
+		case "DescribeInstanceAttribute":
+			_, _ = w.Write([]byte(`<DescribeInstanceAttributeResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <instanceId>i-1</instanceId>
+  <disableApiTermination><value>true</value></disableApiTermination>
+</DescribeInstanceAttributeResponse>`))
+			return
+		case "DescribeImages":
+			_, _ = w.Write([]byte(`<DescribeImagesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <imagesSet>
+    <item><imageId>ami-1</imageId><name>web-2024-06-01</name><imageOwnerId>111111111111</imageOwnerId><imageState>available</imageState></item>
+  </imagesSet>
+</DescribeImagesResponse>`))
+			return
+		case "DescribeInstanceCreditSpecifications":
+			_, _ = w.Write([]byte(`<DescribeInstanceCreditSpecificationsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <instanceCreditSpecificationSet>
+    <item><instanceId>i-1</instanceId><cpuCredits>standard</cpuCredits></item>
+  </instanceCreditSpecificationSet>
+</DescribeInstanceCreditSpecificationsResponse>`))
+			return
+		}
+		form = r.PostForm
+		_, _ = w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>req-1</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-1</reservationId>
+      <instancesSet>
+        <item>
+          <instanceId>i-1</instanceId>
+          <imageId>ami-1</imageId>
+          <instanceType>t3.micro</instanceType>
+          <instanceState><code>16</code><name>running</name></instanceState>
+          <placement><availabilityZone>eu-west-1a</availabilityZone></placement>
+          <tagSet><item><key>Name</key><value>web</value></item></tagSet>
+        </item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>`))
+	}))
+	defer server.Close()
+
+	sess, err := awssession.New(awssession.Options{Region: "eu-west-1", EndpointURL: server.URL})
+	assert.NoError(t, err)
+
+	result, err := NewEC2Repo(sess).ListInstances(context.Background(), InstanceFilter{InstanceIDs: []string{"i-1"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "DescribeInstances", form.Get("Action"))
+	assert.Equal(t, "instance-id", form.Get("Filter.1.Name"))
+	assert.Equal(t, "i-1", form.Get("Filter.1.Value.1"))
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, "i-1", result[0].Name)
+		assert.Equal(t, "eu-west-1", result[0].Region)
+		data := result[0].Data.(map[string]interface{})
+		assert.Equal(t, "t3.micro", data["instance_type"])
+		assert.Equal(t, map[string]string{"Name": "web"}, data["tags"])
+		assert.Equal(t, true, data["disable_api_termination"])
+		assert.Equal(t, "standard", data["credit_specification.cpu_credits"])
+		assert.Equal(t, types.Image{ID: "ami-1", Name: "web-2024-06-01", Owner: "111111111111", State: "available"}, data["image"])
+	}
+}
+
+func TestInstanceFilter_Matches(t *testing.T) {
+	resource := func(id, state string, tags map[string]string) types.Resource {
+		return types.NewResource(id, types.EC2Instance, map[string]interface{}{"state": state, "tags": tags})
+	}
+	web := resource("i-1", "running", map[string]string{"Env": "prod", "Team": "web"})
+	gone := resource("i-2", "terminated", map[string]string{"Env": "prod"})
+
+	tests := []struct {
+		name     string
+		filter   InstanceFilter
+		expected []types.Resource
+	}{
+		{name: "terminated excluded by default", filter: InstanceFilter{}, expected: []types.Resource{web}},
+		{name: "terminated included", filter: InstanceFilter{IncludeTerminated: true}, expected: []types.Resource{web, gone}},
+		{name: "instance IDs", filter: InstanceFilter{InstanceIDs: []string{"i-2"}, IncludeTerminated: true}, expected: []types.Resource{gone}},
+		{name: "tag value", filter: InstanceFilter{Tags: map[string][]string{"Env": {"dev", "prod"}}}, expected: []types.Resource{web}},
+		{name: "tag value mismatch", filter: InstanceFilter{Tags: map[string][]string{"Env": {"dev"}}}},
+		{name: "tag key", filter: InstanceFilter{Tags: map[string][]string{"Team": nil}}, expected: []types.Resource{web}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.filter.Select([]types.Resource{web, gone}))
+		})
+	}
+}
+
+func TestParseTagSelectors(t *testing.T) {
+	tags, err := ParseTagSelectors([]string{"Env=prod", "Env=staging", "Team", "Empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"Env": {"prod", "staging"}, "Team": nil, "Empty": {""}}, tags)
+
+	_, err = ParseTagSelectors([]string{"=prod"})
+	assert.EqualError(t, err, `invalid tag selector "=prod": expected Key=Value or Key`)
+}
+
+// withRegion sets the region eC2InstanceToResource derives from the availability zone.
+func withRegion(res types.Resource, region string) types.Resource {
+	res.Region = region
+	return res
+}
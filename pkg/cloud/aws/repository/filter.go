@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// liveInstanceStates lists every instance state but terminated, which
+// DescribeInstances keeps returning for up to an hour after termination.
+var liveInstanceStates = []string{
+	ec2.InstanceStateNamePending,
+	ec2.InstanceStateNameRunning,
+	ec2.InstanceStateNameShuttingDown,
+	ec2.InstanceStateNameStopping,
+	ec2.InstanceStateNameStopped,
+}
+
+// InstanceFilter selects the instances to list. Zero values select everything
+// except terminated instances.
+type InstanceFilter struct {
+	InstanceIDs []string
+	// Tags maps tag keys to accepted values; a key without values only requires the tag to exist.
+	Tags              map[string][]string
+	IncludeTerminated bool
+	// ExpectedImages maps the instances the desired state tracks, by ID, to
+	// the AMI it expects them to run. ParentExpectedImages does the same for
+	// the instances launched by a resource it tracks. These AMIs are described
+	// along with those the instances run; they do not narrow the selection.
+	ExpectedImages       map[string]string
+	ParentExpectedImages map[types.ResourceRef]string
+	// Recorded maps the instances the desired state tracks, by ID, to the
+	// attribute keys it records for them. ParentRecorded does the same for
+	// the instances launched by a resource it tracks. Attributes that need a
+	// call per instance are only described when recorded; when both are nil,
+	// they are described for every instance.
+	Recorded       map[string][]string
+	ParentRecorded map[types.ResourceRef][]string
+}
+
+// recorded returns whether the desired state records an attribute of instance.
+func (f InstanceFilter) recorded(instance types.Resource) func(key string) bool {
+	if f.Recorded == nil && f.ParentRecorded == nil {
+		return func(string) bool { return true }
+	}
+	keys := f.Recorded[instance.Name]
+	if instance.Parent != nil {
+		keys = append(append([]string{}, keys...), f.ParentRecorded[*instance.Parent]...)
+	}
+	return func(key string) bool { return contains(keys, key) }
+}
+
+// expectedImage returns the AMI the desired state expects instance to run, or "".
+func (f InstanceFilter) expectedImage(instance types.Resource) string {
+	if ami := f.ExpectedImages[instance.Name]; ami != "" {
+		return ami
+	}
+	if instance.Parent != nil {
+		return f.ParentExpectedImages[*instance.Parent]
+	}
+	return ""
+}
+
+// ParseTagSelectors parses Key=Value and Key selectors. Repeating a key accepts any of its values.
+func ParseTagSelectors(selectors []string) (map[string][]string, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+
+	tags := make(map[string][]string)
+	for _, selector := range selectors {
+		key, value, hasValue := strings.Cut(selector, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid tag selector %q: expected Key=Value or Key", selector)
+		}
+		if _, ok := tags[key]; !ok {
+			tags[key] = nil
+		}
+		if hasValue {
+			tags[key] = append(tags[key], value)
+		}
+	}
+	return tags, nil
+}
+
+// ec2Filters translates the filter into DescribeInstances filters. Instance IDs
+// are sent as an instance-id filter rather than InstanceIds, which fails the
+// whole call with InvalidInstanceID.NotFound when one instance no longer exists.
+func (f InstanceFilter) ec2Filters() []*ec2.Filter {
+	var filters []*ec2.Filter
+	if len(f.InstanceIDs) > 0 {
+		filters = append(filters, &ec2.Filter{Name: aws.String("instance-id"), Values: aws.StringSlice(f.InstanceIDs)})
+	}
+
+	keys := make([]string, 0, len(f.Tags))
+	for key := range f.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if values := f.Tags[key]; len(values) > 0 {
+			filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + key), Values: aws.StringSlice(values)})
+			continue
+		}
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{key})})
+	}
+
+	if !f.IncludeTerminated {
+		filters = append(filters, &ec2.Filter{Name: aws.String("instance-state-name"), Values: aws.StringSlice(liveInstanceStates)})
+	}
+	return filters
+}
+
+// Matches reports whether a normalized EC2 resource passes the filter. It is
+// used where the server-side filters are not available, such as JSON input and
+// the desired-state side of a comparison.
+func (f InstanceFilter) Matches(res types.Resource) bool {
+	if len(f.InstanceIDs) > 0 && !contains(f.InstanceIDs, res.Name) {
+		return false
+	}
+
+	data, _ := res.Data.(map[string]interface{})
+	if !f.IncludeTerminated && data["state"] == ec2.InstanceStateNameTerminated {
+		return false
+	}
+
+	tags, _ := data["tags"].(map[string]string)
+	for key, values := range f.Tags {
+		value, ok := tags[key]
+		if !ok || (len(values) > 0 && !contains(values, value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the resources that pass the filter.
+func (f InstanceFilter) Select(resources []types.Resource) []types.Resource {
+	var selected []types.Resource
+	for _, res := range resources {
+		if f.Matches(res) {
+			selected = append(selected, res)
+		}
+	}
+	return selected
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
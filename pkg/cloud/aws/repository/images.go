@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// describeImagesBatchSize bounds the values of the image-id filter in one DescribeImages call.
+const describeImagesBatchSize = 200
+
+// describeImages describes the AMIs the instances run and the ones the
+// filter expects them to run. Each instance gets its own AMI as image and,
+// when it differs, the expected one as expected_image. An AMI that is not
+// found was deregistered or is no longer shared with the account, and is
+// recorded as deregistered. AMIs are looked up with a filter rather than by
+// ID, so unknown IDs, such as those of another region, are left out instead
+// of failing the call.
+func (r *ec2Repo) describeImages(ctx context.Context, instances []types.Resource, filter InstanceFilter) error {
+	if len(instances) == 0 {
+		return nil
+	}
+
+	requested := make(map[string]bool)
+	for _, instance := range instances {
+		if data, ok := instance.Data.(map[string]interface{}); ok {
+			if ami, _ := data["ami"].(string); ami != "" {
+				requested[ami] = true
+			}
+		}
+		if expected := filter.expectedImage(instance); expected != "" {
+			requested[expected] = true
+		}
+	}
+	ids := make([]string, 0, len(requested))
+	for id := range requested {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	images := make(map[string]types.Image)
+	for start := 0; start < len(ids); start += describeImagesBatchSize {
+		end := min(start+describeImagesBatchSize, len(ids))
+		input := &ec2.DescribeImagesInput{
+			Filters:           []*ec2.Filter{{Name: aws.String("image-id"), Values: aws.StringSlice(ids[start:end])}},
+			IncludeDeprecated: aws.Bool(true),
+			IncludeDisabled:   aws.Bool(true),
+		}
+		err := r.client.DescribeImagesPagesWithContext(ctx, input, func(output *ec2.DescribeImagesOutput, lastPage bool) bool {
+			for _, image := range output.Images {
+				images[awsString(image.ImageId)] = imageToType(image)
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe AMIs: %w", err)
+		}
+	}
+
+	for _, instance := range instances {
+		data, ok := instance.Data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ami, _ := data["ami"].(string)
+		if ami != "" {
+			image, found := images[ami]
+			if !found {
+				image = types.Image{ID: ami, State: ec2.ImageStateDeregistered}
+			}
+			data["image"] = image
+		}
+		if expected := filter.expectedImage(instance); expected != "" && expected != ami {
+			if image, found := images[expected]; found {
+				data["expected_image"] = image
+			}
+		}
+	}
+	return nil
+}
+
+func imageToType(image *ec2.Image) types.Image {
+	owner := awsString(image.ImageOwnerAlias)
+	if owner == "" {
+		owner = awsString(image.OwnerId)
+	}
+	return types.Image{
+		ID:              awsString(image.ImageId),
+		Name:            awsString(image.Name),
+		Owner:           owner,
+		CreationDate:    awsString(image.CreationDate),
+		DeprecationTime: awsString(image.DeprecationTime),
+		State:           awsString(image.State),
+	}
+}
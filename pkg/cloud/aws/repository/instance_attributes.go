@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/userdata"
+	"golang.org/x/sync/errgroup"
+)
+
+// describedAttribute is an instance attribute DescribeInstances leaves out,
+// which DescribeInstanceAttribute returns one at a time. value returns nil
+// when the response does not carry the attribute.
+type describedAttribute struct {
+	name  string
+	key   string
+	value func(*ec2.DescribeInstanceAttributeOutput) interface{}
+}
+
+var describedAttributes = []describedAttribute{
+	{
+		name: ec2.InstanceAttributeNameDisableApiTermination,
+		key:  "disable_api_termination",
+		value: func(o *ec2.DescribeInstanceAttributeOutput) interface{} {
+			return booleanAttribute(o.DisableApiTermination)
+		},
+	},
+	{
+		name: ec2.InstanceAttributeNameDisableApiStop,
+		key:  "disable_api_stop",
+		value: func(o *ec2.DescribeInstanceAttributeOutput) interface{} {
+			return booleanAttribute(o.DisableApiStop)
+		},
+	},
+	{
+		name:  ec2.InstanceAttributeNameUserData,
+		key:   "user_data",
+		value: func(o *ec2.DescribeInstanceAttributeOutput) interface{} { return userData(o.UserData) },
+	},
+}
+
+func booleanAttribute(attribute *ec2.AttributeBooleanValue) interface{} {
+	if attribute == nil || attribute.Value == nil {
+		return nil
+	}
+	return *attribute.Value
+}
+
+// userData returns the raw user data, which AWS returns base64-encoded. An
+// instance without user data gets an empty value.
+func userData(attribute *ec2.AttributeValue) interface{} {
+	if attribute == nil || attribute.Value == nil {
+		return ""
+	}
+	raw, err := userdata.DecodeBase64(*attribute.Value)
+	if err != nil {
+		return *attribute.Value
+	}
+	return string(raw)
+}
+
+// creditSpecificationBatchSize bounds the instance IDs sent in one
+// DescribeInstanceCreditSpecifications call.
+const creditSpecificationBatchSize = 100
+
+// addInstanceSettings adds the settings DescribeInstances reports besides the
+// basic identity fields. Settings missing from the response, e.g. from an
+// older JSON export, are left out so they are not reported as drift.
+func addInstanceSettings(data map[string]interface{}, instance *ec2.Instance) {
+	setBool(data, "source_dest_check", instance.SourceDestCheck)
+	setBool(data, "ebs_optimized", instance.EbsOptimized)
+
+	if instance.Monitoring != nil && instance.Monitoring.State != nil {
+		state := aws.StringValue(instance.Monitoring.State)
+		data["monitoring"] = state == ec2.MonitoringStateEnabled || state == ec2.MonitoringStatePending
+	}
+	if instance.HibernationOptions != nil {
+		setBool(data, "hibernation", instance.HibernationOptions.Configured)
+	}
+
+	// An instance without a profile or placement group has none in the response
+	profile := ""
+	if instance.IamInstanceProfile != nil {
+		profile = instanceProfileName(awsString(instance.IamInstanceProfile.Arn))
+	}
+	data["iam_instance_profile"] = profile
+	if instance.Placement != nil {
+		data["placement_group"] = awsString(instance.Placement.GroupName)
+		setString(data, "tenancy", instance.Placement.Tenancy)
+	}
+
+	if options := instance.MetadataOptions; options != nil {
+		setString(data, "metadata_options.http_endpoint", options.HttpEndpoint)
+		setString(data, "metadata_options.http_tokens", options.HttpTokens)
+		setInt(data, "metadata_options.http_put_response_hop_limit", options.HttpPutResponseHopLimit)
+		setString(data, "metadata_options.http_protocol_ipv6", options.HttpProtocolIpv6)
+		setString(data, "metadata_options.instance_metadata_tags", options.InstanceMetadataTags)
+	}
+	if options := instance.CpuOptions; options != nil {
+		setInt(data, "cpu_options.core_count", options.CoreCount)
+		setInt(data, "cpu_options.threads_per_core", options.ThreadsPerCore)
+	}
+}
+
+// describeAttributesConcurrency bounds the instances whose attributes are
+// described at once.
+const describeAttributesConcurrency = 8
+
+// burstableType matches the burstable performance instance types (t2, t3,
+// t3a, t4g), the only ones with a CPU credit specification.
+var burstableType = regexp.MustCompile(`^t\d+[a-z]*\.`)
+
+// describeInstanceAttributes adds the attributes that need a call per
+// instance, or per batch of burstable instances for their CPU credits.
+// Terminated instances no longer have them. When the filter says which
+// attributes the desired state records, only those are described, and only
+// for the instances it tracks.
+func (r *ec2Repo) describeInstanceAttributes(ctx context.Context, instances []types.Resource, filter InstanceFilter) error {
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(describeAttributesConcurrency)
+
+	// Each instance's attributes are collected apart and added once all calls are done
+	described := make([]map[string]interface{}, len(instances))
+	var burstable []*string
+	for i, instance := range instances {
+		data, ok := instance.Data.(map[string]interface{})
+		if !ok || data["state"] == ec2.InstanceStateNameTerminated || data["state"] == ec2.InstanceStateNameShuttingDown {
+			continue
+		}
+		recorded := filter.recorded(instance)
+
+		var attributes []describedAttribute
+		for _, attribute := range describedAttributes {
+			if recorded(attribute.key) {
+				attributes = append(attributes, attribute)
+			}
+		}
+		if len(attributes) > 0 {
+			i, name := i, instance.Name
+			group.Go(func() error {
+				values := make(map[string]interface{}, len(attributes))
+				for _, attribute := range attributes {
+					output, err := r.client.DescribeInstanceAttributeWithContext(groupCtx, &ec2.DescribeInstanceAttributeInput{
+						InstanceId: aws.String(name),
+						Attribute:  aws.String(attribute.name),
+					})
+					if err != nil {
+						return fmt.Errorf("failed to describe %s of %s: %w", attribute.name, name, err)
+					}
+					if value := attribute.value(output); value != nil {
+						values[attribute.key] = value
+					}
+				}
+				described[i] = values
+				return nil
+			})
+		}
+
+		if instanceType, _ := data["instance_type"].(string); isBurstable(instanceType) && recorded("credit_specification.cpu_credits") {
+			burstable = append(burstable, aws.String(instance.Name))
+		}
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+	for i, values := range described {
+		for key, value := range values {
+			instances[i].Data.(map[string]interface{})[key] = value
+		}
+	}
+
+	credits := make(map[string]string)
+	for start := 0; start < len(burstable); start += creditSpecificationBatchSize {
+		end := min(start+creditSpecificationBatchSize, len(burstable))
+		input := &ec2.DescribeInstanceCreditSpecificationsInput{InstanceIds: burstable[start:end]}
+		err := r.client.DescribeInstanceCreditSpecificationsPagesWithContext(ctx, input, func(output *ec2.DescribeInstanceCreditSpecificationsOutput, lastPage bool) bool {
+			for _, spec := range output.InstanceCreditSpecifications {
+				credits[awsString(spec.InstanceId)] = awsString(spec.CpuCredits)
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe CPU credit specifications: %w", err)
+		}
+	}
+	for _, instance := range instances {
+		if cpuCredits, ok := credits[instance.Name]; ok {
+			instance.Data.(map[string]interface{})["credit_specification.cpu_credits"] = cpuCredits
+		}
+	}
+	return nil
+}
+
+// isBurstable reports whether instanceType is a burstable performance type.
+func isBurstable(instanceType string) bool {
+	return burstableType.MatchString(instanceType)
+}
+
+// instanceProfileName returns the name of an instance profile from its ARN,
+// e.g. web from arn:aws:iam::111111111111:instance-profile/app/web. Terraform
+// records the name.
+func instanceProfileName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func setBool(data map[string]interface{}, key string, value *bool) {
+	if value != nil {
+		data[key] = *value
+	}
+}
+
+func setString(data map[string]interface{}, key string, value *string) {
+	if value != nil {
+		data[key] = *value
+	}
+}
+
+func setInt(data map[string]interface{}, key string, value *int64) {
+	if value != nil {
+		data[key] = *value
+	}
+}
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/userdata"
+)
+
+// Tags EC2 adds to instances launched from a launch template.
+const (
+	launchTemplateIDTag      = "aws:ec2launchtemplate:id"
+	launchTemplateVersionTag = "aws:ec2launchtemplate:version"
+)
+
+// errLaunchTemplateNotFound is the error code of a deleted launch template.
+const errLaunchTemplateNotFound = "InvalidLaunchTemplateId.NotFound"
+
+// addLaunchTemplate adds the launch template the instance was launched from,
+// as launch_template.id and launch_template.version.
+func addLaunchTemplate(data map[string]interface{}, instance *ec2.Instance) {
+	id := findTag(instance.Tags, launchTemplateIDTag)
+	if id == nil {
+		return
+	}
+	data["launch_template.id"] = *id
+	data["launch_template.version"] = awsString(findTag(instance.Tags, launchTemplateVersionTag))
+}
+
+// describeLaunchTemplates adds the current latest and default version numbers
+// of the instances' launch templates, and the contents of the versions
+// involved as launch_template.versions, so a moved $Latest or $Default can be
+// explained field by field. Deleted templates are skipped.
+func (r *ec2Repo) describeLaunchTemplates(ctx context.Context, instances []types.Resource) error {
+	byTemplate := make(map[string][]map[string]interface{})
+	for _, instance := range instances {
+		data, ok := instance.Data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, _ := data["launch_template.id"].(string); id != "" {
+			byTemplate[id] = append(byTemplate[id], data)
+		}
+	}
+
+	ids := make([]string, 0, len(byTemplate))
+	for id := range byTemplate {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		output, err := r.client.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
+			LaunchTemplateIds: []*string{aws.String(id)},
+		})
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == errLaunchTemplateNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to describe launch template %s: %w", id, err)
+		}
+		if len(output.LaunchTemplates) == 0 {
+			continue
+		}
+		template := output.LaunchTemplates[0]
+		latest := strconv.FormatInt(aws.Int64Value(template.LatestVersionNumber), 10)
+		defaultVersion := strconv.FormatInt(aws.Int64Value(template.DefaultVersionNumber), 10)
+
+		wanted := map[string]bool{latest: true, defaultVersion: true}
+		for _, data := range byTemplate[id] {
+			if version, _ := data["launch_template.version"].(string); version != "" {
+				wanted[version] = true
+			}
+		}
+		versions, err := r.describeLaunchTemplateVersions(ctx, id, wanted)
+		if err != nil {
+			return err
+		}
+
+		for _, data := range byTemplate[id] {
+			data["launch_template.latest_version"] = latest
+			data["launch_template.default_version"] = defaultVersion
+			data["launch_template.versions"] = versions
+		}
+	}
+	return nil
+}
+
+// describeLaunchTemplateVersions returns the flattened contents of the wanted
+// versions of a launch template, keyed by version number.
+func (r *ec2Repo) describeLaunchTemplateVersions(ctx context.Context, id string, wanted map[string]bool) (map[string]map[string]string, error) {
+	input := &ec2.DescribeLaunchTemplateVersionsInput{LaunchTemplateId: aws.String(id)}
+	for version := range wanted {
+		input.Versions = append(input.Versions, aws.String(version))
+	}
+	sort.Slice(input.Versions, func(i, j int) bool {
+		return aws.StringValue(input.Versions[i]) < aws.StringValue(input.Versions[j])
+	})
+
+	versions := make(map[string]map[string]string)
+	var flattenErr error
+	err := r.client.DescribeLaunchTemplateVersionsPagesWithContext(ctx, input, func(output *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+		for _, version := range output.LaunchTemplateVersions {
+			fields, err := launchTemplateFields(version.LaunchTemplateData)
+			if err != nil {
+				flattenErr = err
+				return false
+			}
+			versions[strconv.FormatInt(aws.Int64Value(version.VersionNumber), 10)] = fields
+		}
+		return true
+	})
+	if err == nil {
+		err = flattenErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe versions of launch template %s: %w", id, err)
+	}
+	return versions, nil
+}
+
+// launchTemplateFields flattens launch template data into its set fields,
+// keyed by their path such as MetadataOptions.HttpTokens or
+// BlockDeviceMappings[0].Ebs.VolumeSize. User data is replaced by its digest.
+func launchTemplateFields(data *ec2.ResponseLaunchTemplateData) (map[string]string, error) {
+	fields := make(map[string]string)
+	if data == nil {
+		return fields, nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	flattenFields(fields, "", decoded)
+
+	if encodedUserData, ok := fields["UserData"]; ok {
+		if raw, err := userdata.DecodeBase64(encodedUserData); err == nil {
+			fields["UserData"] = "sha1:" + userdata.Hash(raw)
+		}
+	}
+	return fields, nil
+}
+
+func flattenFields(fields map[string]string, path string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, child := range v {
+			flattenFields(fields, strings.TrimPrefix(path+"."+key, "."), child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenFields(fields, fmt.Sprintf("%s[%d]", path, i), child)
+		}
+	default:
+		fields[path] = fmt.Sprint(v)
+	}
+}
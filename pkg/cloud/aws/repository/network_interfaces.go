@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// amazonOwner is the IpOwnerId of public addresses AWS assigns itself; any
+// other owner means an Elastic IP.
+const amazonOwner = "amazon"
+
+// addNetworkInterfaces adds the instance's network interfaces as
+// network_interface, keyed by device index. DescribeInstances already embeds
+// every field compared here: the ID, subnet, private, secondary and IPv6
+// addresses, security groups, the association's owner and public IP, and the
+// attachment's device index and delete_on_termination. So no
+// DescribeNetworkInterfaces call is made.
+func addNetworkInterfaces(data map[string]interface{}, instance *ec2.Instance) {
+	if instance.NetworkInterfaces == nil {
+		return
+	}
+
+	interfaces := make(map[string]map[string]interface{})
+	for _, eni := range instance.NetworkInterfaces {
+		if eni == nil || eni.Attachment == nil || eni.Attachment.DeviceIndex == nil {
+			continue
+		}
+
+		secondaryIPs := []string{}
+		for _, address := range eni.PrivateIpAddresses {
+			if address != nil && !aws.BoolValue(address.Primary) {
+				secondaryIPs = append(secondaryIPs, awsString(address.PrivateIpAddress))
+			}
+		}
+		ipv6Addresses := []string{}
+		for _, address := range eni.Ipv6Addresses {
+			if address != nil {
+				ipv6Addresses = append(ipv6Addresses, awsString(address.Ipv6Address))
+			}
+		}
+		groups := securityGroupsToSlice(eni.Groups)
+		if groups == nil {
+			groups = []string{}
+		}
+		sort.Strings(secondaryIPs)
+		sort.Strings(ipv6Addresses)
+		sort.Strings(groups)
+
+		elasticIP := ""
+		if eni.Association != nil && awsString(eni.Association.IpOwnerId) != amazonOwner {
+			elasticIP = awsString(eni.Association.PublicIp)
+		}
+
+		device := map[string]interface{}{
+			"network_interface_id":  awsString(eni.NetworkInterfaceId),
+			"subnet_id":             awsString(eni.SubnetId),
+			"private_ip":            awsString(eni.PrivateIpAddress),
+			"secondary_private_ips": secondaryIPs,
+			"ipv6_addresses":        ipv6Addresses,
+			"security_groups":       groups,
+			"elastic_ip":            elasticIP,
+		}
+		setBool(device, "delete_on_termination", eni.Attachment.DeleteOnTermination)
+		interfaces[strconv.FormatInt(*eni.Attachment.DeviceIndex, 10)] = device
+	}
+	data["network_interface"] = interfaces
+}
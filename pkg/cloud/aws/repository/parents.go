@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// parentTag is a system tag naming the service resource that launched an
+// instance, as that resource's Terraform ID.
+type parentTag struct {
+	key          string
+	resourceType types.ResourceType
+}
+
+// parentTags are checked in order, so a service's own resource wins over the
+// Auto Scaling group it creates underneath, e.g. an EKS node group's or an
+// Elastic Beanstalk environment's.
+var parentTags = []parentTag{
+	{key: "elasticbeanstalk:environment-id", resourceType: types.BeanstalkEnvironment},
+	{key: "eks:nodegroup-name", resourceType: types.EKSNodeGroup},
+	{key: "aws:ec2spot:fleet-request-id", resourceType: types.SpotFleetRequest},
+	{key: "aws:ec2:fleet-id", resourceType: types.EC2Fleet},
+	{key: "aws:autoscaling:groupName", resourceType: types.AutoScalingGroup},
+}
+
+// instanceParent returns the resource that launched and manages the instance,
+// or nil for an instance launched on its own.
+func instanceParent(instance *ec2.Instance) *types.ResourceRef {
+	for _, tag := range parentTags {
+		value := awsString(findTag(instance.Tags, tag.key))
+		if value == "" {
+			continue
+		}
+		if tag.resourceType == types.EKSNodeGroup {
+			// Terraform identifies node groups as cluster:nodegroup
+			value = awsString(findTag(instance.Tags, "eks:cluster-name")) + ":" + value
+		}
+		return &types.ResourceRef{Type: tag.resourceType, Name: value}
+	}
+	if awsString(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot && instance.SpotInstanceRequestId != nil {
+		return &types.ResourceRef{Type: types.SpotInstanceRequest, Name: *instance.SpotInstanceRequestId}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package repository
+
+import "github.com/aws/aws-sdk-go/aws/session"
+
+// Scope is where instances are listed: the regions of the session's account,
+// or the given accounts through their assumed roles.
+type Scope struct {
+	// Regions are scanned instead of the session's region, and by every account that lists none of its own.
+	Regions  []string
+	Accounts []Account
+}
+
+// NewScopedEC2Repo creates the repository that lists instances across scope,
+// scanning accounts and regions concurrently.
+func NewScopedEC2Repo(session *session.Session, scope Scope) EC2Repository {
+	if len(scope.Accounts) > 0 {
+		return NewMultiRegionEC2Repo(NewAccountEC2Repos(session, scope.Accounts, scope.Regions))
+	}
+	if len(scope.Regions) > 0 {
+		return NewMultiRegionEC2Repo(NewRegionalEC2Repos(session, scope.Regions))
+	}
+	return NewEC2Repo(session)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// describeVolumesBatchSize bounds the volume IDs sent in one DescribeVolumes call.
+const describeVolumesBatchSize = 200
+
+// addBlockDevices adds the instance's EBS block device mappings: the root
+// device as root_block_device and the others as ebs_block_device, keyed by
+// device name. The mappings only name the volumes; describeVolumes adds their
+// configuration.
+func addBlockDevices(data map[string]interface{}, instance *ec2.Instance) {
+	if instance.BlockDeviceMappings == nil {
+		return
+	}
+
+	rootDevice := awsString(instance.RootDeviceName)
+	ebsDevices := make(map[string]map[string]interface{})
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping == nil || mapping.Ebs == nil {
+			continue
+		}
+		device := map[string]interface{}{
+			"device_name": awsString(mapping.DeviceName),
+			"volume_id":   awsString(mapping.Ebs.VolumeId),
+		}
+		setBool(device, "delete_on_termination", mapping.Ebs.DeleteOnTermination)
+
+		if awsString(mapping.DeviceName) == rootDevice {
+			data["root_block_device"] = device
+			continue
+		}
+		ebsDevices[awsString(mapping.DeviceName)] = device
+	}
+	data["ebs_block_device"] = ebsDevices
+}
+
+// describeVolumes adds the size, type, performance and encryption of every
+// volume attached to the instances.
+func (r *ec2Repo) describeVolumes(ctx context.Context, instances []types.Resource) error {
+	devices := make(map[string]map[string]interface{})
+	var volumeIDs []*string
+	addDevice := func(device map[string]interface{}) {
+		if volumeID, _ := device["volume_id"].(string); volumeID != "" {
+			devices[volumeID] = device
+			volumeIDs = append(volumeIDs, aws.String(volumeID))
+		}
+	}
+	for _, instance := range instances {
+		data, ok := instance.Data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if root, ok := data["root_block_device"].(map[string]interface{}); ok {
+			addDevice(root)
+		}
+		if ebs, ok := data["ebs_block_device"].(map[string]map[string]interface{}); ok {
+			for _, device := range ebs {
+				addDevice(device)
+			}
+		}
+	}
+
+	for start := 0; start < len(volumeIDs); start += describeVolumesBatchSize {
+		end := min(start+describeVolumesBatchSize, len(volumeIDs))
+		input := &ec2.DescribeVolumesInput{VolumeIds: volumeIDs[start:end]}
+		err := r.client.DescribeVolumesPagesWithContext(ctx, input, func(output *ec2.DescribeVolumesOutput, lastPage bool) bool {
+			for _, volume := range output.Volumes {
+				if device, ok := devices[awsString(volume.VolumeId)]; ok {
+					addVolume(device, volume)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe EBS volumes: %w", err)
+		}
+	}
+	return nil
+}
+
+// addVolume adds a volume's configuration to its block device.
+func addVolume(device map[string]interface{}, volume *ec2.Volume) {
+	setInt(device, "volume_size", volume.Size)
+	setString(device, "volume_type", volume.VolumeType)
+	setInt(device, "iops", volume.Iops)
+	setInt(device, "throughput", volume.Throughput)
+	setBool(device, "encrypted", volume.Encrypted)
+	// Unencrypted volumes have no key; Terraform records an empty one
+	device["kms_key_id"] = awsString(volume.KmsKeyId)
+}
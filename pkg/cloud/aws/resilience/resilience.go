@@ -0,0 +1,160 @@
+package resilience
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Defaults for the command-line flags.
+const (
+	DefaultMaxRetries  = 8
+	DefaultRateLimit   = 20
+	DefaultCallTimeout = 30 * time.Second
+)
+
+// Backoff bounds. Throttled calls start from a longer delay than other
+// transient errors, since retrying them quickly only prolongs the throttling.
+const (
+	minRetryDelay    = 100 * time.Millisecond
+	minThrottleDelay = 500 * time.Millisecond
+	maxRetryDelay    = 20 * time.Second
+)
+
+// Config controls how AWS API calls are retried and rate limited.
+type Config struct {
+	// MaxRetries is how often a throttled or transiently failing call is retried.
+	MaxRetries int
+	// RateLimit caps the requests per second sent to each service in each
+	// region, across all goroutines; 0 disables the limit.
+	RateLimit float64
+	// CallTimeout bounds each attempt of a call until the response headers
+	// arrive; reading the body is not bounded. 0 leaves attempts unbounded.
+	CallTimeout time.Duration
+	// Stats records retries when set.
+	Stats *Stats
+}
+
+// Apply makes every client created from sess retry with jittered exponential
+// backoff on throttling (e.g. RequestLimitExceeded) and transient errors,
+// wait for the rate limiter before each attempt, and time out attempts that
+// hang. Clients and sessions derived from sess afterwards inherit all of it.
+func Apply(sess *session.Session, config Config) {
+	retryer := &recordingRetryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    config.MaxRetries,
+			MinRetryDelay:    minRetryDelay,
+			MinThrottleDelay: minThrottleDelay,
+			MaxRetryDelay:    maxRetryDelay,
+			MaxThrottleDelay: maxRetryDelay,
+		},
+		stats: config.Stats,
+	}
+	request.WithRetryer(sess.Config, retryer)
+	sess.Config.MaxRetries = aws.Int(config.MaxRetries)
+
+	if config.CallTimeout > 0 {
+		sess.Config.HTTPClient = attemptClient(sess.Config.HTTPClient, config.CallTimeout)
+	}
+
+	if config.RateLimit > 0 {
+		limiters := newLimiters(config.RateLimit)
+		// Sign runs before every attempt, retries included
+		sess.Handlers.Sign.PushFrontNamed(request.NamedHandler{
+			Name: "drift-detector.RateLimit",
+			Fn: func(r *request.Request) {
+				if err := limiters.get(limiterKey(r)).wait(r.Context()); err != nil {
+					r.Error = awserr.New(request.CanceledErrorCode, "rate limiter wait canceled", err)
+				}
+			},
+		})
+	}
+
+	if config.Stats != nil {
+		sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+			Name: "drift-detector.RetryStats",
+			Fn: func(r *request.Request) {
+				if r.RetryCount > 0 && r.Error != nil {
+					config.Stats.recordFailure(serviceName(r), regionName(r))
+				}
+			},
+		})
+	}
+}
+
+// attemptClient bounds an attempt until the response headers arrive: the
+// connection, the TLS handshake and the wait for the service to answer. Timed
+// out attempts surface as network errors, which are retried. Reading the body
+// is left unbounded, since a large response such as a state file streamed
+// from S3 can take longer than any single call should.
+//
+// The timeouts are set on a copy of base's transport, so whatever the session
+// configured, such as the AWS_CA_BUNDLE certificates, is kept. A custom round
+// tripper cannot be given the timeouts and is left as it is.
+func attemptClient(base *http.Client, timeout time.Duration) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	httpClient := *base
+
+	var transport *http.Transport
+	switch t := base.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return &httpClient
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return dial(ctx, network, address)
+	}
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	httpClient.Transport = transport
+	return &httpClient
+}
+
+// recordingRetryer is the SDK's default retryer, which adds jitter to its
+// exponential backoff, reporting each retry to stats.
+type recordingRetryer struct {
+	client.DefaultRetryer
+	stats *Stats
+}
+
+func (r *recordingRetryer) RetryRules(req *request.Request) time.Duration {
+	if r.stats != nil {
+		code := "unknown"
+		if aerr, ok := req.Error.(awserr.Error); ok {
+			code = aerr.Code()
+		}
+		r.stats.recordRetry(serviceName(req), regionName(req), code)
+	}
+	return r.DefaultRetryer.RetryRules(req)
+}
+
+func limiterKey(r *request.Request) string {
+	return serviceName(r) + "/" + regionName(r)
+}
+
+func serviceName(r *request.Request) string {
+	return r.ClientInfo.ServiceName
+}
+
+func regionName(r *request.Request) string {
+	return aws.StringValue(r.Config.Region)
+}
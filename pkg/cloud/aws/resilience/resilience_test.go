@@ -0,0 +1,211 @@
+package resilience
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const emptyDescribeInstances = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet/></DescribeInstancesResponse>`
+
+func ec2Error(code string) string {
+	return `<Response><Errors><Error><Code>` + code + `</Code><Message>try again</Message></Error></Errors><RequestID>req-1</RequestID></Response>`
+}
+
+// newTestSession points every client at server with static credentials.
+func newTestSession(t *testing.T, server *httptest.Server) *session.Session {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})
+	assert.NoError(t, err)
+	return sess
+}
+
+func TestApplyRetriesThrottlingAndTransientErrors(t *testing.T) {
+	responses := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusServiceUnavailable, ec2Error("RequestLimitExceeded")},
+		{http.StatusInternalServerError, ec2Error("InternalError")},
+		{http.StatusOK, emptyDescribeInstances},
+	}
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[atomic.AddInt32(&calls, 1)-1]
+		w.WriteHeader(response.status)
+		_, _ = w.Write([]byte(response.body))
+	}))
+	defer server.Close()
+
+	stats := NewStats()
+	sess := newTestSession(t, server)
+	Apply(sess, Config{MaxRetries: 3, Stats: stats})
+
+	_, err := ec2.New(sess).DescribeInstancesWithContext(context.Background(), &ec2.DescribeInstancesInput{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, []types.RetryStat{{
+		Service: "ec2",
+		Region:  "eu-west-1",
+		Retries: 2,
+		Codes:   map[string]int{"RequestLimitExceeded": 1, "InternalError": 1},
+	}}, stats.Summary())
+}
+
+func TestApplyRecordsCallsThatFailAfterRetrying(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(ec2Error("InternalError")))
+	}))
+	defer server.Close()
+
+	stats := NewStats()
+	sess := newTestSession(t, server)
+	Apply(sess, Config{MaxRetries: 1, Stats: stats})
+
+	_, err := ec2.New(sess).DescribeInstancesWithContext(context.Background(), &ec2.DescribeInstancesInput{})
+
+	assert.ErrorContains(t, err, "InternalError")
+	assert.Equal(t, []types.RetryStat{{
+		Service: "ec2",
+		Region:  "eu-west-1",
+		Retries: 1,
+		Codes:   map[string]int{"InternalError": 1},
+		Failed:  1,
+	}}, stats.Summary())
+}
+
+func TestApplyCallTimeoutRetriesHungAttempts(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte(emptyDescribeInstances))
+	}))
+	defer server.Close()
+
+	sess := newTestSession(t, server)
+	Apply(sess, Config{MaxRetries: 2, CallTimeout: 50 * time.Millisecond})
+
+	_, err := ec2.New(sess).DescribeInstancesWithContext(context.Background(), &ec2.DescribeInstancesInput{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "the attempt waiting for headers is timed out and retried")
+}
+
+func TestApplyCallTimeoutKeepsTheSessionTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(emptyDescribeInstances))
+	}))
+	defer server.Close()
+
+	sess := newTestSession(t, server)
+	// The test server's client trusts its certificate, as a CA bundle would
+	sess.Config.HTTPClient = server.Client()
+	Apply(sess, Config{MaxRetries: 0, CallTimeout: 50 * time.Millisecond})
+
+	_, err := ec2.New(sess).DescribeInstancesWithContext(context.Background(), &ec2.DescribeInstancesInput{})
+
+	assert.NoError(t, err, "the trusted certificates survive Apply")
+	transport := sess.Config.HTTPClient.Transport.(*http.Transport)
+	assert.Equal(t, 50*time.Millisecond, transport.ResponseHeaderTimeout)
+	assert.NotSame(t, server.Client().Transport, transport, "the session's transport is copied, not changed")
+}
+
+func TestApplyCallTimeoutLeavesSlowBodiesAlone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first half,"))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(" second half"))
+	}))
+	defer server.Close()
+
+	sess := newTestSession(t, server)
+	Apply(sess, Config{MaxRetries: 0, CallTimeout: 50 * time.Millisecond})
+
+	output, err := s3.New(sess, &aws.Config{S3ForcePathStyle: aws.Bool(true)}).GetObjectWithContext(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("states"),
+		Key:    aws.String("terraform.tfstate"),
+	})
+	assert.NoError(t, err)
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	assert.NoError(t, err, "reading a body slower than the call timeout does not fail")
+	assert.Equal(t, "first half, second half", string(body))
+}
+
+func TestApplyRateLimitHonoursCancellation(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(emptyDescribeInstances))
+	}))
+	defer server.Close()
+
+	sess := newTestSession(t, server)
+	Apply(sess, Config{RateLimit: 0.001})
+	client := ec2.New(sess)
+
+	_, err := client.DescribeInstancesWithContext(context.Background(), &ec2.DescribeInstancesInput{})
+	assert.NoError(t, err, "the first call uses the burst")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+	assert.ErrorContains(t, err, "RequestCanceled")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "the limited call never reaches the service")
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept []time.Duration
+	bucket := newTokenBucket(2)
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+	bucket.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, bucket.wait(context.Background()))
+	}
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, slept, "the burst is free, then callers queue up")
+
+	now = now.Add(3 * time.Second)
+	slept = nil
+	assert.NoError(t, bucket.wait(context.Background()))
+	assert.Empty(t, slept, "the bucket refills over time")
+
+	t.Run("cancelled wait returns its token", func(t *testing.T) {
+		bucket.sleep = func(ctx context.Context, _ time.Duration) error { return context.Canceled }
+		bucket.tokens = 0
+		assert.ErrorIs(t, bucket.wait(context.Background()), context.Canceled)
+		assert.Equal(t, float64(0), bucket.tokens)
+	})
+}
+
+func TestLimitersArePerKey(t *testing.T) {
+	l := newLimiters(5)
+	assert.Same(t, l.get("ec2/us-east-1"), l.get("ec2/us-east-1"))
+	assert.NotSame(t, l.get("ec2/us-east-1"), l.get("ec2/eu-west-1"))
+}
@@ -0,0 +1,67 @@
+package resilience
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// Stats counts retried and failed calls per service and region. It is safe for concurrent use.
+type Stats struct {
+	mu    sync.Mutex
+	stats map[string]*types.RetryStat
+}
+
+// NewStats creates empty Stats.
+func NewStats() *Stats {
+	return &Stats{stats: make(map[string]*types.RetryStat)}
+}
+
+func (s *Stats) recordRetry(service, region, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stat := s.stat(service, region)
+	stat.Retries++
+	stat.Codes[code]++
+}
+
+func (s *Stats) recordFailure(service, region string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stat(service, region).Failed++
+}
+
+func (s *Stats) stat(service, region string) *types.RetryStat {
+	key := service + "/" + region
+	stat, ok := s.stats[key]
+	if !ok {
+		stat = &types.RetryStat{Service: service, Region: region, Codes: make(map[string]int)}
+		s.stats[key] = stat
+	}
+	return stat
+}
+
+// Summary returns the recorded retries sorted by service and region.
+func (s *Stats) Summary() []types.RetryStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := make([]types.RetryStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		codes := make(map[string]int, len(stat.Codes))
+		for code, n := range stat.Codes {
+			codes[code] = n
+		}
+		copied := *stat
+		copied.Codes = codes
+		summary = append(summary, copied)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Service != summary[j].Service {
+			return summary[i].Service < summary[j].Service
+		}
+		return summary[i].Region < summary[j].Region
+	})
+	return summary
+}
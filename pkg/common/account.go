@@ -0,0 +1,20 @@
+package common
+
+import "strings"
+
+// AccountFromARN returns the account ID field of an ARN, or "" for malformed ARNs.
+func AccountFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
+
+// AccountLabel formats an account for display as alias/ID, or just the ID when it has no alias.
+func AccountLabel(id, alias string) string {
+	if alias == "" {
+		return id
+	}
+	return alias + "/" + id
+}
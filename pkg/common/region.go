@@ -0,0 +1,24 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// regionPattern matches the region prefix of an availability zone, local zone
+// or wavelength zone name, e.g. us-west-2 in us-west-2a or us-west-2-lax-1a.
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-\d+`)
+
+// RegionFromAvailabilityZone returns the region an availability zone belongs to, or "".
+func RegionFromAvailabilityZone(zone string) string {
+	return regionPattern.FindString(zone)
+}
+
+// RegionFromARN returns the region field of an ARN, or "" for global or malformed ARNs.
+func RegionFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 5)
+	if len(parts) < 5 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
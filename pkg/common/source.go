@@ -0,0 +1,10 @@
+package common
+
+// SourceType names the IaC tool whose state describes the desired configuration.
+type SourceType string
+
+const (
+	SourceTerraform      SourceType = "terraform"
+	SourcePulumi         SourceType = "pulumi"
+	SourceCloudFormation SourceType = "cloudformation"
+)
@@ -9,6 +9,7 @@ import (
 // FileReader is an interface for reading files
 type FileReader interface {
 	ReadFile(path string) ([]byte, error)
+	Open(path string) (io.ReadCloser, error)
 }
 
 // OSFileReader is the default file reader implementation
@@ -26,3 +27,12 @@ func (r *OSFileReader) ReadFile(path string) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// Open opens the file for streaming reads. The caller must close it.
+func (r *OSFileReader) Open(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
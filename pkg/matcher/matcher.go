@@ -0,0 +1,101 @@
+package matcher
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// Matcher recognizes the cloud resource a state resource describes when their
+// IDs differ, e.g. because the instance was terminated and relaunched by hand.
+type Matcher interface {
+	// Name identifies the strategy in findings, e.g. "tag:Name".
+	Name() string
+	// Score rates how likely actual is the resource desired describes, from 0
+	// (no match) to 1 (certain).
+	Score(desired, actual types.Resource) float64
+}
+
+// Pair links the index of a desired resource to the index of the actual
+// resource a matcher paired it with.
+type Pair struct {
+	Desired    int
+	Actual     int
+	Matcher    string
+	Confidence float64
+}
+
+// Match pairs desired resources with actual resources of the same type and
+// location. Matchers are tried in order, each on the resources still
+// unpaired, so stronger strategies should come first. A desired resource is
+// only paired when a single actual resource scores best; ties are ambiguous
+// and left unpaired. Pairs are returned in desired order.
+func Match(matchers []Matcher, desired, actual []types.Resource) []Pair {
+	pairedActual := make(map[int]bool)
+	pairedDesired := make(map[int]bool)
+	var pairs []Pair
+	for _, m := range matchers {
+		for i, d := range desired {
+			if pairedDesired[i] {
+				continue
+			}
+			best, bestScore, tied := -1, 0.0, false
+			for j, a := range actual {
+				if pairedActual[j] || a.Type != d.Type || !sameLocation(d, a) {
+					continue
+				}
+				score := m.Score(d, a)
+				switch {
+				case score <= 0:
+				case score > bestScore:
+					best, bestScore, tied = j, score, false
+				case score == bestScore:
+					tied = true
+				}
+			}
+			if best < 0 || tied {
+				continue
+			}
+			pairedDesired[i], pairedActual[best] = true, true
+			pairs = append(pairs, Pair{Desired: i, Actual: best, Matcher: m.Name(), Confidence: bestScore})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Desired < pairs[j].Desired })
+	return pairs
+}
+
+// sameLocation reports whether two resources may live in the same account
+// and region. An unknown account or region matches any.
+func sameLocation(a, b types.Resource) bool {
+	return (a.Region == "" || b.Region == "" || a.Region == b.Region) &&
+		(a.Account == "" || b.Account == "" || a.Account == b.Account)
+}
+
+// Parse builds matchers from specs: "arn", "tag:<key>", "fuzzy" or
+// "fuzzy:<threshold>" with a threshold between 0 and 1.
+func Parse(specs []string) ([]Matcher, error) {
+	matchers := make([]Matcher, 0, len(specs))
+	for _, spec := range specs {
+		kind, arg, hasArg := strings.Cut(spec, ":")
+		switch {
+		case kind == "arn" && !hasArg:
+			matchers = append(matchers, NewARNMatcher())
+		case kind == "tag" && arg != "":
+			matchers = append(matchers, NewTagMatcher(arg))
+		case kind == "fuzzy" && !hasArg:
+			matchers = append(matchers, NewFuzzyMatcher(DefaultFuzzyThreshold))
+		case kind == "fuzzy":
+			threshold, err := strconv.ParseFloat(arg, 64)
+			if err != nil || threshold <= 0 || threshold > 1 {
+				return nil, fmt.Errorf("invalid fuzzy match threshold %q: must be a number in (0, 1]", arg)
+			}
+			matchers = append(matchers, NewFuzzyMatcher(threshold))
+		default:
+			return nil, fmt.Errorf("unknown matcher %q: expected arn, tag:<key> or fuzzy[:<threshold>]", spec)
+		}
+	}
+	return matchers, nil
+}
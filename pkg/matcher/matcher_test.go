@@ -0,0 +1,173 @@
+package matcher
+
+import (
+	"testing"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func instance(id string, data map[string]interface{}) types.Resource {
+	return types.NewResource(id, types.EC2Instance, data)
+}
+
+func TestARNMatcher(t *testing.T) {
+	desired := instance("web", map[string]interface{}{})
+	desired.ARN = "arn:aws:ec2:us-east-1:123456789012:instance/i-123"
+
+	m := NewARNMatcher()
+	assert.Equal(t, 1.0, m.Score(desired, instance("i-123", nil)))
+	assert.Equal(t, 0.0, m.Score(desired, instance("i-456", nil)))
+	assert.Equal(t, 0.0, m.Score(instance("web", nil), instance("i-123", nil)), "no ARN recorded")
+
+	arn := func(id, region, account string) types.Resource {
+		r := instance(id, nil)
+		r.ARN = "arn:aws:ec2:" + region + ":" + account + ":instance/" + id
+		return r
+	}
+	assert.Equal(t, 1.0, m.Score(desired, arn("i-123", "us-east-1", "123456789012")))
+	assert.Equal(t, 0.5, m.Score(desired, arn("i-456", "us-east-1", "123456789012")), "a replacement keeps the region and account")
+	assert.Equal(t, 0.0, m.Score(desired, arn("i-456", "us-east-1", "210987654321")))
+	assert.Equal(t, 0.0, m.Score(desired, arn("i-123", "eu-west-1", "123456789012")))
+}
+
+func TestTagMatcher(t *testing.T) {
+	desired := instance("i-123", map[string]interface{}{
+		"tags":     map[string]string{"Name": "web"},
+		"tags_all": map[string]string{"Name": "web", "terraform-address": "aws_instance.web"},
+	})
+
+	assert.Equal(t, 0.9, NewTagMatcher("Name").Score(desired, instance("i-456", map[string]interface{}{
+		"tags": map[string]string{"Name": "web"},
+	})))
+	assert.Equal(t, 0.9, NewTagMatcher("terraform-address").Score(desired, instance("i-456", map[string]interface{}{
+		"tags": map[string]string{"terraform-address": "aws_instance.web"},
+	})), "default tags count")
+	assert.Equal(t, 0.0, NewTagMatcher("Name").Score(desired, instance("i-456", map[string]interface{}{
+		"tags": map[string]string{"Name": "api"},
+	})))
+	assert.Equal(t, 0.0, NewTagMatcher("Owner").Score(desired, instance("i-456", map[string]interface{}{
+		"tags": map[string]string{},
+	})), "an absent tag does not match")
+}
+
+func TestFuzzyMatcher(t *testing.T) {
+	desired := instance("i-123", map[string]interface{}{
+		"ami":               "ami-1",
+		"instance_type":     "t3.micro",
+		"subnet_id":         "subnet-1",
+		"availability_zone": "us-east-1a",
+		"security_groups":   []string{"sg-2", "sg-1"},
+		"private_ip":        "10.0.0.1",
+	})
+
+	tests := []struct {
+		name     string
+		actual   map[string]interface{}
+		expected float64
+	}{
+		{
+			name: "all equal",
+			actual: map[string]interface{}{
+				"ami":               "ami-1",
+				"instance_type":     "t3.micro",
+				"subnet_id":         "subnet-1",
+				"availability_zone": "us-east-1a",
+				"security_groups":   []string{"sg-1", "sg-2"},
+				"private_ip":        "10.0.0.9",
+			},
+			expected: 1,
+		},
+		{
+			name: "one of five differs",
+			actual: map[string]interface{}{
+				"ami":               "ami-2",
+				"instance_type":     "t3.micro",
+				"subnet_id":         "subnet-1",
+				"availability_zone": "us-east-1a",
+				"security_groups":   []string{"sg-1", "sg-2"},
+			},
+			expected: 0.8,
+		},
+		{
+			name: "below threshold",
+			actual: map[string]interface{}{
+				"ami":               "ami-2",
+				"instance_type":     "t3.large",
+				"subnet_id":         "subnet-1",
+				"availability_zone": "us-east-1a",
+				"security_groups":   []string{"sg-1", "sg-2"},
+			},
+		},
+		{
+			name:   "too few attributes",
+			actual: map[string]interface{}{"ami": "ami-1", "instance_type": "t3.micro"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, NewFuzzyMatcher(DefaultFuzzyThreshold).Score(desired, instance("i-456", tt.actual)), 1e-9)
+		})
+	}
+}
+
+func TestFuzzyMatcherDefaultTags(t *testing.T) {
+	desired := instance("i-123", map[string]interface{}{
+		"ami":           "ami-1",
+		"instance_type": "t3.micro",
+		"tags":          map[string]string{"Name": "web"},
+		"tags_all":      map[string]string{"Name": "web", "Team": "platform"},
+	})
+	actual := instance("i-456", map[string]interface{}{
+		"ami":           "ami-1",
+		"instance_type": "t3.micro",
+		"tags":          map[string]string{"Name": "web", "Team": "platform"},
+	})
+
+	assert.Equal(t, 1.0, NewFuzzyMatcher(DefaultFuzzyThreshold).Score(desired, actual), "the default tags the instance carries are expected")
+}
+
+func TestMatch(t *testing.T) {
+	tagged := func(id, name, region string) types.Resource {
+		r := instance(id, map[string]interface{}{"tags": map[string]string{"Name": name}})
+		r.Region = region
+		return r
+	}
+	desired := []types.Resource{
+		tagged("i-1", "web", "us-east-1"),
+		tagged("i-2", "worker", ""),
+		tagged("i-3", "api", "us-east-1"),
+		tagged("i-4", "db", ""),
+	}
+	desired[3].ARN = "arn:aws:ec2:us-east-1:123456789012:instance/i-40"
+	actual := []types.Resource{
+		tagged("i-10", "web", "us-east-1"),
+		tagged("i-20", "worker", ""),
+		tagged("i-21", "worker", ""),
+		tagged("i-30", "api", "eu-west-1"),
+		tagged("i-40", "", ""),
+	}
+
+	pairs := Match([]Matcher{NewARNMatcher(), NewTagMatcher("Name")}, desired, actual)
+	assert.Equal(t, []Pair{
+		{Desired: 0, Actual: 0, Matcher: "tag:Name", Confidence: 0.9},
+		{Desired: 3, Actual: 4, Matcher: "arn", Confidence: 1},
+	}, pairs, "ambiguous tags and other regions are not paired")
+}
+
+func TestParse(t *testing.T) {
+	matchers, err := Parse([]string{"arn", "tag:Name", "fuzzy", "fuzzy:0.6"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Matcher{
+		arnMatcher{},
+		tagMatcher{key: "Name"},
+		fuzzyMatcher{threshold: DefaultFuzzyThreshold},
+		fuzzyMatcher{threshold: 0.6},
+	}, matchers)
+
+	for _, spec := range []string{"id", "tag", "tag:", "fuzzy:2", "fuzzy:x", "arn:x"} {
+		_, err := Parse([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,198 @@
+package matcher
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// DefaultFuzzyThreshold is the share of compared attributes that must agree
+// for the fuzzy matcher to pair two resources.
+const DefaultFuzzyThreshold = 0.8
+
+// minFuzzyAttributes is how many attributes the fuzzy matcher needs to
+// compare before it trusts a score.
+const minFuzzyAttributes = 3
+
+// tagConfidence is the confidence of a tag match; tags identify a resource
+// well but can be copied to another one.
+const tagConfidence = 0.9
+
+// arnScopeConfidence is the confidence of an ARN match on everything but the
+// resource ID, which is all of the ARN a replacement keeps.
+const arnScopeConfidence = 0.5
+
+type arnMatcher struct{}
+
+// NewARNMatcher matches a state resource with the instance its recorded ARN
+// names, for states whose resource ID is not the instance ID. A replaced
+// instance has a new ID, so an instance in the same partition, region and
+// account matches with less confidence, which Match only pairs when no other
+// candidate is left.
+func NewARNMatcher() Matcher {
+	return arnMatcher{}
+}
+
+func (arnMatcher) Name() string {
+	return "arn"
+}
+
+func (arnMatcher) Score(desired, actual types.Resource) float64 {
+	scope, id, ok := splitARN(desired.ARN)
+	if !ok {
+		return 0
+	}
+	if actual.ARN == "" {
+		// Without a cloud-side ARN, only the ID can be compared
+		if id == actual.Name {
+			return 1
+		}
+		return 0
+	}
+	if actual.ARN == desired.ARN {
+		return 1
+	}
+	if actualScope, _, ok := splitARN(actual.ARN); ok && actualScope == scope {
+		return arnScopeConfidence
+	}
+	return 0
+}
+
+// splitARN splits arn:partition:ec2:region:account:instance/i-... into the
+// part before the resource ID and the ID.
+func splitARN(arn string) (scope, id string, ok bool) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return "", "", false
+	}
+	resourceType, id, ok := strings.Cut(parts[5], "/")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Join(parts[:5], ":") + ":" + resourceType, id, true
+}
+
+type tagMatcher struct {
+	key string
+}
+
+// NewTagMatcher matches resources carrying the same non-empty value of a tag,
+// such as Name or terraform-address.
+func NewTagMatcher(key string) Matcher {
+	return tagMatcher{key: key}
+}
+
+func (m tagMatcher) Name() string {
+	return "tag:" + m.key
+}
+
+func (m tagMatcher) Score(desired, actual types.Resource) float64 {
+	value := tagValue(desired, "tags_all", m.key)
+	if value == "" {
+		value = tagValue(desired, "tags", m.key)
+	}
+	if value != "" && value == tagValue(actual, "tags", m.key) {
+		return tagConfidence
+	}
+	return 0
+}
+
+// tagValue returns the value of a tag in a resource's tag attribute, or "".
+func tagValue(r types.Resource, attribute, key string) string {
+	data, _ := r.Data.(map[string]interface{})
+	tags, _ := data[attribute].(map[string]string)
+	return tags[key]
+}
+
+// fuzzyAttributes are the attributes the fuzzy matcher compares: those that
+// describe what an instance is, rather than which one it is.
+var fuzzyAttributes = []string{
+	"ami",
+	"instance_type",
+	"key_name",
+	"subnet_id",
+	"availability_zone",
+	"iam_instance_profile",
+	"security_groups",
+	"tags",
+}
+
+type fuzzyMatcher struct {
+	threshold float64
+}
+
+// NewFuzzyMatcher matches resources whose attributes mostly agree. The score
+// is the share of compared attributes that are equal, and scores below
+// threshold are no match.
+func NewFuzzyMatcher(threshold float64) Matcher {
+	return fuzzyMatcher{threshold: threshold}
+}
+
+func (fuzzyMatcher) Name() string {
+	return "fuzzy"
+}
+
+func (m fuzzyMatcher) Score(desired, actual types.Resource) float64 {
+	desiredData, okDesired := desired.Data.(map[string]interface{})
+	actualData, okActual := actual.Data.(map[string]interface{})
+	if !okDesired || !okActual {
+		return 0
+	}
+
+	compared, equal := 0, 0
+	for _, attribute := range fuzzyAttributes {
+		desiredValue := fuzzyValue(desiredData[attribute])
+		// Terraform records default tags only in tags_all, which is what the cloud reports as tags
+		if all := fuzzyValue(desiredData["tags_all"]); attribute == "tags" && all != nil {
+			desiredValue = all
+		}
+		actualValue := fuzzyValue(actualData[attribute])
+		if desiredValue == nil || actualValue == nil {
+			continue
+		}
+		compared++
+		if reflect.DeepEqual(desiredValue, actualValue) {
+			equal++
+		}
+	}
+	if compared < minFuzzyAttributes {
+		return 0
+	}
+	score := float64(equal) / float64(compared)
+	if score < m.threshold {
+		return 0
+	}
+	return score
+}
+
+// fuzzyValue normalizes an attribute value for the fuzzy matcher: lists are
+// sorted, AWS reserved tags dropped, and empty values become nil.
+func fuzzyValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		if value == "" {
+			return nil
+		}
+	case []string:
+		if len(value) == 0 {
+			return nil
+		}
+		sorted := append([]string{}, value...)
+		sort.Strings(sorted)
+		return sorted
+	case map[string]string:
+		tags := make(map[string]string, len(value))
+		for k, s := range value {
+			if !strings.HasPrefix(k, "aws:") {
+				tags[k] = s
+			}
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return tags
+	}
+	return v
+}
@@ -0,0 +1,260 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// cloudFormationResourceTypes maps CloudFormation resource types onto the
+// Terraform resource types the comparators are registered for.
+var cloudFormationResourceTypes = map[string]types.ResourceType{
+	"AWS::EC2::Instance": types.EC2Instance,
+}
+
+// cloudFormationInstanceProperties maps AWS::EC2::Instance properties onto normalized attribute names.
+var cloudFormationInstanceProperties = map[string]string{
+	"InstanceType":     "instance_type",
+	"ImageId":          "ami",
+	"KeyName":          "key_name",
+	"SubnetId":         "subnet_id",
+	"AvailabilityZone": "availability_zone",
+	"PrivateIpAddress": "private_ip",
+	"SecurityGroupIds": "security_groups",
+}
+
+// stackResource is one entry of `aws cloudformation describe-stack-resources`
+// (StackResources) or `list-stack-resources` (StackResourceSummaries) output.
+type stackResource struct {
+	LogicalResourceID  string `json:"LogicalResourceId"`
+	PhysicalResourceID string `json:"PhysicalResourceId"`
+	ResourceStatus     string `json:"ResourceStatus"`
+}
+
+// ParseStackResources reads a stack resource listing and returns the physical
+// ID of each logical resource. Deleted resources are left out.
+func ParseStackResources(data []byte) (map[string]string, error) {
+	var listing struct {
+		StackResources         []stackResource `json:"StackResources"`
+		StackResourceSummaries []stackResource `json:"StackResourceSummaries"`
+	}
+	if err := json.Unmarshal(data, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse stack resources: %w", err)
+	}
+
+	physicalIDs := make(map[string]string)
+	for _, res := range append(listing.StackResources, listing.StackResourceSummaries...) {
+		if res.PhysicalResourceID == "" || res.ResourceStatus == "DELETE_COMPLETE" {
+			continue
+		}
+		physicalIDs[res.LogicalResourceID] = res.PhysicalResourceID
+	}
+	if len(physicalIDs) == 0 {
+		return nil, fmt.Errorf("no stack resources found")
+	}
+	return physicalIDs, nil
+}
+
+// ParseCloudFormationTemplate parses a JSON or YAML template and returns the
+// resources that have a physical ID in the stack. Only properties the template
+// sets, and whose value can be resolved, are included, so attributes the
+// template leaves to AWS are not reported as drift. A Ref to another resource
+// of the stack resolves to its physical ID; parameters and other intrinsic
+// functions cannot be resolved from the template alone and are left out.
+func ParseCloudFormationTemplate(data []byte, physicalIDs map[string]string, supported map[types.ResourceType]struct{}) ([]types.Resource, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	template, _ := cfnValue(&doc).(map[string]interface{})
+	resources, ok := template["Resources"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no resources found in template")
+	}
+
+	results := make([]types.Resource, 0)
+	for logicalID, raw := range resources {
+		definition, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		physicalID, ok := physicalIDs[logicalID]
+		if !ok {
+			continue
+		}
+
+		cfnType, _ := definition["Type"].(string)
+		resourceType, ok := cloudFormationResourceTypes[cfnType]
+		if !ok || !isSupported(supported, string(resourceType)) {
+			continue
+		}
+
+		properties, _ := definition["Properties"].(map[string]interface{})
+		resource := types.NewResource(physicalID, resourceType, cloudFormationInstanceAttributes(physicalID, properties, physicalIDs))
+		resource.Address = logicalID
+		resource.Region = regionOf(resource.Data.(map[string]interface{}))
+		results = append(results, resource)
+	}
+
+	// Map iteration order is random; keep the template's resources in a stable order
+	sort.Slice(results, func(i, j int) bool { return results[i].Address < results[j].Address })
+	return results, nil
+}
+
+// cloudFormationInstanceAttributes converts AWS::EC2::Instance properties into normalized attributes.
+func cloudFormationInstanceAttributes(physicalID string, properties map[string]interface{}, physicalIDs map[string]string) map[string]interface{} {
+	attributes := map[string]interface{}{"instance_id": physicalID}
+
+	for property, name := range cloudFormationInstanceProperties {
+		raw, ok := properties[property]
+		if !ok {
+			continue
+		}
+		value, ok := resolveCFNValue(raw, physicalIDs)
+		if !ok {
+			continue
+		}
+		if list, isList := value.([]interface{}); isList {
+			strs := make([]string, 0, len(list))
+			for _, v := range list {
+				strs = append(strs, fmt.Sprintf("%v", v))
+			}
+			attributes[name] = strs
+			continue
+		}
+		attributes[name] = fmt.Sprintf("%v", value)
+	}
+
+	if rawTags, ok := properties["Tags"].([]interface{}); ok {
+		tags := make(map[string]string)
+		for _, rawTag := range rawTags {
+			tag, _ := rawTag.(map[string]interface{})
+			key, keyOk := resolveCFNValue(tag["Key"], physicalIDs)
+			value, valueOk := resolveCFNValue(tag["Value"], physicalIDs)
+			if !keyOk || !valueOk {
+				// One unknown tag makes the whole set incomparable
+				tags = nil
+				break
+			}
+			tags[fmt.Sprintf("%v", key)] = fmt.Sprintf("%v", value)
+		}
+		if tags != nil {
+			attributes["tags"] = tags
+		}
+	}
+
+	return attributes
+}
+
+// resolveCFNValue resolves a literal or a Ref to a stack resource, reporting
+// false for anything that needs deployment-time information.
+func resolveCFNValue(v interface{}, physicalIDs map[string]string) (interface{}, bool) {
+	switch value := v.(type) {
+	case nil:
+		return nil, false
+	case map[string]interface{}:
+		ref, ok := value["Ref"].(string)
+		if !ok || len(value) != 1 {
+			return nil, false
+		}
+		physicalID, ok := physicalIDs[ref]
+		return physicalID, ok
+	case []interface{}:
+		resolved := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			r, ok := resolveCFNValue(item, physicalIDs)
+			if !ok {
+				return nil, false
+			}
+			resolved = append(resolved, r)
+		}
+		return resolved, true
+	default:
+		return value, true
+	}
+}
+
+// cfnValue converts a YAML node into plain Go values, expanding the short form
+// of intrinsic functions (!Ref X, !GetAtt A.B, !Sub ...) into the long form
+// used by JSON templates.
+func cfnValue(node *yaml.Node) interface{} {
+	var value interface{}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return cfnValue(node.Content[0])
+	case yaml.AliasNode:
+		return cfnValue(node.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			m[node.Content[i].Value] = cfnValue(node.Content[i+1])
+		}
+		value = m
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			list = append(list, cfnValue(item))
+		}
+		value = list
+	case yaml.ScalarNode:
+		if isIntrinsicTag(node.Tag) {
+			value = node.Value
+		} else if err := node.Decode(&value); err != nil {
+			value = node.Value
+		}
+	}
+
+	if !isIntrinsicTag(node.Tag) {
+		return value
+	}
+
+	function := strings.TrimPrefix(node.Tag, "!")
+	if function != "Ref" && function != "Condition" {
+		function = "Fn::" + function
+	}
+	if s, ok := value.(string); ok && function == "Fn::GetAtt" {
+		resource, attribute, _ := strings.Cut(s, ".")
+		value = []interface{}{resource, attribute}
+	}
+	return map[string]interface{}{function: value}
+}
+
+// isIntrinsicTag reports whether a YAML tag is a short-form intrinsic function
+// rather than one of the standard !!str, !!int, ... tags.
+func isIntrinsicTag(tag string) bool {
+	return strings.HasPrefix(tag, "!") && !strings.HasPrefix(tag, "!!")
+}
+
+// CloudFormationParser implements Parser for CloudFormation templates, naming
+// resources by the physical IDs of the deployed stack.
+type CloudFormationParser struct {
+	physicalIDs map[string]string
+	supported   map[types.ResourceType]struct{}
+}
+
+func (p *CloudFormationParser) ParseTerraformStateFile(data []byte) ([]types.Resource, error) {
+	return ParseCloudFormationTemplate(data, p.physicalIDs, p.supported)
+}
+
+func (p *CloudFormationParser) ParseTerraformStateStream(r io.Reader) ([]types.Resource, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	return ParseCloudFormationTemplate(data, p.physicalIDs, p.supported)
+}
+
+// NewCloudFormationParser creates a template parser for the stack whose
+// resources are described by physicalIDs, as returned by ParseStackResources.
+func NewCloudFormationParser(physicalIDs map[string]string, supported ...types.ResourceType) *CloudFormationParser {
+	return &CloudFormationParser{physicalIDs: physicalIDs, supported: NewParser(supported...).supported}
+}
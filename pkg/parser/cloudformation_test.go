@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const stackResourcesExport = `{
+	"StackResources": [
+		{"StackName": "web", "LogicalResourceId": "WebServer", "PhysicalResourceId": "i-1234567890abcdef0", "ResourceType": "AWS::EC2::Instance", "ResourceStatus": "CREATE_COMPLETE"},
+		{"StackName": "web", "LogicalResourceId": "WebSG", "PhysicalResourceId": "sg-12345678", "ResourceType": "AWS::EC2::SecurityGroup", "ResourceStatus": "CREATE_COMPLETE"},
+		{"StackName": "web", "LogicalResourceId": "Old", "PhysicalResourceId": "i-0000000000000000", "ResourceType": "AWS::EC2::Instance", "ResourceStatus": "DELETE_COMPLETE"}
+	]
+}`
+
+const yamlTemplate = `
+AWSTemplateFormatVersion: "2010-09-09"
+Parameters:
+  KeyName:
+    Type: AWS::EC2::KeyPair::KeyName
+Resources:
+  WebSG:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupDescription: web
+  WebServer:
+    Type: AWS::EC2::Instance
+    Properties:
+      InstanceType: t2.micro
+      ImageId: ami-12345678
+      KeyName: !Ref KeyName
+      SubnetId: subnet-12345678
+      SecurityGroupIds:
+        - !Ref WebSG
+      Tags:
+        - Key: Name
+          Value: test-instance
+  Old:
+    Type: AWS::EC2::Instance
+    Properties:
+      InstanceType: t2.micro
+  NotDeployed:
+    Type: AWS::EC2::Instance
+    Properties:
+      InstanceType: t2.micro
+`
+
+const jsonTemplate = `{
+	"Resources": {
+		"WebServer": {
+			"Type": "AWS::EC2::Instance",
+			"Properties": {
+				"InstanceType": "t2.micro",
+				"ImageId": "ami-12345678",
+				"KeyName": {"Ref": "KeyName"},
+				"SubnetId": "subnet-12345678",
+				"SecurityGroupIds": [{"Ref": "WebSG"}],
+				"Tags": [{"Key": "Name", "Value": "test-instance"}]
+			}
+		}
+	}
+}`
+
+func TestParseStackResources(t *testing.T) {
+	physicalIDs, err := ParseStackResources([]byte(stackResourcesExport))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"WebServer": "i-1234567890abcdef0", "WebSG": "sg-12345678"}, physicalIDs)
+
+	physicalIDs, err = ParseStackResources([]byte(`{"StackResourceSummaries": [{"LogicalResourceId": "A", "PhysicalResourceId": "i-1"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "i-1"}, physicalIDs)
+
+	_, err = ParseStackResources([]byte(`{}`))
+	assert.EqualError(t, err, "no stack resources found")
+}
+
+func TestParseCloudFormationTemplate(t *testing.T) {
+	physicalIDs, err := ParseStackResources([]byte(stackResourcesExport))
+	assert.NoError(t, err)
+
+	// KeyName refers to a parameter, which the template alone cannot resolve
+	expected := []types.Resource{{
+		Name: "i-1234567890abcdef0",
+		Type: types.EC2Instance,
+		Data: map[string]interface{}{
+			"instance_id":     "i-1234567890abcdef0",
+			"instance_type":   "t2.micro",
+			"ami":             "ami-12345678",
+			"subnet_id":       "subnet-12345678",
+			"security_groups": []string{"sg-12345678"},
+			"tags":            map[string]string{"Name": "test-instance"},
+		},
+		Address: "WebServer",
+	}}
+	supported := map[types.ResourceType]struct{}{types.EC2Instance: {}}
+
+	tests := []struct {
+		name           string
+		input          string
+		expected       []types.Resource
+		expectedErrMsg string
+	}{
+		{name: "yaml with short form intrinsics", input: yamlTemplate, expected: expected},
+		{name: "json", input: jsonTemplate, expected: expected},
+		{name: "no resources", input: `{"Parameters": {}}`, expectedErrMsg: "no resources found in template"},
+		{name: "invalid", input: "Resources: [", expectedErrMsg: "failed to parse template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseCloudFormationTemplate([]byte(tt.input), physicalIDs, supported)
+
+			if tt.expectedErrMsg != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMsg)
+				assert.Nil(t, result)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+// Environment variables holding the secret for OpenTofu encrypted state. The
+// passphrase feeds the pbkdf2 key provider; the key file holds a raw AES key,
+// hex encoded, for states written with a static key.
+const (
+	envStatePassphrase = "DRIFT_DETECTOR_STATE_PASSPHRASE"
+	envStateKeyFile    = "DRIFT_DETECTOR_STATE_KEY_FILE"
+)
+
+// pbkdf2MetaPrefix prefixes the meta entries written by OpenTofu's pbkdf2 key provider.
+const pbkdf2MetaPrefix = "key_provider.pbkdf2."
+
+// encryptedState is the envelope OpenTofu writes in place of the state when
+// state encryption is configured. []byte fields are base64 in JSON.
+type encryptedState struct {
+	Meta    map[string][]byte `json:"meta"`
+	Data    []byte            `json:"encrypted_data"`
+	Version string            `json:"encryption_version"`
+}
+
+// decodeField decodes key into the envelope if it is one of its fields,
+// reporting whether it was.
+func (s *encryptedState) decodeField(dec *json.Decoder, key string) (bool, error) {
+	switch key {
+	case "meta":
+		return true, dec.Decode(&s.Meta)
+	case "encrypted_data":
+		return true, dec.Decode(&s.Data)
+	case "encryption_version":
+		return true, dec.Decode(&s.Version)
+	}
+	return false, nil
+}
+
+// pbkdf2Metadata is what the pbkdf2 key provider stores to re-derive its key.
+type pbkdf2Metadata struct {
+	Salt         []byte `json:"salt"`
+	Iterations   int    `json:"iterations"`
+	HashFunction string `json:"hash_function"`
+	KeyLength    int    `json:"key_length"`
+}
+
+// decryptState decrypts an OpenTofu encrypted state envelope using the key
+// material configured in the environment and returns the plain state JSON.
+// Every pbkdf2 key provider recorded in the envelope is tried in turn, so
+// states written during a passphrase rotation still open.
+func decryptState(state encryptedState) ([]byte, error) {
+	if state.Version != "v0" {
+		return nil, fmt.Errorf("unsupported OpenTofu encryption version %q", state.Version)
+	}
+
+	keys, err := stateKeys(state.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if plain, err := decryptAESGCM(key, state.Data); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to decrypt OpenTofu state: wrong passphrase or key")
+}
+
+// stateKeys returns the candidate AES keys for an encrypted state.
+func stateKeys(meta map[string][]byte) ([][]byte, error) {
+	if keyFile := os.Getenv(envStateKeyFile); keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state key file: %w", err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode state key file %q: expected a hex encoded key", keyFile)
+		}
+		return [][]byte{key}, nil
+	}
+
+	passphrase := os.Getenv(envStatePassphrase)
+	if passphrase == "" {
+		return nil, fmt.Errorf("state is encrypted by OpenTofu: set %s or %s", envStatePassphrase, envStateKeyFile)
+	}
+
+	var keys [][]byte
+	for name, raw := range meta {
+		if !strings.HasPrefix(name, pbkdf2MetaPrefix) {
+			continue
+		}
+		var params pbkdf2Metadata
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("failed to parse %s metadata: %w", name, err)
+		}
+		newHash, err := pbkdf2Hash(params.HashFunction)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use %s: %w", name, err)
+		}
+		keys = append(keys, pbkdf2Key([]byte(passphrase), params.Salt, params.Iterations, params.KeyLength, newHash))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("state is encrypted without a pbkdf2 key provider: set %s instead", envStateKeyFile)
+	}
+	return keys, nil
+}
+
+// pbkdf2Hash maps the hash_function names OpenTofu records to hash constructors.
+func pbkdf2Hash(name string) (func() hash.Hash, error) {
+	switch name {
+	case "", "sha512":
+		return sha512.New, nil
+	case "sha256":
+		return sha256.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash function %q", name)
+	}
+}
+
+// pbkdf2Key derives a key as specified by RFC 8018, section 5.2.
+func pbkdf2Key(password, salt []byte, iterations, keyLength int, newHash func() hash.Hash) []byte {
+	prf := hmac.New(newHash, password)
+	size := prf.Size()
+	blocks := (keyLength + size - 1) / size
+
+	key := make([]byte, 0, blocks*size)
+	u := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		_ = binary.Write(prf, binary.BigEndian, uint32(block))
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, size)
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
+
+// decryptAESGCM opens data sealed by OpenTofu's aes_gcm method, which prefixes
+// the ciphertext with its nonce.
+func decryptAESGCM(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
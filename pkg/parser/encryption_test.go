@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// tofuState is an unencrypted state as written by OpenTofu 1.8, including
+// fields Terraform does not write.
+const tofuState = `{
+	"version": 4,
+	"terraform_version": "1.8.3",
+	"serial": 3,
+	"lineage": "0b6c2c8e-4c4a-7d1e-2b3b-8f5a9a1d2c3e",
+	"outputs": {},
+	"resources": [{
+		"mode": "managed",
+		"type": "aws_instance",
+		"name": "web",
+		"provider": "provider[\"registry.opentofu.org/hashicorp/aws\"]",
+		"instances": [{
+			"schema_version": 1,
+			"attributes": {"id": "i-123", "instance_type": "t2.micro"},
+			"sensitive_attributes": [],
+			"private": "eyJzY2hlbWFfdmVyc2lvbiI6IjEifQ==",
+			"dependencies": []
+		}]
+	}],
+	"check_results": null
+}`
+
+func TestParseEncryptedState(t *testing.T) {
+	passphrase := "correct horse battery staple"
+	expected := []types.Resource{{
+		Name:    "i-123",
+		Type:    types.EC2Instance,
+		Data:    normalizeAttributes(map[string]interface{}{"id": "i-123", "instance_type": "t2.micro"}),
+		Address: "aws_instance.web",
+	}}
+
+	keyFile := filepath.Join(t.TempDir(), "state.key")
+	staticKey := bytes.Repeat([]byte{0x42}, 32)
+	assert.NoError(t, os.WriteFile(keyFile, []byte(hex.EncodeToString(staticKey)+"\n"), 0o600))
+
+	tests := []struct {
+		name           string
+		env            map[string]string
+		input          []byte
+		expectedErrMsg string
+	}{
+		{
+			name:  "plain OpenTofu state",
+			input: []byte(tofuState),
+		},
+		{
+			name:  "pbkdf2 passphrase",
+			env:   map[string]string{envStatePassphrase: passphrase},
+			input: encryptWithPassphrase(t, passphrase, []byte(tofuState)),
+		},
+		{
+			name:  "static key file",
+			env:   map[string]string{envStateKeyFile: keyFile},
+			input: encryptWithKey(t, staticKey, []byte(tofuState)),
+		},
+		{
+			name:           "wrong passphrase",
+			env:            map[string]string{envStatePassphrase: "wrong"},
+			input:          encryptWithPassphrase(t, passphrase, []byte(tofuState)),
+			expectedErrMsg: "failed to decrypt OpenTofu state: wrong passphrase or key",
+		},
+		{
+			name:           "no key material",
+			input:          encryptWithPassphrase(t, passphrase, []byte(tofuState)),
+			expectedErrMsg: "state is encrypted by OpenTofu: set " + envStatePassphrase,
+		},
+		{
+			name:           "unknown encryption version",
+			env:            map[string]string{envStatePassphrase: passphrase},
+			input:          []byte(`{"encrypted_data": "AAAA", "encryption_version": "v9"}`),
+			expectedErrMsg: `unsupported OpenTofu encryption version "v9"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envStatePassphrase, "")
+			t.Setenv(envStateKeyFile, "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			fromFile, fileErr := ParseTerraformStateFile(tt.input)
+			fromStream, streamErr := ParseTerraformStateStream(bytes.NewReader(tt.input), nil)
+
+			if tt.expectedErrMsg != "" {
+				assert.ErrorContains(t, fileErr, tt.expectedErrMsg)
+				assert.ErrorContains(t, streamErr, tt.expectedErrMsg)
+				return
+			}
+
+			assert.NoError(t, fileErr)
+			assert.NoError(t, streamErr)
+			assert.Equal(t, expected, fromFile)
+			assert.Equal(t, expected, fromStream)
+		})
+	}
+}
+
+func TestPBKDF2Key(t *testing.T) {
+	// RFC 7914, section 11
+	key := pbkdf2Key([]byte("passwd"), []byte("salt"), 1, 64, sha256.New)
+	assert.Equal(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783", hex.EncodeToString(key))
+
+	assert.Len(t, pbkdf2Key([]byte("passwd"), []byte("salt"), 2, 32, sha512.New), 32)
+}
+
+// encryptWithPassphrase builds the envelope OpenTofu writes for a pbkdf2 key provider.
+func encryptWithPassphrase(t *testing.T, passphrase string, plain []byte) []byte {
+	params := pbkdf2Metadata{Salt: bytes.Repeat([]byte{0x01}, 32), Iterations: 1000, HashFunction: "sha512", KeyLength: 32}
+	key := pbkdf2Key([]byte(passphrase), params.Salt, params.Iterations, params.KeyLength, sha512.New)
+	return mustJSON(t, encryptedState{
+		Meta:    map[string][]byte{pbkdf2MetaPrefix + "main": mustJSON(t, params)},
+		Data:    seal(t, key, plain),
+		Version: "v0",
+	})
+}
+
+// encryptWithKey builds the envelope OpenTofu writes for a static key provider.
+func encryptWithKey(t *testing.T, key, plain []byte) []byte {
+	return mustJSON(t, encryptedState{
+		Meta:    map[string][]byte{"key_provider.static.main": []byte("{}")},
+		Data:    seal(t, key, plain),
+		Version: "v0",
+	})
+}
+
+func seal(t *testing.T, key, plain []byte) []byte {
+	block, err := aes.NewCipher(key)
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	nonce := bytes.Repeat([]byte{0x07}, gcm.NonceSize())
+	return gcm.Seal(nonce, nonce, plain, nil)
+}
@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/papidb/drift-detector/pkg/userdata"
+)
+
+// instanceScalars are aws_instance attributes that are compared as they are.
+var instanceScalars = []string{
+	"source_dest_check",
+	"monitoring",
+	"ebs_optimized",
+	"iam_instance_profile",
+	"disable_api_termination",
+	"disable_api_stop",
+	"hibernation",
+	"tenancy",
+	"placement_group",
+}
+
+// instanceBlocks lists the compared fields of nested aws_instance blocks. Each
+// field is flattened to a block.field key such as metadata_options.http_tokens.
+var instanceBlocks = map[string][]string{
+	"metadata_options": {
+		"http_endpoint",
+		"http_tokens",
+		"http_put_response_hop_limit",
+		"http_protocol_ipv6",
+		"instance_metadata_tags",
+	},
+	"cpu_options":          {"core_count", "threads_per_core"},
+	"credit_specification": {"cpu_credits"},
+	"launch_template":      {"id", "version"},
+}
+
+// legacyInstanceAttributes maps top-level attributes of older AWS provider
+// versions onto the block fields that replaced them.
+var legacyInstanceAttributes = map[string]string{
+	"cpu_core_count":       "cpu_options.core_count",
+	"cpu_threads_per_core": "cpu_options.threads_per_core",
+}
+
+// instanceSettings extracts the instance settings beyond the basic identity
+// fields. Attributes the state does not record are left out, so they are not
+// compared.
+func instanceSettings(attributes map[string]interface{}) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, name := range instanceScalars {
+		setValue(settings, name, attributes[name])
+	}
+	for legacy, key := range legacyInstanceAttributes {
+		setValue(settings, key, attributes[legacy])
+	}
+	for name, fields := range instanceBlocks {
+		block := nestedBlock(attributes[name])
+		for _, field := range fields {
+			setValue(settings, name+"."+field, block[field])
+		}
+	}
+	return settings
+}
+
+// setValue stores a recorded value under key, converting JSON numbers to the
+// integers the cloud side reports. Unrecorded (nil) values are left out.
+func setValue(m map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case float64:
+		m[key] = int64(v)
+	default:
+		m[key] = v
+	}
+}
+
+// nestedBlock returns the fields of a nested block, which Terraform stores as
+// a list holding one object and Pulumi as an object with camelCase keys.
+func nestedBlock(raw interface{}) map[string]interface{} {
+	if list, ok := raw.([]interface{}); ok {
+		if len(list) == 0 {
+			return nil
+		}
+		raw = list[0]
+	}
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	block := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		block[snakeCase(key)] = value
+	}
+	return block
+}
+
+// blockDeviceFields are the compared fields of root_block_device and ebs_block_device entries.
+var blockDeviceFields = []string{
+	"device_name",
+	"volume_id",
+	"volume_size",
+	"volume_type",
+	"iops",
+	"throughput",
+	"encrypted",
+	"kms_key_id",
+	"delete_on_termination",
+}
+
+// blockDevices extracts root_block_device, and ebs_block_device keyed by
+// device name. Pulumi names the latter ebs_block_devices.
+func blockDevices(attributes map[string]interface{}) map[string]interface{} {
+	devices := make(map[string]interface{})
+	if root := nestedBlock(attributes["root_block_device"]); root != nil {
+		devices["root_block_device"] = blockDevice(root)
+	}
+
+	for _, name := range []string{"ebs_block_device", "ebs_block_devices"} {
+		list, ok := attributes[name].([]interface{})
+		if !ok {
+			continue
+		}
+		ebs := make(map[string]map[string]interface{}, len(list))
+		for _, raw := range list {
+			device := blockDevice(nestedBlock(raw))
+			if deviceName, ok := device["device_name"].(string); ok {
+				ebs[deviceName] = device
+			}
+		}
+		devices["ebs_block_device"] = ebs
+	}
+	return devices
+}
+
+func blockDevice(block map[string]interface{}) map[string]interface{} {
+	device := make(map[string]interface{})
+	for _, field := range blockDeviceFields {
+		setValue(device, field, block[field])
+	}
+	return device
+}
+
+// networkInterfaces extracts network_interface keyed by device index. The
+// instance-level secondary addresses belong to the primary interface, device
+// 0, and network_interface blocks (Pulumi's network_interfaces) name the
+// interfaces attached at launch. The instance's security groups, which are
+// the primary interface's, stay in security_groups.
+func networkInterfaces(attributes map[string]interface{}) map[string]interface{} {
+	interfaces := make(map[string]map[string]interface{})
+	device := func(index string) map[string]interface{} {
+		if _, ok := interfaces[index]; !ok {
+			interfaces[index] = make(map[string]interface{})
+		}
+		return interfaces[index]
+	}
+
+	primary := map[string]string{
+		"primary_network_interface_id": "network_interface_id",
+		"secondary_private_ips":        "secondary_private_ips",
+		"ipv6_addresses":               "ipv6_addresses",
+	}
+	for attribute, field := range primary {
+		switch value := attributes[attribute].(type) {
+		case string:
+			device("0")[field] = value
+		case []interface{}:
+			device("0")[field] = sortedStrings(value)
+		}
+	}
+
+	for _, name := range []string{"network_interface", "network_interfaces"} {
+		list, _ := attributes[name].([]interface{})
+		for _, raw := range list {
+			block := nestedBlock(raw)
+			index, ok := block["device_index"].(float64)
+			if !ok {
+				continue
+			}
+			eni := device(fmt.Sprintf("%d", int64(index)))
+			setValue(eni, "network_interface_id", block["network_interface_id"])
+			setValue(eni, "delete_on_termination", block["delete_on_termination"])
+		}
+	}
+
+	if len(interfaces) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"network_interface": interfaces}
+}
+
+// sortedStrings converts a JSON list of strings, whose order AWS does not keep, to a sorted []string.
+func sortedStrings(list []interface{}) []string {
+	strs := make([]string, 0, len(list))
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+// userData extracts user_data: the content when the state records it, via
+// user_data_base64 or as plain text, and otherwise the digest the Terraform AWS
+// provider stores in its place. A null user_data means the instance has none.
+func userData(attributes map[string]interface{}) map[string]interface{} {
+	if encoded, ok := attributes["user_data_base64"].(string); ok && encoded != "" {
+		if raw, err := userdata.DecodeBase64(encoded); err == nil {
+			return map[string]interface{}{"user_data": string(raw)}
+		}
+	}
+
+	value, recorded := attributes["user_data"]
+	if !recorded {
+		return nil
+	}
+	text, _ := value.(string)
+	return map[string]interface{}{"user_data": text}
+}
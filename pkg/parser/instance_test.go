@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]interface{}
+		expected   map[string]interface{}
+	}{
+		{
+			name: "terraform blocks",
+			attributes: map[string]interface{}{
+				"source_dest_check":       true,
+				"monitoring":              false,
+				"iam_instance_profile":    "web",
+				"disable_api_termination": true,
+				"placement_group":         "",
+				"metadata_options": []interface{}{map[string]interface{}{
+					"http_endpoint":               "enabled",
+					"http_tokens":                 "optional",
+					"http_put_response_hop_limit": float64(1),
+				}},
+				"cpu_options":          []interface{}{map[string]interface{}{"core_count": float64(2), "threads_per_core": float64(1)}},
+				"credit_specification": []interface{}{},
+				"launch_template":      []interface{}{map[string]interface{}{"id": "lt-1", "name": "web", "version": "$Latest"}},
+			},
+			expected: map[string]interface{}{
+				"source_dest_check":                            true,
+				"monitoring":                                   false,
+				"iam_instance_profile":                         "web",
+				"disable_api_termination":                      true,
+				"placement_group":                              "",
+				"metadata_options.http_endpoint":               "enabled",
+				"metadata_options.http_tokens":                 "optional",
+				"metadata_options.http_put_response_hop_limit": int64(1),
+				"cpu_options.core_count":                       int64(2),
+				"cpu_options.threads_per_core":                 int64(1),
+				"launch_template.id":                           "lt-1",
+				"launch_template.version":                      "$Latest",
+			},
+		},
+		{
+			name: "pulumi objects",
+			attributes: map[string]interface{}{
+				"metadata_options":     map[string]interface{}{"httpTokens": "required"},
+				"credit_specification": map[string]interface{}{"cpuCredits": "unlimited"},
+			},
+			expected: map[string]interface{}{
+				"metadata_options.http_tokens":     "required",
+				"credit_specification.cpu_credits": "unlimited",
+			},
+		},
+		{
+			name:       "legacy cpu attributes",
+			attributes: map[string]interface{}{"cpu_core_count": float64(4), "cpu_threads_per_core": float64(2)},
+			expected: map[string]interface{}{
+				"cpu_options.core_count":       int64(4),
+				"cpu_options.threads_per_core": int64(2),
+			},
+		},
+		{
+			name:       "nothing recorded",
+			attributes: map[string]interface{}{"id": "i-1"},
+			expected:   map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, instanceSettings(tt.attributes))
+		})
+	}
+}
+
+func TestBlockDevices(t *testing.T) {
+	t.Run("terraform", func(t *testing.T) {
+		devices := blockDevices(map[string]interface{}{
+			"root_block_device": []interface{}{map[string]interface{}{
+				"device_name": "/dev/xvda",
+				"volume_id":   "vol-root",
+				"volume_size": float64(50),
+				"volume_type": "gp3",
+				"encrypted":   false,
+				"kms_key_id":  "",
+				"tags":        map[string]interface{}{"Name": "root"},
+			}},
+			"ebs_block_device": []interface{}{
+				map[string]interface{}{"device_name": "/dev/sdf", "volume_size": float64(100), "iops": float64(4000)},
+			},
+		})
+
+		assert.Equal(t, map[string]interface{}{
+			"root_block_device": map[string]interface{}{
+				"device_name": "/dev/xvda",
+				"volume_id":   "vol-root",
+				"volume_size": int64(50),
+				"volume_type": "gp3",
+				"encrypted":   false,
+				"kms_key_id":  "",
+			},
+			"ebs_block_device": map[string]map[string]interface{}{
+				"/dev/sdf": {"device_name": "/dev/sdf", "volume_size": int64(100), "iops": int64(4000)},
+			},
+		}, devices)
+	})
+
+	t.Run("pulumi", func(t *testing.T) {
+		devices := blockDevices(map[string]interface{}{
+			"root_block_device": map[string]interface{}{"volumeSize": float64(20)},
+			"ebs_block_devices": []interface{}{map[string]interface{}{"deviceName": "/dev/sdg", "volumeType": "gp2"}},
+		})
+
+		assert.Equal(t, map[string]interface{}{
+			"root_block_device": map[string]interface{}{"volume_size": int64(20)},
+			"ebs_block_device": map[string]map[string]interface{}{
+				"/dev/sdg": {"device_name": "/dev/sdg", "volume_type": "gp2"},
+			},
+		}, devices)
+	})
+
+	t.Run("none recorded", func(t *testing.T) {
+		assert.Empty(t, blockDevices(map[string]interface{}{"id": "i-1"}))
+	})
+}
+
+func TestNetworkInterfaces(t *testing.T) {
+	interfaces := networkInterfaces(map[string]interface{}{
+		"primary_network_interface_id": "eni-primary",
+		"secondary_private_ips":        []interface{}{"10.0.0.9", "10.0.0.5"},
+		"ipv6_addresses":               []interface{}{},
+		"vpc_security_group_ids":       []interface{}{"sg-1"},
+		"network_interface": []interface{}{
+			map[string]interface{}{"device_index": float64(1), "network_interface_id": "eni-extra", "delete_on_termination": false},
+		},
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"network_interface": map[string]map[string]interface{}{
+			"0": {
+				"network_interface_id":  "eni-primary",
+				"secondary_private_ips": []string{"10.0.0.5", "10.0.0.9"},
+				"ipv6_addresses":        []string{},
+			},
+			"1": {"network_interface_id": "eni-extra", "delete_on_termination": false},
+		},
+	}, interfaces)
+
+	assert.Nil(t, networkInterfaces(map[string]interface{}{"vpc_security_group_ids": []interface{}{"sg-1"}}))
+}
+
+func TestUserData(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]interface{}
+		expected   map[string]interface{}
+	}{
+		{
+			name:       "digest",
+			attributes: map[string]interface{}{"user_data": "5d41402abc4b2a76b9719d911017c592aaaaaaaa"},
+			expected:   map[string]interface{}{"user_data": "5d41402abc4b2a76b9719d911017c592aaaaaaaa"},
+		},
+		{
+			name:       "base64",
+			attributes: map[string]interface{}{"user_data": nil, "user_data_base64": "IyEvYmluL2Jhc2gKZWNobyBoaQo="},
+			expected:   map[string]interface{}{"user_data": "#!/bin/bash\necho hi\n"},
+		},
+		{
+			name:       "none",
+			attributes: map[string]interface{}{"user_data": nil, "user_data_base64": nil},
+			expected:   map[string]interface{}{"user_data": ""},
+		},
+		{
+			name:       "not recorded",
+			attributes: map[string]interface{}{"id": "i-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, userData(tt.attributes))
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// launchSpecs normalize the resources that launch and manage EC2 instances
+// into launch specifications: the instance attributes their instances are
+// expected to have, under the same keys as a normalized aws_instance. Only
+// what the resource pins down is included, so the rest is not compared.
+var launchSpecs = map[types.ResourceType]func(map[string]interface{}) map[string]interface{}{
+	types.AutoScalingGroup:     autoScalingGroupSpec,
+	types.LaunchConfiguration:  launchConfigurationSpec,
+	types.EKSNodeGroup:         eksNodeGroupSpec,
+	types.SpotFleetRequest:     spotFleetRequestSpec,
+	types.EC2Fleet:             ec2FleetSpec,
+	types.SpotInstanceRequest:  spotInstanceRequestSpec,
+	types.BeanstalkEnvironment: beanstalkEnvironmentSpec,
+}
+
+// LaunchSpecTypes returns the resource types normalized into launch specifications.
+func LaunchSpecTypes() []types.ResourceType {
+	resourceTypes := make([]types.ResourceType, 0, len(launchSpecs))
+	for resourceType := range launchSpecs {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	return resourceTypes
+}
+
+// normalizeResource normalizes a resource's attributes: a launch
+// specification for the resources that launch instances, and the shared
+// instance keys for everything else.
+func normalizeResource(resourceType types.ResourceType, attributes map[string]interface{}) map[string]interface{} {
+	if spec, ok := launchSpecs[resourceType]; ok {
+		return spec(attributes)
+	}
+	return normalizeAttributes(attributes)
+}
+
+// autoScalingGroupSpec takes the group's launch template, directly or from
+// its mixed instances policy. A group using a launch configuration names it
+// as launch_configuration, to be completed from that resource.
+func autoScalingGroupSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := make(map[string]interface{})
+	template := nestedBlock(attributes["launch_template"])
+	if template == nil {
+		policy := nestedBlock(attributes["mixed_instances_policy"])
+		template = nestedBlock(nestedBlock(policy["launch_template"])["launch_template_specification"])
+	}
+	setLaunchTemplate(spec, template)
+	if name, _ := attributes["launch_configuration"].(string); name != "" {
+		spec["launch_configuration"] = name
+	}
+	return spec
+}
+
+// launchConfigurationSpec maps a launch configuration onto instance attributes.
+func launchConfigurationSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := make(map[string]interface{})
+	setValue(spec, "ami", attributes["image_id"])
+	setValue(spec, "instance_type", attributes["instance_type"])
+	setValue(spec, "key_name", attributes["key_name"])
+	setValue(spec, "ebs_optimized", attributes["ebs_optimized"])
+	setValue(spec, "monitoring", attributes["enable_monitoring"])
+	if groups, ok := attributes["security_groups"].([]interface{}); ok {
+		spec["security_groups"] = sortedStrings(groups)
+	}
+	if profile, _ := attributes["iam_instance_profile"].(string); profile != "" {
+		// The profile may be given by ARN; instances report its name
+		spec["iam_instance_profile"] = profile[strings.LastIndex(profile, "/")+1:]
+	}
+	for key, value := range userData(attributes) {
+		spec[key] = value
+	}
+	return spec
+}
+
+// eksNodeGroupSpec takes the node group's launch template, and its instance
+// type when it allows only one.
+func eksNodeGroupSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := make(map[string]interface{})
+	setLaunchTemplate(spec, nestedBlock(attributes["launch_template"]))
+	if instanceTypes, _ := attributes["instance_types"].([]interface{}); len(instanceTypes) == 1 {
+		setValue(spec, "instance_type", instanceTypes[0])
+	}
+	return spec
+}
+
+// spotFleetRequestSpec takes the fleet's launch template, or its launch
+// specification when it has exactly one.
+func spotFleetRequestSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := make(map[string]interface{})
+	config := nestedBlock(attributes["launch_template_config"])
+	setLaunchTemplate(spec, nestedBlock(config["launch_template_specification"]))
+	if specifications, _ := attributes["launch_specification"].([]interface{}); len(specifications) == 1 {
+		specification := nestedBlock(specifications[0])
+		setValue(spec, "ami", specification["ami"])
+		setValue(spec, "instance_type", specification["instance_type"])
+		setValue(spec, "key_name", specification["key_name"])
+	}
+	return spec
+}
+
+// ec2FleetSpec takes the fleet's launch template.
+func ec2FleetSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := make(map[string]interface{})
+	config := nestedBlock(attributes["launch_template_config"])
+	setLaunchTemplate(spec, nestedBlock(config["launch_template_specification"]))
+	return spec
+}
+
+// spotInstanceRequestSpec takes the instance arguments of a Spot request,
+// which match aws_instance's. Its ID and tags are the request's own.
+func spotInstanceRequestSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := normalizeAttributes(attributes)
+	delete(spec, "instance_id")
+	delete(spec, "tags")
+	delete(spec, "tags_all")
+	return spec
+}
+
+// beanstalkSettings maps the Elastic Beanstalk option settings that pin down
+// instances onto instance attributes.
+var beanstalkSettings = map[[2]string]string{
+	{"aws:autoscaling:launchconfiguration", "InstanceType"}: "instance_type",
+	{"aws:ec2:instances", "InstanceTypes"}:                  "instance_type",
+	{"aws:autoscaling:launchconfiguration", "EC2KeyName"}:   "key_name",
+	{"aws:autoscaling:launchconfiguration", "ImageId"}:      "ami",
+}
+
+// beanstalkEnvironmentSpec takes the environment's declared option settings.
+// Pulumi names them settings.
+func beanstalkEnvironmentSpec(attributes map[string]interface{}) map[string]interface{} {
+	spec := make(map[string]interface{})
+	settings, ok := attributes["setting"].([]interface{})
+	if !ok {
+		settings, _ = attributes["settings"].([]interface{})
+	}
+	for _, raw := range settings {
+		setting := nestedBlock(raw)
+		namespace, _ := setting["namespace"].(string)
+		name, _ := setting["name"].(string)
+		value, _ := setting["value"].(string)
+		key, ok := beanstalkSettings[[2]string{namespace, name}]
+		// A list of instance types does not pin down one
+		if !ok || value == "" || strings.Contains(value, ",") {
+			continue
+		}
+		spec[key] = value
+	}
+	return spec
+}
+
+// setLaunchTemplate adds a launch template reference as launch_template.id
+// and launch_template.version. Some resources name the ID launch_template_id.
+func setLaunchTemplate(spec map[string]interface{}, template map[string]interface{}) {
+	id, _ := template["id"].(string)
+	if id == "" {
+		id, _ = template["launch_template_id"].(string)
+	}
+	if id == "" {
+		return
+	}
+	spec["launch_template.id"] = id
+	setValue(spec, "launch_template.version", template["version"])
+}
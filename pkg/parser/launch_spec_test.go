@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeResourceLaunchSpecs(t *testing.T) {
+	tests := []struct {
+		name         string
+		resourceType types.ResourceType
+		attributes   map[string]interface{}
+		expected     map[string]interface{}
+	}{
+		{
+			name:         "auto scaling group with a launch template",
+			resourceType: types.AutoScalingGroup,
+			attributes: map[string]interface{}{
+				"id":                   "web-asg",
+				"launch_configuration": "",
+				"launch_template":      []interface{}{map[string]interface{}{"id": "lt-1", "name": "web", "version": "$Latest"}},
+				"max_size":             float64(3),
+			},
+			expected: map[string]interface{}{"launch_template.id": "lt-1", "launch_template.version": "$Latest"},
+		},
+		{
+			name:         "auto scaling group with a mixed instances policy",
+			resourceType: types.AutoScalingGroup,
+			attributes: map[string]interface{}{
+				"launch_template": []interface{}{},
+				"mixed_instances_policy": []interface{}{map[string]interface{}{
+					"launch_template": []interface{}{map[string]interface{}{
+						"launch_template_specification": []interface{}{map[string]interface{}{"launch_template_id": "lt-2", "version": "$Default"}},
+					}},
+				}},
+			},
+			expected: map[string]interface{}{"launch_template.id": "lt-2", "launch_template.version": "$Default"},
+		},
+		{
+			name:         "auto scaling group with a launch configuration",
+			resourceType: types.AutoScalingGroup,
+			attributes:   map[string]interface{}{"launch_configuration": "web-lc"},
+			expected:     map[string]interface{}{"launch_configuration": "web-lc"},
+		},
+		{
+			name:         "launch configuration",
+			resourceType: types.LaunchConfiguration,
+			attributes: map[string]interface{}{
+				"id":                   "web-lc",
+				"image_id":             "ami-1",
+				"instance_type":        "t3.micro",
+				"key_name":             "deploy",
+				"security_groups":      []interface{}{"sg-2", "sg-1"},
+				"iam_instance_profile": "arn:aws:iam::111111111111:instance-profile/web",
+				"enable_monitoring":    true,
+				"user_data":            nil,
+			},
+			expected: map[string]interface{}{
+				"ami":                  "ami-1",
+				"instance_type":        "t3.micro",
+				"key_name":             "deploy",
+				"security_groups":      []string{"sg-1", "sg-2"},
+				"iam_instance_profile": "web",
+				"monitoring":           true,
+				"user_data":            "",
+			},
+		},
+		{
+			name:         "eks node group with one instance type",
+			resourceType: types.EKSNodeGroup,
+			attributes: map[string]interface{}{
+				"id":              "prod:workers",
+				"instance_types":  []interface{}{"m5.large"},
+				"launch_template": []interface{}{map[string]interface{}{"id": "lt-3", "version": "4"}},
+			},
+			expected: map[string]interface{}{"instance_type": "m5.large", "launch_template.id": "lt-3", "launch_template.version": "4"},
+		},
+		{
+			name:         "eks node group with several instance types",
+			resourceType: types.EKSNodeGroup,
+			attributes:   map[string]interface{}{"instance_types": []interface{}{"m5.large", "m5a.large"}},
+			expected:     map[string]interface{}{},
+		},
+		{
+			name:         "spot fleet with one launch specification",
+			resourceType: types.SpotFleetRequest,
+			attributes: map[string]interface{}{
+				"launch_specification": []interface{}{map[string]interface{}{"ami": "ami-1", "instance_type": "c5.large", "key_name": "deploy"}},
+			},
+			expected: map[string]interface{}{"ami": "ami-1", "instance_type": "c5.large", "key_name": "deploy"},
+		},
+		{
+			name:         "ec2 fleet",
+			resourceType: types.EC2Fleet,
+			attributes: map[string]interface{}{
+				"launch_template_config": []interface{}{map[string]interface{}{
+					"launch_template_specification": []interface{}{map[string]interface{}{"launch_template_id": "lt-4", "version": "1"}},
+				}},
+			},
+			expected: map[string]interface{}{"launch_template.id": "lt-4", "launch_template.version": "1"},
+		},
+		{
+			name:         "elastic beanstalk environment",
+			resourceType: types.BeanstalkEnvironment,
+			attributes: map[string]interface{}{
+				"setting": []interface{}{
+					map[string]interface{}{"namespace": "aws:autoscaling:launchconfiguration", "name": "EC2KeyName", "value": "deploy"},
+					map[string]interface{}{"namespace": "aws:ec2:instances", "name": "InstanceTypes", "value": "t3.small,t3.medium"},
+					map[string]interface{}{"namespace": "aws:elasticbeanstalk:environment", "name": "EnvironmentType", "value": "LoadBalanced"},
+				},
+			},
+			expected: map[string]interface{}{"key_name": "deploy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, normalizeResource(tt.resourceType, tt.attributes))
+		})
+	}
+
+	t.Run("spot instance request", func(t *testing.T) {
+		spec := normalizeResource(types.SpotInstanceRequest, map[string]interface{}{
+			"id":            "sir-1",
+			"ami":           "ami-1",
+			"instance_type": "t3.micro",
+			"tags":          map[string]interface{}{"Name": "request"},
+		})
+		assert.Equal(t, "ami-1", spec["ami"])
+		assert.Equal(t, "t3.micro", spec["instance_type"])
+		assert.NotContains(t, spec, "instance_id", "the ID is the request's")
+		assert.NotContains(t, spec, "tags", "the tags are the request's")
+	})
+}
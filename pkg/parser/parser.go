@@ -1,19 +1,54 @@
 package parser
 
-import "github.com/papidb/drift-detector/internal/types"
+import (
+	"fmt"
+	"io"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/common"
+)
 
 // Parser is an interface for parsing Terraform state files
 type Parser interface {
 	ParseTerraformStateFile(data []byte) ([]types.Resource, error)
+	ParseTerraformStateStream(r io.Reader) ([]types.Resource, error)
 }
 
 // DefaultParser is the default implementation of Parser
-type DefaultParser struct{}
+type DefaultParser struct {
+	supported map[types.ResourceType]struct{}
+}
 
 func (p *DefaultParser) ParseTerraformStateFile(data []byte) ([]types.Resource, error) {
 	return ParseTerraformStateFile(data)
 }
 
-func NewParser() *DefaultParser {
-	return &DefaultParser{}
+func (p *DefaultParser) ParseTerraformStateStream(r io.Reader) ([]types.Resource, error) {
+	return ParseTerraformStateStream(r, p.supported)
+}
+
+// NewParser creates a parser. When supported resource types are given, the
+// streaming parser skips every other resource type without decoding it.
+func NewParser(supported ...types.ResourceType) *DefaultParser {
+	var set map[types.ResourceType]struct{}
+	if len(supported) > 0 {
+		set = make(map[types.ResourceType]struct{}, len(supported))
+		for _, resourceType := range supported {
+			set[resourceType] = struct{}{}
+		}
+	}
+	return &DefaultParser{supported: set}
+}
+
+// NewSourceParser returns the parser for the given desired-state source.
+// CloudFormation templates need the stack's physical IDs, see NewCloudFormationParser.
+func NewSourceParser(source common.SourceType, supported ...types.ResourceType) (Parser, error) {
+	switch source {
+	case common.SourceTerraform, "":
+		return NewParser(supported...), nil
+	case common.SourcePulumi:
+		return NewPulumiParser(supported...), nil
+	default:
+		return nil, fmt.Errorf("unsupported source %q", source)
+	}
 }
@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// pulumiResourceTypes maps Pulumi type tokens onto the Terraform resource types
+// the comparators are registered for.
+var pulumiResourceTypes = map[string]types.ResourceType{
+	"aws:ec2/instance:Instance":                       types.EC2Instance,
+	"aws:autoscaling/group:Group":                     types.AutoScalingGroup,
+	"aws:ec2/launchConfiguration:LaunchConfiguration": types.LaunchConfiguration,
+	"aws:eks/nodeGroup:NodeGroup":                     types.EKSNodeGroup,
+	"aws:ec2/spotFleetRequest:SpotFleetRequest":       types.SpotFleetRequest,
+	"aws:ec2/fleet:Fleet":                             types.EC2Fleet,
+	"aws:ec2/spotInstanceRequest:SpotInstanceRequest": types.SpotInstanceRequest,
+	"aws:elasticbeanstalk/environment:Environment":    types.BeanstalkEnvironment,
+}
+
+// pulumiSecretSig is the signature key Pulumi uses to mark a secret value.
+const pulumiSecretSig = "4dabf18193072939515e22adb298388d"
+
+// pulumiStackExport is the subset of `pulumi stack export` output we need.
+type pulumiStackExport struct {
+	Deployment *struct {
+		Resources []pulumiResource `json:"resources"`
+	} `json:"deployment"`
+}
+
+type pulumiResource struct {
+	URN      string                 `json:"urn"`
+	Custom   bool                   `json:"custom"`
+	Delete   bool                   `json:"delete"`
+	External bool                   `json:"external"`
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Outputs  map[string]interface{} `json:"outputs"`
+}
+
+// ParsePulumiStackExport parses the JSON written by `pulumi stack export` and
+// returns its resources with the same normalized attributes the Terraform
+// parser produces. Component and provider resources, resources pending
+// deletion and resources only read by the stack are skipped. A nil supported
+// set keeps every other resource, typed by its Pulumi token when it has no
+// Terraform equivalent.
+func ParsePulumiStackExport(data []byte, supported map[types.ResourceType]struct{}) ([]types.Resource, error) {
+	var export pulumiStackExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to parse stack export: %w", err)
+	}
+	if export.Deployment == nil {
+		return nil, fmt.Errorf("no deployment found in stack export")
+	}
+
+	results := make([]types.Resource, 0)
+	for _, res := range export.Deployment.Resources {
+		if !res.Custom || res.Delete || res.External || strings.HasPrefix(res.Type, "pulumi:providers:") {
+			continue
+		}
+
+		resourceType, ok := pulumiResourceTypes[res.Type]
+		if !ok {
+			resourceType = types.ResourceType(res.Type)
+		}
+		if !isSupported(supported, string(resourceType)) {
+			continue
+		}
+
+		attributes, sensitive := pulumiAttributes(res.Outputs)
+		if _, ok := attributes["id"]; !ok {
+			attributes["id"] = res.ID
+		}
+
+		resource := types.NewResource(res.ID, resourceType, normalizeResource(resourceType, attributes))
+		resource.Address = res.URN
+		resource.Sensitive = sensitive
+		resource.Region = regionOf(attributes)
+		resource.Account = accountOf(attributes)
+		resource.ARN, _ = attributes["arn"].(string)
+		results = append(results, resource)
+	}
+	return results, nil
+}
+
+// pulumiAttributes converts camelCase Pulumi outputs to Terraform attribute
+// names and unwraps secrets, returning the normalized paths of the secrets.
+func pulumiAttributes(outputs map[string]interface{}) (map[string]interface{}, []string) {
+	attributes := make(map[string]interface{}, len(outputs))
+	var sensitive []string
+
+	for key, value := range outputs {
+		name := snakeCase(key)
+		path := name
+		if renamed, ok := renamedAttributes[name]; ok {
+			path = renamed
+		}
+
+		if plain, ok := unwrapPulumiSecret(value); ok {
+			value = plain
+			sensitive = append(sensitive, path)
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			for k, v := range nested {
+				if plain, ok := unwrapPulumiSecret(v); ok {
+					nested[k] = plain
+					sensitive = append(sensitive, path+"."+k)
+				}
+			}
+		}
+		attributes[name] = value
+	}
+	return attributes, sensitive
+}
+
+// unwrapPulumiSecret returns the plaintext of a secret value. Secrets exported
+// without --show-secrets only carry ciphertext and unwrap to nil.
+func unwrapPulumiSecret(value interface{}) (interface{}, bool) {
+	secret, ok := value.(map[string]interface{})
+	if !ok || secret[pulumiSecretSig] == nil {
+		return nil, false
+	}
+
+	plaintext, ok := secret["plaintext"].(string)
+	if !ok {
+		return nil, true
+	}
+	var plain interface{}
+	if err := json.Unmarshal([]byte(plaintext), &plain); err != nil {
+		return plaintext, true
+	}
+	return plain, true
+}
+
+// snakeCase converts a camelCase output name such as vpcSecurityGroupIds to
+// the Terraform spelling vpc_security_group_ids.
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// PulumiParser implements Parser for `pulumi stack export` documents so the
+// rest of the pipeline can treat a stack like a state file.
+type PulumiParser struct {
+	supported map[types.ResourceType]struct{}
+}
+
+func (p *PulumiParser) ParseTerraformStateFile(data []byte) ([]types.Resource, error) {
+	return ParsePulumiStackExport(data, p.supported)
+}
+
+// ParseTerraformStateStream reads the whole export before parsing it; stack
+// exports lack the bulky unsupported resources that make streaming pay off.
+func (p *PulumiParser) ParseTerraformStateStream(r io.Reader) ([]types.Resource, error) {
+	rc, err := decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stack export: %w", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stack export: %w", err)
+	}
+	return ParsePulumiStackExport(data, p.supported)
+}
+
+// NewPulumiParser creates a parser for Pulumi stack exports, keeping only the
+// given resource types when any are given.
+func NewPulumiParser(supported ...types.ResourceType) *PulumiParser {
+	return &PulumiParser{supported: NewParser(supported...).supported}
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const stackExport = `{
+	"version": 3,
+	"deployment": {
+		"manifest": {"time": "2024-05-01T10:00:00Z", "version": "v3.115.0"},
+		"resources": [
+			{
+				"urn": "urn:pulumi:dev::web::pulumi:pulumi:Stack::web-dev",
+				"custom": false,
+				"type": "pulumi:pulumi:Stack"
+			},
+			{
+				"urn": "urn:pulumi:dev::web::pulumi:providers:aws::default_6_0_0",
+				"custom": true,
+				"id": "5c0b4f2e",
+				"type": "pulumi:providers:aws"
+			},
+			{
+				"urn": "urn:pulumi:dev::web::aws:ec2/instance:Instance::web",
+				"custom": true,
+				"id": "i-1234567890abcdef0",
+				"type": "aws:ec2/instance:Instance",
+				"outputs": {
+					"ami": "ami-12345678",
+					"availabilityZone": "us-west-2a",
+					"instanceState": "running",
+					"instanceType": "t2.micro",
+					"keyName": "my-key",
+					"privateIp": "10.0.0.1",
+					"publicIp": "203.0.113.1",
+					"subnetId": "subnet-12345678",
+					"tags": {
+						"Name": "test-instance",
+						"ApiKey": {"4dabf18193072939515e22adb298388d": "1b47061264138c4ac30d75fd1eb44270", "plaintext": "\"s3cr3t\""}
+					},
+					"vpcSecurityGroupIds": ["sg-12345678"]
+				}
+			},
+			{
+				"urn": "urn:pulumi:dev::web::aws:ec2/instance:Instance::old",
+				"custom": true,
+				"delete": true,
+				"id": "i-0000000000000000",
+				"type": "aws:ec2/instance:Instance",
+				"outputs": {}
+			},
+			{
+				"urn": "urn:pulumi:dev::web::aws:s3/bucket:Bucket::logs",
+				"custom": true,
+				"id": "logs",
+				"type": "aws:s3/bucket:Bucket",
+				"outputs": {"bucket": "logs"}
+			}
+		]
+	}
+}`
+
+func TestParsePulumiStackExport(t *testing.T) {
+	instance := types.NewResource("i-1234567890abcdef0", types.EC2Instance, map[string]interface{}{
+		"instance_id":       "i-1234567890abcdef0",
+		"instance_type":     "t2.micro",
+		"ami":               "ami-12345678",
+		"key_name":          "my-key",
+		"subnet_id":         "subnet-12345678",
+		"availability_zone": "us-west-2a",
+		"state":             "running",
+		"private_ip":        "10.0.0.1",
+		"public_ip":         "203.0.113.1",
+		"tags":              map[string]string{"Name": "test-instance", "ApiKey": "s3cr3t"},
+		"security_groups":   []string{"sg-12345678"},
+	})
+	instance.Address = "urn:pulumi:dev::web::aws:ec2/instance:Instance::web"
+	instance.Sensitive = []string{"tags.ApiKey"}
+	instance.Region = "us-west-2"
+
+	tests := []struct {
+		name           string
+		input          string
+		supported      map[types.ResourceType]struct{}
+		expected       []types.Resource
+		expectedErrMsg string
+	}{
+		{
+			name:      "maps supported types",
+			input:     stackExport,
+			supported: map[types.ResourceType]struct{}{types.EC2Instance: {}},
+			expected:  []types.Resource{instance},
+		},
+		{
+			name:  "keeps unmapped types by token when unfiltered",
+			input: stackExport,
+			expected: []types.Resource{instance, {
+				Name:    "logs",
+				Type:    types.ResourceType("aws:s3/bucket:Bucket"),
+				Data:    normalizeAttributes(map[string]interface{}{"id": "logs", "bucket": "logs"}),
+				Address: "urn:pulumi:dev::web::aws:s3/bucket:Bucket::logs",
+			}},
+		},
+		{
+			name:           "not a stack export",
+			input:          `{"version": 4, "resources": []}`,
+			expectedErrMsg: "no deployment found in stack export",
+		},
+		{
+			name:           "invalid JSON",
+			input:          `{invalid`,
+			expectedErrMsg: "failed to parse stack export",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParsePulumiStackExport([]byte(tt.input), tt.supported)
+
+			if tt.expectedErrMsg != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMsg)
+				assert.Nil(t, result)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestNewSourceParser(t *testing.T) {
+	p, err := NewSourceParser(common.SourcePulumi, types.EC2Instance)
+	assert.NoError(t, err)
+	resources, err := p.ParseTerraformStateStream(bytes.NewReader(gzipBytes(t, []byte(stackExport))))
+	assert.NoError(t, err)
+	assert.Len(t, resources, 1)
+
+	p, err = NewSourceParser(common.SourceTerraform)
+	assert.NoError(t, err)
+	assert.IsType(t, &DefaultParser{}, p)
+
+	_, err = NewSourceParser("cdk")
+	assert.EqualError(t, err, `unsupported source "cdk"`)
+}
+
+func TestSnakeCase(t *testing.T) {
+	assert.Equal(t, "vpc_security_group_ids", snakeCase("vpcSecurityGroupIds"))
+	assert.Equal(t, "private_ip", snakeCase("privateIp"))
+	assert.Equal(t, "ami", snakeCase("ami"))
+}
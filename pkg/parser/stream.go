@@ -0,0 +1,308 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// ParseTerraformStateStream parses a Terraform state file from r without loading
+// the whole document into memory. The "resources" array is walked token by token
+// and only instances of a supported resource type are decoded; everything else is
+// skipped. A nil supported set decodes every resource type. Gzip-compressed input
+// is detected and decompressed transparently. OpenTofu encrypted state has to be
+// decrypted as a whole, so it is buffered before its plain text is parsed.
+func ParseTerraformStateStream(r io.Reader, supported map[types.ResourceType]struct{}) ([]types.Resource, error) {
+	rc, err := decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	defer rc.Close()
+
+	dec := json.NewDecoder(rc)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	var (
+		results        []types.Resource
+		foundResources bool
+		encrypted      encryptedState
+	)
+
+	for dec.More() {
+		key, err := readKey(dec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		}
+
+		if ok, err := encrypted.decodeField(dec, key); ok || err != nil {
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse state file: %w", err)
+			}
+			continue
+		}
+		if key != "resources" {
+			if err := skipValue(dec); err != nil {
+				return nil, fmt.Errorf("failed to parse state file: %w", err)
+			}
+			continue
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			// Mirror ParseTerraformStateFile: anything but an array means no resources.
+			if err := skipRest(dec, tok); err != nil {
+				return nil, fmt.Errorf("failed to parse state file: %w", err)
+			}
+			continue
+		}
+
+		foundResources = true
+		results = make([]types.Resource, 0)
+		for dec.More() {
+			resources, err := decodeStreamResource(dec, supported)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse state file: %w", err)
+			}
+			results = append(results, resources...)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	if encrypted.Data != nil {
+		plain, err := decryptState(encrypted)
+		if err != nil {
+			return nil, err
+		}
+		return ParseTerraformStateStream(bytes.NewReader(plain), supported)
+	}
+
+	if !foundResources {
+		return nil, fmt.Errorf("no resources found in state file")
+	}
+
+	return results, nil
+}
+
+// decodeStreamResource decodes one element of the "resources" array. Instances are
+// only materialised once the resource type is known to be supported; if the
+// "instances" key precedes "type" the raw instances are buffered until it is known.
+// Terraform writes module, mode, type and name before instances; the address of
+// each decoded instance is still recomputed once the whole entry has been read.
+func decodeStreamResource(dec *json.Decoder, supported map[types.ResourceType]struct{}) ([]types.Resource, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, skipRest(dec, tok)
+	}
+
+	var (
+		header    resourceHeader
+		typeKnown bool
+		buffered  []json.RawMessage
+		results   []types.Resource
+		indexKeys []interface{}
+	)
+
+	for dec.More() {
+		key, err := readKey(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "type":
+			if err := dec.Decode(&header.Type); err != nil {
+				return nil, err
+			}
+			typeKnown = true
+
+		case "module":
+			if err := dec.Decode(&header.Module); err != nil {
+				return nil, err
+			}
+
+		case "mode":
+			if err := dec.Decode(&header.Mode); err != nil {
+				return nil, err
+			}
+
+		case "name":
+			if err := dec.Decode(&header.Name); err != nil {
+				return nil, err
+			}
+
+		case "instances":
+			if typeKnown && !isSupported(supported, header.Type) {
+				if err := skipValue(dec); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				if err := skipRest(dec, tok); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			for dec.More() {
+				if !typeKnown {
+					var raw json.RawMessage
+					if err := dec.Decode(&raw); err != nil {
+						return nil, err
+					}
+					buffered = append(buffered, raw)
+					continue
+				}
+
+				var inst interface{}
+				if err := dec.Decode(&inst); err != nil {
+					return nil, err
+				}
+				if resource, ok := terraformInstanceToResource(header, inst); ok {
+					results = append(results, resource)
+					indexKeys = append(indexKeys, indexKeyOf(inst))
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return nil, err
+			}
+
+		default:
+			if err := skipValue(dec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+
+	if len(buffered) > 0 && isSupported(supported, header.Type) {
+		for _, raw := range buffered {
+			var inst interface{}
+			if err := json.Unmarshal(raw, &inst); err != nil {
+				return nil, err
+			}
+			if resource, ok := terraformInstanceToResource(header, inst); ok {
+				results = append(results, resource)
+				indexKeys = append(indexKeys, indexKeyOf(inst))
+			}
+		}
+	}
+
+	for i := range results {
+		results[i].Address = header.address(indexKeys[i])
+	}
+
+	return results, nil
+}
+
+// indexKeyOf returns the index_key of a decoded instance, if any.
+func indexKeyOf(inst interface{}) interface{} {
+	instanceMap, _ := inst.(map[string]interface{})
+	return instanceMap["index_key"]
+}
+
+// isSupported reports whether instances of resourceType should be decoded.
+func isSupported(supported map[types.ResourceType]struct{}, resourceType string) bool {
+	if supported == nil {
+		return true
+	}
+	_, ok := supported[types.ResourceType(resourceType)]
+	return ok
+}
+
+// decompress transparently unwraps gzip-compressed input by sniffing its magic
+// bytes. Closing the result releases the decompressor, not r.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return io.NopCloser(br), nil
+}
+
+// readKey reads the next object key from the decoder.
+func readKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key, got %v", tok)
+	}
+	return key, nil
+}
+
+// expectDelim reads the next token and checks that it is the given delimiter.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
+
+// skipValue discards the next JSON value without materialising it.
+func skipValue(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	return skipRest(dec, tok)
+}
+
+// skipRest discards the remainder of a value whose first token has already been read.
+func skipRest(dec *json.Decoder, tok json.Token) error {
+	delim, ok := tok.(json.Delim)
+	if !ok || delim == '}' || delim == ']' {
+		return nil
+	}
+
+	depth := 1
+	for depth > 0 {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+	}
+	return nil
+}
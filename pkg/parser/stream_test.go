@@ -0,0 +1,355 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTerraformStateStream(t *testing.T) {
+	instanceAttributes := map[string]interface{}{
+		"id":                     "i-1234567890abcdef0",
+		"instance_type":          "t2.micro",
+		"ami":                    "ami-12345678",
+		"key_name":               "my-key",
+		"subnet_id":              "subnet-12345678",
+		"availability_zone":      "us-west-2a",
+		"instance_state":         "running",
+		"private_ip":             "10.0.0.1",
+		"public_ip":              "203.0.113.1",
+		"tags":                   map[string]string{"Name": "test-instance"},
+		"vpc_security_group_ids": []string{"sg-12345678"},
+	}
+	expectedInstance := types.NewResource(
+		"i-1234567890abcdef0",
+		types.EC2Instance,
+		map[string]interface{}{
+			"instance_id":       "i-1234567890abcdef0",
+			"instance_type":     "t2.micro",
+			"ami":               "ami-12345678",
+			"key_name":          "my-key",
+			"subnet_id":         "subnet-12345678",
+			"availability_zone": "us-west-2a",
+			"state":             "running",
+			"private_ip":        "10.0.0.1",
+			"public_ip":         "203.0.113.1",
+			"tags":              map[string]string{"Name": "test-instance"},
+			"security_groups":   []string{"sg-12345678"},
+		},
+	)
+
+	expectedInstance.Region = "us-west-2"
+
+	addressedInstance := expectedInstance
+	addressedInstance.Address = "aws_instance.web"
+
+	validState := mustJSON(t, map[string]interface{}{
+		"version": 4,
+		"outputs": map[string]interface{}{"ip": map[string]interface{}{"value": "10.0.0.1"}},
+		"resources": []interface{}{
+			map[string]interface{}{
+				"mode": "managed",
+				"type": "aws_security_group",
+				"name": "web",
+				"instances": []interface{}{
+					map[string]interface{}{"attributes": map[string]interface{}{"id": "sg-12345678"}},
+				},
+			},
+			map[string]interface{}{
+				"mode":      "managed",
+				"type":      "aws_instance",
+				"name":      "web",
+				"instances": []interface{}{map[string]interface{}{"attributes": instanceAttributes}},
+			},
+		},
+	})
+
+	// encoding/json sorts map keys, so build this one by hand to put "instances" first
+	instancesFirstState := []byte(`{"resources":[{"instances":[{"attributes":` +
+		string(mustJSON(t, instanceAttributes)) + `}],"type":"aws_instance"}]}`)
+
+	tests := []struct {
+		name           string
+		input          []byte
+		supported      map[types.ResourceType]struct{}
+		expected       []types.Resource
+		expectedErrMsg string
+	}{
+		{
+			name:      "skips unsupported resource types",
+			input:     validState,
+			supported: map[types.ResourceType]struct{}{types.EC2Instance: {}},
+			expected:  []types.Resource{addressedInstance},
+		},
+		{
+			name:  "decodes every type when no filter is given",
+			input: validState,
+			expected: []types.Resource{
+				{
+					Name:    "sg-12345678",
+					Type:    types.ResourceType("aws_security_group"),
+					Data:    normalizeAttributes(map[string]interface{}{"id": "sg-12345678"}),
+					Address: "aws_security_group.web",
+				},
+				addressedInstance,
+			},
+		},
+		{
+			name:      "gzip compressed state",
+			input:     gzipBytes(t, validState),
+			supported: map[types.ResourceType]struct{}{types.EC2Instance: {}},
+			expected:  []types.Resource{addressedInstance},
+		},
+		{
+			name:      "instances before type",
+			input:     instancesFirstState,
+			supported: map[types.ResourceType]struct{}{types.EC2Instance: {}},
+			expected:  []types.Resource{expectedInstance},
+		},
+		{
+			name:     "empty resources",
+			input:    []byte(`{"resources": []}`),
+			expected: []types.Resource{},
+		},
+		{
+			name:     "invalid instances field",
+			input:    []byte(`{"resources": [{"type": "aws_instance", "instances": "not-an-array"}]}`),
+			expected: []types.Resource{},
+		},
+		{
+			name:     "missing attributes",
+			input:    []byte(`{"resources": [{"type": "aws_instance", "instances": [{"other_field": "value"}]}]}`),
+			expected: []types.Resource{},
+		},
+		{
+			name:           "no resources field",
+			input:          []byte(`{"other_field": "value"}`),
+			expectedErrMsg: "no resources found in state file",
+		},
+		{
+			name:           "resources is not an array",
+			input:          []byte(`{"resources": {"type": "aws_instance"}}`),
+			expectedErrMsg: "no resources found in state file",
+		},
+		{
+			name:           "invalid JSON",
+			input:          []byte(`{invalid json`),
+			expectedErrMsg: "failed to parse state file",
+		},
+		{
+			name:           "truncated state",
+			input:          validState[:len(validState)/2],
+			expectedErrMsg: "failed to parse state file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseTerraformStateStream(bytes.NewReader(tt.input), tt.supported)
+
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				assert.Nil(t, result)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestParseTerraformStateStreamMatchesFileParser(t *testing.T) {
+	state := largeState(50, 5)
+
+	fromFile, err := ParseTerraformStateFile(state)
+	assert.NoError(t, err)
+
+	fromStream, err := ParseTerraformStateStream(bytes.NewReader(state), nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, fromFile, fromStream)
+}
+
+// BenchmarkParseTerraformStateFile and BenchmarkParseTerraformStateStream parse the
+// same in-memory state, dominated by resources without a comparator, so the two
+// can be compared for throughput and total allocations.
+func BenchmarkParseTerraformStateFile(b *testing.B) {
+	state := largeState(2000, 10)
+	b.SetBytes(int64(len(state)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTerraformStateFile(state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseTerraformStateStream(b *testing.B) {
+	state := largeState(2000, 10)
+	supported := map[types.ResourceType]struct{}{types.EC2Instance: {}}
+	b.SetBytes(int64(len(state)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTerraformStateStream(bytes.NewReader(state), supported); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseTerraformStateStreamGzip(b *testing.B) {
+	state := gzipBytes(b, largeState(2000, 10))
+	supported := map[types.ResourceType]struct{}{types.EC2Instance: {}}
+	b.SetBytes(int64(len(state)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTerraformStateStream(bytes.NewReader(state), supported); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseTerraformStateStreamPeakHeap streams generated states of growing
+// size that never exist in memory as a whole. The reported peak-heap-B should stay
+// flat as the number of resources grows by an order of magnitude.
+func BenchmarkParseTerraformStateStreamPeakHeap(b *testing.B) {
+	supported := map[types.ResourceType]struct{}{types.EC2Instance: {}}
+
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("resources=%d", n), func(b *testing.B) {
+			var peak uint64
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				stop := samplePeakHeap(&peak)
+				_, err := ParseTerraformStateStream(generatedState(n, 10), supported)
+				stop()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
+
+// generatedState streams the same document as largeState through a pipe.
+func generatedState(unsupported, instances int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriter(pw)
+		w.WriteString(`{"version":4,"resources":[`)
+		for i := 0; i < unsupported; i++ {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			writeUnsupportedResource(w, i)
+		}
+		for i := 0; i < instances; i++ {
+			w.WriteString(",")
+			writeInstanceResource(w, i)
+		}
+		w.WriteString(`]}`)
+		pw.CloseWithError(w.Flush())
+	}()
+	return pr
+}
+
+// samplePeakHeap records the highest live heap size observed until the returned func is called.
+func samplePeakHeap(peak *uint64) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			metrics.Read(sample)
+			if v := sample[0].Value.Uint64(); v > *peak {
+				*peak = v
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// largeState builds a state with many bulky unsupported resources and a few EC2 instances.
+func largeState(unsupported, instances int) []byte {
+	data, err := io.ReadAll(generatedState(unsupported, instances))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func writeUnsupportedResource(w io.Writer, i int) {
+	fmt.Fprintf(w, `{"mode":"managed","type":"aws_iam_policy","name":"p%d","instances":[{"attributes":{"id":"p%d","policy":%q,"tags":{"Index":"%d"}}}]}`,
+		i, i, strings.Repeat(`{"Effect":"Allow","Action":"s3:GetObject"}`, 50), i)
+}
+
+func TestDecompress(t *testing.T) {
+	for name, input := range map[string][]byte{"plain": []byte(`{}`), "gzip": gzipBytes(t, []byte(`{}`))} {
+		t.Run(name, func(t *testing.T) {
+			rc, err := decompress(bytes.NewReader(input))
+			assert.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			assert.NoError(t, err)
+			assert.Equal(t, `{}`, string(data))
+			assert.NoError(t, rc.Close())
+		})
+	}
+}
+
+func writeInstanceResource(w io.Writer, i int) {
+	fmt.Fprintf(w, `{"mode":"managed","type":"aws_instance","name":"i%d","instances":[{"attributes":{"id":"i-%08d","instance_type":"t2.micro","tags":{"Name":"web"},"vpc_security_group_ids":["sg-1"]}}]}`, i, i)
+}
+
+func mustJSON(t testing.TB, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func gzipBytes(t testing.TB, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -3,18 +3,34 @@ package parser
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/common"
 )
 
 // ParseTerraformStateFile parses a Terraform state file and extracts only the attributes of each instance.
+// OpenTofu encrypted state is decrypted first, see decryptState.
 func ParseTerraformStateFile(data []byte) ([]types.Resource, error) {
 	var state map[string]interface{}
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, fmt.Errorf("failed to parse state file: %w", err)
 	}
 
+	if _, ok := state["encrypted_data"]; ok {
+		var encrypted encryptedState
+		if err := json.Unmarshal(data, &encrypted); err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		}
+		plain, err := decryptState(encrypted)
+		if err != nil {
+			return nil, err
+		}
+		return ParseTerraformStateFile(plain)
+	}
+
 	results := make([]types.Resource, 0) // Initialize as empty slice
+
 	resources, ok := state["resources"].([]interface{})
 	if !ok {
 		return nil, fmt.Errorf("no resources found in state file")
@@ -26,7 +42,11 @@ func ParseTerraformStateFile(data []byte) ([]types.Resource, error) {
 			continue
 		}
 
-		resourceType, _ := resourceMap["type"].(string)
+		header := resourceHeader{}
+		header.Module, _ = resourceMap["module"].(string)
+		header.Mode, _ = resourceMap["mode"].(string)
+		header.Type, _ = resourceMap["type"].(string)
+		header.Name, _ = resourceMap["name"].(string)
 
 		instances, ok := resourceMap["instances"].([]interface{})
 		if !ok {
@@ -34,58 +54,203 @@ func ParseTerraformStateFile(data []byte) ([]types.Resource, error) {
 		}
 
 		for _, inst := range instances {
-			instanceMap, ok := inst.(map[string]interface{})
+			resource, ok := terraformInstanceToResource(header, inst)
 			if !ok {
 				continue
 			}
+			results = append(results, resource)
+		}
+	}
+
+	return results, nil
+}
+
+// resourceHeader holds the fields of a state "resources" entry that identify it.
+type resourceHeader struct {
+	Module string
+	Mode   string
+	Type   string
+	Name   string
+}
+
+// address returns the Terraform address of one instance of the resource, e.g.
+// module.app.aws_instance.web[0]. It is empty when the header has no name.
+func (h resourceHeader) address(indexKey interface{}) string {
+	if h.Type == "" || h.Name == "" {
+		return ""
+	}
+
+	address := h.Type + "." + h.Name
+	if h.Mode == "data" {
+		address = "data." + address
+	}
+	if h.Module != "" {
+		address = h.Module + "." + address
+	}
+
+	switch key := indexKey.(type) {
+	case string:
+		address += fmt.Sprintf("[%q]", key)
+	case float64:
+		address += fmt.Sprintf("[%d]", int(key))
+	}
+	return address
+}
+
+// terraformInstanceToResource converts a single entry of a resource's "instances"
+// array into a normalized resource. It reports false when the entry carries no attributes.
+func terraformInstanceToResource(header resourceHeader, inst interface{}) (types.Resource, bool) {
+	instanceMap, ok := inst.(map[string]interface{})
+	if !ok {
+		return types.Resource{}, false
+	}
+
+	attributes, ok := instanceMap["attributes"].(map[string]interface{})
+	if !ok {
+		return types.Resource{}, false
+	}
+
+	resource := types.NewResource(
+		fmt.Sprintf("%v", attributes["id"]),
+		types.ResourceType(header.Type),
+		normalizeResource(types.ResourceType(header.Type), attributes),
+	)
+	resource.Address = header.address(instanceMap["index_key"])
+	resource.Sensitive = sensitiveAttributePaths(instanceMap["sensitive_attributes"])
+	resource.Region = regionOf(attributes)
+	resource.Account = accountOf(attributes)
+	resource.ARN, _ = attributes["arn"].(string)
+	return resource, true
+}
+
+// regionOf derives a resource's region from its ARN or, failing that, its
+// availability zone. State does not otherwise record which region the
+// provider configuration pointed at.
+func regionOf(attributes map[string]interface{}) string {
+	if arn, ok := attributes["arn"].(string); ok {
+		if region := common.RegionFromARN(arn); region != "" {
+			return region
+		}
+	}
+	zone, _ := attributes["availability_zone"].(string)
+	return common.RegionFromAvailabilityZone(zone)
+}
+
+// accountOf derives a resource's account ID from its ARN.
+func accountOf(attributes map[string]interface{}) string {
+	arn, _ := attributes["arn"].(string)
+	return common.AccountFromARN(arn)
+}
 
-			attributes, ok := instanceMap["attributes"].(map[string]interface{})
+// renamedAttributes maps Terraform attribute names to the normalized keys that differ from them.
+var renamedAttributes = map[string]string{
+	"id":                     "instance_id",
+	"instance_state":         "state",
+	"vpc_security_group_ids": "security_groups",
+}
+
+// sensitiveAttributePaths converts the sensitive_attributes of a v4 state instance,
+// a list of paths made of get_attr and index steps, into normalized dotted paths
+// such as "user_data" or "tags.ApiKey".
+func sensitiveAttributePaths(raw interface{}) []string {
+	rawPaths, ok := raw.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var paths []string
+	for _, rawPath := range rawPaths {
+		steps, ok := rawPath.([]interface{})
+		if !ok || len(steps) == 0 {
+			continue
+		}
+
+		var segments []string
+		for _, rawStep := range steps {
+			step, ok := rawStep.(map[string]interface{})
 			if !ok {
-				continue
+				break
 			}
-
-			// Convert vpc_security_group_ids to []string
-			var securityGroups []string
-			if sgIds, ok := attributes["vpc_security_group_ids"].([]interface{}); ok {
-				for _, sgId := range sgIds {
-					if sgIdStr, ok := sgId.(string); ok {
-						securityGroups = append(securityGroups, sgIdStr)
-					}
+			switch step["type"] {
+			case "get_attr":
+				segments = append(segments, fmt.Sprintf("%v", step["value"]))
+			case "index":
+				// Blocks are flattened into their single item, so only map keys are kept
+				if index, ok := step["value"].(map[string]interface{}); ok && index["type"] == "string" {
+					segments = append(segments, fmt.Sprintf("%v", index["value"]))
 				}
 			}
+		}
+		if len(segments) == 0 {
+			continue
+		}
 
-			// Convert tags to map[string]string
-			var tags map[string]string
-			if tagsRaw, ok := attributes["tags"].(map[string]interface{}); ok {
-				tags = make(map[string]string)
-				for k, v := range tagsRaw {
-					if vStr, ok := v.(string); ok {
-						tags[k] = vStr
-					}
-				}
-			}
+		if renamed, ok := renamedAttributes[segments[0]]; ok {
+			segments[0] = renamed
+		}
+		paths = append(paths, strings.Join(segments, "."))
+	}
+	return paths
+}
 
-			normalized := map[string]interface{}{
-				"instance_id":       attributes["id"],
-				"instance_type":     attributes["instance_type"],
-				"ami":               attributes["ami"],
-				"key_name":          attributes["key_name"],
-				"subnet_id":         attributes["subnet_id"],
-				"availability_zone": attributes["availability_zone"],
-				"state":             attributes["instance_state"],
-				"tags":              tags,
-				"private_ip":        attributes["private_ip"],
-				"public_ip":         attributes["public_ip"],
-				"security_groups":   securityGroups,
+// normalizeAttributes maps Terraform attribute names onto the keys shared with the cloud repositories.
+func normalizeAttributes(attributes map[string]interface{}) map[string]interface{} {
+	// Convert vpc_security_group_ids to []string
+	var securityGroups []string
+	if sgIds, ok := attributes["vpc_security_group_ids"].([]interface{}); ok {
+		for _, sgId := range sgIds {
+			if sgIdStr, ok := sgId.(string); ok {
+				securityGroups = append(securityGroups, sgIdStr)
 			}
-
-			results = append(results, types.NewResource(
-				fmt.Sprintf("%v", attributes["id"]),
-				types.ResourceType(resourceType),
-				normalized,
-			))
 		}
 	}
 
-	return results, nil
+	// Convert tags to map[string]string
+	tags := stringMap(attributes["tags"])
+
+	normalized := map[string]interface{}{
+		"instance_id":       attributes["id"],
+		"instance_type":     attributes["instance_type"],
+		"ami":               attributes["ami"],
+		"key_name":          attributes["key_name"],
+		"subnet_id":         attributes["subnet_id"],
+		"availability_zone": attributes["availability_zone"],
+		"state":             attributes["instance_state"],
+		"tags":              tags,
+		"private_ip":        attributes["private_ip"],
+		"public_ip":         attributes["public_ip"],
+		"security_groups":   securityGroups,
+	}
+	for key, value := range instanceSettings(attributes) {
+		normalized[key] = value
+	}
+	for key, value := range blockDevices(attributes) {
+		normalized[key] = value
+	}
+	for key, value := range networkInterfaces(attributes) {
+		normalized[key] = value
+	}
+	for key, value := range userData(attributes) {
+		normalized[key] = value
+	}
+	// tags_all adds the provider's default_tags to tags
+	if tagsAll := stringMap(attributes["tags_all"]); tagsAll != nil {
+		normalized["tags_all"] = tagsAll
+	}
+	return normalized
+}
+
+// stringMap converts a JSON object of strings to a map[string]string, or nil when raw is not an object.
+func stringMap(raw interface{}) map[string]string {
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	strs := make(map[string]string, len(object))
+	for k, v := range object {
+		if vStr, ok := v.(string); ok {
+			strs[k] = vStr
+		}
+	}
+	return strs
 }
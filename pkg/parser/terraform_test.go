@@ -74,6 +74,25 @@ func TestParseTerraformStateFile(t *testing.T) {
 	}
 	missingAttributesJSON, _ := json.Marshal(missingAttributesState)
 
+	validInstance := types.NewResource(
+		"i-1234567890abcdef0",
+		types.ResourceType("aws_instance"),
+		map[string]interface{}{
+			"instance_id":       "i-1234567890abcdef0",
+			"instance_type":     "t2.micro",
+			"ami":               "ami-12345678",
+			"key_name":          "my-key",
+			"subnet_id":         "subnet-12345678",
+			"availability_zone": "us-west-2a",
+			"state":             "running",
+			"private_ip":        "10.0.0.1",
+			"public_ip":         "203.0.113.1",
+			"tags":              map[string]string{"Name": "test-instance", "Env": "prod"},
+			"security_groups":   []string{"sg-12345678"},
+		},
+	)
+	validInstance.Region = "us-west-2"
+
 	tests := []struct {
 		name           string
 		input          []byte
@@ -84,23 +103,7 @@ func TestParseTerraformStateFile(t *testing.T) {
 			name:  "valid state file",
 			input: validStateJSON,
 			expected: []types.Resource{
-				types.NewResource(
-					"i-1234567890abcdef0",
-					types.ResourceType("aws_instance"),
-					map[string]interface{}{
-						"instance_id":       "i-1234567890abcdef0",
-						"instance_type":     "t2.micro",
-						"ami":               "ami-12345678",
-						"key_name":          "my-key",
-						"subnet_id":         "subnet-12345678",
-						"availability_zone": "us-west-2a",
-						"state":             "running",
-						"private_ip":        "10.0.0.1",
-						"public_ip":         "203.0.113.1",
-						"tags":              map[string]string{"Name": "test-instance", "Env": "prod"},
-						"security_groups":   []string{"sg-12345678"},
-					},
-				),
+				validInstance,
 			},
 		},
 		{
@@ -146,3 +149,79 @@ func TestParseTerraformStateFile(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceHeaderAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   resourceHeader
+		indexKey interface{}
+		expected string
+	}{
+		{name: "plain", header: resourceHeader{Mode: "managed", Type: "aws_instance", Name: "web"}, expected: "aws_instance.web"},
+		{name: "count", header: resourceHeader{Type: "aws_instance", Name: "web"}, indexKey: float64(2), expected: "aws_instance.web[2]"},
+		{name: "for_each", header: resourceHeader{Type: "aws_instance", Name: "web"}, indexKey: "blue", expected: `aws_instance.web["blue"]`},
+		{name: "module", header: resourceHeader{Module: "module.app", Type: "aws_instance", Name: "web"}, expected: "module.app.aws_instance.web"},
+		{name: "data source", header: resourceHeader{Mode: "data", Type: "aws_instance", Name: "web"}, expected: "data.aws_instance.web"},
+		{name: "unnamed", header: resourceHeader{Type: "aws_instance"}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.header.address(tt.indexKey))
+		})
+	}
+}
+
+func TestRegionOf(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]interface{}
+		expected   string
+	}{
+		{name: "from ARN", attributes: map[string]interface{}{"arn": "arn:aws:ec2:eu-west-1:123456789012:instance/i-1", "availability_zone": "us-east-1a"}, expected: "eu-west-1"},
+		{name: "from availability zone", attributes: map[string]interface{}{"availability_zone": "us-east-1a"}, expected: "us-east-1"},
+		{name: "from local zone", attributes: map[string]interface{}{"availability_zone": "us-west-2-lax-1a"}, expected: "us-west-2"},
+		{name: "GovCloud", attributes: map[string]interface{}{"availability_zone": "us-gov-west-1b"}, expected: "us-gov-west-1"},
+		{name: "global ARN falls back to zone", attributes: map[string]interface{}{"arn": "arn:aws:iam::123456789012:role/x", "availability_zone": "eu-central-1c"}, expected: "eu-central-1"},
+		{name: "unknown", attributes: map[string]interface{}{}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, regionOf(tt.attributes))
+		})
+	}
+}
+
+func TestAccountOf(t *testing.T) {
+	assert.Equal(t, "123456789012", accountOf(map[string]interface{}{"arn": "arn:aws:ec2:eu-west-1:123456789012:instance/i-1"}))
+	assert.Equal(t, "", accountOf(map[string]interface{}{"arn": "not-an-arn"}))
+	assert.Equal(t, "", accountOf(map[string]interface{}{}))
+}
+
+func TestSensitiveAttributePaths(t *testing.T) {
+	var raw interface{}
+	err := json.Unmarshal([]byte(`[
+		[{"type": "get_attr", "value": "user_data"}],
+		[{"type": "get_attr", "value": "tags"}, {"type": "index", "value": {"value": "ApiKey", "type": "string"}}],
+		[{"type": "get_attr", "value": "id"}],
+		[{"type": "get_attr", "value": "metadata_options"}, {"type": "index", "value": {"value": 0, "type": "number"}}, {"type": "get_attr", "value": "http_tokens"}],
+		[]
+	]`), &raw)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"user_data", "tags.ApiKey", "instance_id", "metadata_options.http_tokens"}, sensitiveAttributePaths(raw))
+	assert.Nil(t, sensitiveAttributePaths(nil))
+}
+
+func TestNormalizeAttributesTagsAll(t *testing.T) {
+	normalized := normalizeAttributes(map[string]interface{}{
+		"tags":     map[string]interface{}{"Name": "web"},
+		"tags_all": map[string]interface{}{"Name": "web", "Owner": "platform"},
+	})
+	assert.Equal(t, map[string]string{"Name": "web"}, normalized["tags"])
+	assert.Equal(t, map[string]string{"Name": "web", "Owner": "platform"}, normalized["tags_all"])
+
+	_, ok := normalizeAttributes(map[string]interface{}{"tags": map[string]interface{}{}})["tags_all"]
+	assert.False(t, ok, "tags_all is only set when the state records it")
+}
@@ -2,9 +2,12 @@ package printer
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/common"
 )
 
 type ConsolePrinter struct{}
@@ -29,7 +32,14 @@ func (o *ConsolePrinter) PrintDrifts(resourceType types.ResourceType, resourceNa
 	fmt.Println("Kindly note that green indicates the new value in AWS and red indicates the old value in Terraform.")
 
 	for _, d := range drifts {
-		fmt.Println(yellow(fmt.Sprintf("Detected drift in %s", d.Name)))
+		header := fmt.Sprintf("Detected drift in %s", d.Name)
+		if d.Category == types.DriftOperational {
+			header += " (operational)"
+		}
+		if d.Sensitive {
+			header += " (sensitive)"
+		}
+		fmt.Println(yellow(header))
 
 		if d.OldValue != nil {
 			fmt.Println(red(fmt.Sprintf("- %v", d.OldValue)))
@@ -37,7 +47,70 @@ func (o *ConsolePrinter) PrintDrifts(resourceType types.ResourceType, resourceNa
 		if d.NewValue != nil {
 			fmt.Println(green(fmt.Sprintf("+ %v", d.NewValue)))
 		}
+		for _, line := range d.Diff {
+			switch {
+			case strings.HasPrefix(line, "- "):
+				fmt.Println(red("    " + line))
+			case strings.HasPrefix(line, "+ "):
+				fmt.Println(green("    " + line))
+			default:
+				fmt.Println("    " + line)
+			}
+		}
 
 		fmt.Println()
 	}
 }
+
+func (o *ConsolePrinter) PrintFindings(findings []types.Finding) {
+	fmt.Printf("\n==== Findings ====\n\n")
+
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	for _, f := range findings {
+		var location []string
+		if f.Account != "" {
+			location = append(location, common.AccountLabel(f.Account, f.AccountAlias))
+		}
+		if f.Region != "" {
+			location = append(location, f.Region)
+		}
+		name := f.ResourceName
+		if len(location) > 0 {
+			name = fmt.Sprintf("%s (%s)", f.ResourceName, strings.Join(location, ", "))
+		}
+		line := fmt.Sprintf("  [%s] %s %s: %s", f.Kind, f.ResourceType, name, f.Message)
+		if f.Kind == types.FindingMissing {
+			fmt.Println(red(line))
+			continue
+		}
+		fmt.Println(yellow(line))
+	}
+}
+
+func (o *ConsolePrinter) PrintRetrySummary(stats []types.RetryStat) {
+	fmt.Printf("\n==== API retries ====\n\n")
+
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	for _, stat := range stats {
+		codes := make([]string, 0, len(stat.Codes))
+		for code := range stat.Codes {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for i, code := range codes {
+			codes[i] = fmt.Sprintf("%s: %d", code, stat.Codes[code])
+		}
+
+		line := fmt.Sprintf("  %s %s: %d retries", stat.Service, stat.Region, stat.Retries)
+		if len(codes) > 0 {
+			line += fmt.Sprintf(" (%s)", strings.Join(codes, ", "))
+		}
+		if stat.Failed > 0 {
+			line += fmt.Sprintf(", %d calls failed after retrying", stat.Failed)
+		}
+		fmt.Println(yellow(line))
+	}
+}
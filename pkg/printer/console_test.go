@@ -2,35 +2,38 @@ package printer
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/fatih/color"
 	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/redact"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestConsolePrinter_PrintDrifts(t *testing.T) {
-	// Helper function to capture console output
-	captureOutput := func(f func()) string {
-		// Disable color output for consistent testing
-		color.NoColor = true
-		// Capture stdout
-		originalStdout := os.Stdout
-		r, w, _ := os.Pipe()
-		os.Stdout = w
-
-		f()
-
-		// Restore stdout and read captured output
-		w.Close()
-		os.Stdout = originalStdout
-		var buf bytes.Buffer
-		_, _ = buf.ReadFrom(r)
-		return strings.TrimSpace(buf.String())
-	}
+// captureOutput runs f and returns what it printed to stdout, without colors
+func captureOutput(f func()) string {
+	// Disable color output for consistent testing
+	color.NoColor = true
+
+	// Capture stdout
+	originalStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	f()
+
+	// Restore stdout and read captured output
+	w.Close()
+	os.Stdout = originalStdout
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(r)
+	return strings.TrimSpace(buf.String())
+}
 
+func TestConsolePrinter_PrintDrifts(t *testing.T) {
 	tests := []struct {
 		name           string
 		resourceType   types.ResourceType
@@ -129,6 +132,49 @@ func TestConsolePrinter_PrintDrifts(t *testing.T) {
 				"- my-key",
 			}, "\n"),
 		},
+		{
+			name:         "operational drift",
+			resourceType: types.ResourceType("aws_instance"),
+			resourceName: "i-123",
+			drifts: []types.Drift{
+				{Name: "state", Category: types.DriftOperational, OldValue: "running", NewValue: "stopped"},
+			},
+			expectedOutput: strings.Join([]string{
+				"==== Resource Type: aws_instance ====",
+				"",
+				"  Resource: i-123",
+				"Kindly note that green indicates the new value in AWS and red indicates the old value in Terraform.",
+				"Detected drift in state (operational)",
+				"- running",
+				"+ stopped",
+			}, "\n"),
+		},
+		{
+			name:         "drift with a diff",
+			resourceType: types.ResourceType("aws_instance"),
+			resourceName: "i-123",
+			drifts: []types.Drift{
+				{
+					Name:      "user_data",
+					OldValue:  "sha256:aaaa",
+					NewValue:  "sha256:bbbb",
+					Sensitive: true,
+					Diff:      []string{"  #!/bin/bash", "- echo old", "+ echo new"},
+				},
+			},
+			expectedOutput: strings.Join([]string{
+				"==== Resource Type: aws_instance ====",
+				"",
+				"  Resource: i-123",
+				"Kindly note that green indicates the new value in AWS and red indicates the old value in Terraform.",
+				"Detected drift in user_data (sensitive)",
+				"- sha256:aaaa",
+				"+ sha256:bbbb",
+				"      #!/bin/bash",
+				"    - echo old",
+				"    + echo new",
+			}, "\n"),
+		},
 		{
 			name:         "different resource type",
 			resourceType: types.ResourceType("aws_s3_bucket"),
@@ -162,3 +208,57 @@ func TestConsolePrinter_PrintDrifts(t *testing.T) {
 		})
 	}
 }
+
+func TestConsolePrinter_PrintFindings(t *testing.T) {
+	findings := []types.Finding{
+		{Kind: types.FindingMissing, ResourceType: types.EC2Instance, ResourceName: "i-123", Message: "tracked in a.tfstate but not found in the cloud"},
+		{Kind: types.FindingUnmanaged, ResourceType: types.EC2Instance, ResourceName: "i-456", Message: "not managed by any loaded state"},
+		{Kind: types.FindingUnmanaged, ResourceType: types.EC2Instance, ResourceName: "i-789", Region: "eu-west-1", Message: "not managed by any loaded state"},
+		{Kind: types.FindingUnmanaged, ResourceType: types.EC2Instance, ResourceName: "i-abc", Region: "eu-west-1", Account: "111111111111", AccountAlias: "prod", Message: "not managed by any loaded state"},
+	}
+
+	actual := captureOutput(func() {
+		NewConsolePrinter().PrintFindings(findings)
+	})
+
+	assert.Equal(t, strings.Join([]string{
+		"==== Findings ====",
+		"",
+		"  [missing] aws_instance i-123: tracked in a.tfstate but not found in the cloud",
+		"  [unmanaged] aws_instance i-456: not managed by any loaded state",
+		"  [unmanaged] aws_instance i-789 (eu-west-1): not managed by any loaded state",
+		"  [unmanaged] aws_instance i-abc (prod/111111111111, eu-west-1): not managed by any loaded state",
+	}, "\n"), actual)
+}
+
+func TestConsolePrinter_PrintRetrySummary(t *testing.T) {
+	actual := captureOutput(func() {
+		NewConsolePrinter().PrintRetrySummary([]types.RetryStat{
+			{Service: "ec2", Region: "eu-west-1", Retries: 12, Codes: map[string]int{"RequestLimitExceeded": 10, "InternalError": 2}},
+			{Service: "sts", Region: "us-east-1", Retries: 3, Codes: map[string]int{"Throttling": 3}, Failed: 1},
+		})
+	})
+
+	assert.Equal(t, strings.Join([]string{
+		"==== API retries ====",
+		"",
+		"  ec2 eu-west-1: 12 retries (InternalError: 2, RequestLimitExceeded: 10)",
+		"  sts us-east-1: 3 retries (Throttling: 3), 1 calls failed after retrying",
+	}, "\n"), actual)
+}
+
+func TestRedactingPrinter_PrintDrifts(t *testing.T) {
+	redactor, err := redact.NewRedactor([]string{"key_name"}, nil)
+	assert.NoError(t, err)
+
+	actual := captureOutput(func() {
+		NewRedactingPrinter(NewConsolePrinter(), redactor).PrintDrifts(types.EC2Instance, "i-123", []types.Drift{
+			{Name: "key_name", OldValue: "old-key", NewValue: "new-key"},
+		})
+	})
+
+	assert.Contains(t, actual, "Detected drift in key_name (sensitive)")
+	assert.Contains(t, actual, fmt.Sprintf("- %v", redact.Hash("old-key")))
+	assert.NotContains(t, actual, "old-key")
+	assert.NotContains(t, actual, "new-key")
+}
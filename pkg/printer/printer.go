@@ -7,6 +7,8 @@ import (
 
 type Printer interface {
 	PrintDrifts(resourceType types.ResourceType, resourceName string, drifts []types.Drift)
+	PrintFindings(findings []types.Finding)
+	PrintRetrySummary(stats []types.RetryStat)
 }
 
 func NewPrinter(output common.OutputType) Printer {
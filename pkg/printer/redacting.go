@@ -0,0 +1,30 @@
+package printer
+
+import (
+	"github.com/papidb/drift-detector/internal/types"
+	"github.com/papidb/drift-detector/pkg/redact"
+)
+
+// RedactingPrinter masks user-configured secrets in drift values, and the
+// sensitive attributes of the resources findings carry, before handing them to
+// the wrapped printer, so every output format is covered the same way.
+type RedactingPrinter struct {
+	inner    Printer
+	redactor *redact.Redactor
+}
+
+func NewRedactingPrinter(inner Printer, redactor *redact.Redactor) *RedactingPrinter {
+	return &RedactingPrinter{inner: inner, redactor: redactor}
+}
+
+func (o *RedactingPrinter) PrintDrifts(resourceType types.ResourceType, resourceName string, drifts []types.Drift) {
+	o.inner.PrintDrifts(resourceType, resourceName, o.redactor.Drifts(drifts))
+}
+
+func (o *RedactingPrinter) PrintFindings(findings []types.Finding) {
+	o.inner.PrintFindings(o.redactor.Findings(findings))
+}
+
+func (o *RedactingPrinter) PrintRetrySummary(stats []types.RetryStat) {
+	o.inner.PrintRetrySummary(stats)
+}
@@ -0,0 +1,254 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/papidb/drift-detector/internal/types"
+)
+
+// Mask replaces the parts of a value matched by a redaction pattern.
+const Mask = "[REDACTED]"
+
+// AlwaysSensitive lists attributes treated as sensitive whatever the state says,
+// because they routinely carry secrets.
+var AlwaysSensitive = map[types.ResourceType][]string{
+	types.EC2Instance: {"user_data"},
+}
+
+// Hash returns a short, stable fingerprint of a value so two sensitive values
+// can be told apart without revealing either. A nil value stays nil.
+func Hash(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v", v)))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// HashKeys returns a copy of tags with the values of the given keys hashed.
+func HashKeys(tags map[string]string, keys map[string]struct{}) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if _, ok := keys[k]; ok {
+			v = Hash(v).(string)
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// Redactor masks drift values that users consider secret on top of what the
+// state already marks as sensitive. Paths name attributes such as "user_data"
+// or "tags.DbPassword" and may use path.Match wildcards ("tags.*Token");
+// patterns are regular expressions whose matches are cut out of string values.
+type Redactor struct {
+	paths    []string
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles the given attribute paths and value patterns.
+func NewRedactor(paths, patterns []string) (*Redactor, error) {
+	r := &Redactor{paths: paths}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Drifts returns copies of drifts with configured paths hashed and pattern matches masked.
+func (r *Redactor) Drifts(drifts []types.Drift) []types.Drift {
+	if r == nil || (len(r.paths) == 0 && len(r.patterns) == 0) {
+		return drifts
+	}
+
+	redacted := make([]types.Drift, len(drifts))
+	for i, d := range drifts {
+		if r.matchPath(d.Name) {
+			d.OldValue, d.NewValue, d.Sensitive = Hash(d.OldValue), Hash(d.NewValue), true
+			d.Diff = nil
+		} else if keys := r.matchKeys(d.Name, d.OldValue, d.NewValue); len(keys) > 0 {
+			d.OldValue, d.NewValue, d.Sensitive = hashMapKeys(d.OldValue, keys), hashMapKeys(d.NewValue, keys), true
+		}
+		d.OldValue = r.maskPatterns(d.OldValue)
+		d.NewValue = r.maskPatterns(d.NewValue)
+		if d.Diff != nil {
+			d.Diff = r.maskPatterns(d.Diff).([]string)
+		}
+		redacted[i] = d
+	}
+	return redacted
+}
+
+// matchPath reports whether an attribute path, or the attribute it belongs
+// to, is configured for redaction. A tag inherited from the provider's default
+// tags (default_tags.<key>) matches the paths of tags.<key> and tags_all.<key> too.
+func (r *Redactor) matchPath(name string) bool {
+	return matchPaths(r.paths, name)
+}
+
+// matchPaths reports whether an attribute path, or the attribute it belongs
+// to, matches one of paths.
+func matchPaths(paths []string, name string) bool {
+	names := []string{name}
+	if key, ok := strings.CutPrefix(name, "default_tags."); ok {
+		names = append(names, "tags."+key, "tags_all."+key)
+	}
+	for _, p := range paths {
+		for _, n := range names {
+			if ok, _ := path.Match(p, n); ok || p == n || strings.HasPrefix(n, p+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Findings returns copies of findings whose resources have their sensitive
+// attributes hashed: those their source marks sensitive, those always
+// treated as such and the configured paths. Pattern matches are masked.
+func (r *Redactor) Findings(findings []types.Finding) []types.Finding {
+	if r == nil {
+		r = &Redactor{}
+	}
+	redacted := make([]types.Finding, len(findings))
+	for i, f := range findings {
+		resources := make([]types.Resource, len(f.Resources))
+		for j, res := range f.Resources {
+			resources[j] = r.resource(res)
+		}
+		f.Resources = resources
+		redacted[i] = f
+	}
+	return redacted
+}
+
+// resource returns a copy of res with its sensitive attributes hashed.
+func (r *Redactor) resource(res types.Resource) types.Resource {
+	data, ok := res.Data.(map[string]interface{})
+	if !ok {
+		return res
+	}
+	paths := append(append(append([]string{}, AlwaysSensitive[res.Type]...), res.Sensitive...), r.paths...)
+	redacted := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		redacted[key] = r.maskPatterns(hashPaths(paths, key, value))
+	}
+	res.Data = redacted
+	return res
+}
+
+// hashPaths hashes the value of the attribute name when it matches paths, or
+// the parts of it that do: the keys of a map and the fields of a block.
+// Devices keyed by name have their fields named without it, as in the state.
+func hashPaths(paths []string, name string, value interface{}) interface{} {
+	if matchPaths(paths, name) {
+		return Hash(value)
+	}
+	switch v := value.(type) {
+	case map[string]string:
+		keys := make(map[string]struct{})
+		for k := range v {
+			if matchPaths(paths, name+"."+k) {
+				keys[k] = struct{}{}
+			}
+		}
+		if len(keys) > 0 {
+			return HashKeys(v, keys)
+		}
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(v))
+		for k, field := range v {
+			fields[k] = hashPaths(paths, name+"."+k, field)
+		}
+		return fields
+	case map[string]map[string]interface{}:
+		devices := make(map[string]map[string]interface{}, len(v))
+		for k, device := range v {
+			devices[k] = hashPaths(paths, name, device).(map[string]interface{})
+		}
+		return devices
+	}
+	return value
+}
+
+// matchKeys returns the keys of map values whose "<name>.<key>" path is configured for redaction.
+func (r *Redactor) matchKeys(name string, values ...interface{}) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, v := range values {
+		tags, ok := v.(map[string]string)
+		if !ok {
+			continue
+		}
+		for k := range tags {
+			if r.matchPath(name + "." + k) {
+				keys[k] = struct{}{}
+			}
+		}
+	}
+	return keys
+}
+
+// maskPatterns removes pattern matches from string values, string maps and blocks.
+func (r *Redactor) maskPatterns(v interface{}) interface{} {
+	if len(r.patterns) == 0 {
+		return v
+	}
+
+	switch value := v.(type) {
+	case string:
+		return r.maskString(value)
+	case map[string]string:
+		masked := make(map[string]string, len(value))
+		for k, s := range value {
+			masked[k] = r.maskString(s)
+		}
+		return masked
+	case []string:
+		masked := make([]string, len(value))
+		for i, s := range value {
+			masked[i] = r.maskString(s)
+		}
+		return masked
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(value))
+		for k, field := range value {
+			masked[k] = r.maskPatterns(field)
+		}
+		return masked
+	}
+	return v
+}
+
+// maskString replaces every pattern match in s.
+func (r *Redactor) maskString(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// hashMapKeys hashes the given keys of a map value, leaving other values untouched.
+func hashMapKeys(v interface{}, keys map[string]struct{}) interface{} {
+	if tags, ok := v.(map[string]string); ok {
+		return HashKeys(tags, keys)
+	}
+	return v
+}
+
+// SplitPath splits an attribute path such as "tags.Name" into its attribute and key.
+func SplitPath(p string) (attribute, key string) {
+	attribute, key, _ = strings.Cut(p, ".")
+	return attribute, key
+}
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// DefaultWorkspace is the workspace of a state that lives outside terraform.tfstate.d.
+const DefaultWorkspace = "default"
+
+// File is a discovered state file and the workspace it belongs to.
+type File struct {
+	Path      string
+	Workspace string
+	// Stack is the directory of the Terragrunt stack the state belongs to, if any.
+	Stack string
+}
+
+// Discover walks root and returns every *.tfstate file beneath it. States under
+// terraform.tfstate.d/<workspace>/ are attributed to that workspace; all others
+// to the default workspace. .terraform directories are skipped because the
+// terraform.tfstate found there holds backend settings, not resources.
+func Discover(root string) ([]File, error) {
+	var files []File
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".tfstate" {
+			return nil
+		}
+
+		files = append(files, File{Path: path, Workspace: workspaceOf(path)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover state files under %s: %w", root, err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Terraform state files found under %s", root)
+	}
+	return files, nil
+}
+
+// workspaceOf returns the workspace named by a terraform.tfstate.d/<workspace>/ parent directory.
+func workspaceOf(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Base(filepath.Dir(dir)) == "terraform.tfstate.d" {
+		return filepath.Base(dir)
+	}
+	return DefaultWorkspace
+}
+
+// Name identifies the state to users: its Terragrunt stack directory, or else its path.
+func (f File) Name() string {
+	if f.Stack != "" {
+		return f.Stack
+	}
+	return f.Path
+}
+
+// Label returns a human readable label for the state, e.g. "stacks/app/terraform.tfstate [staging]".
+func (f File) Label() string {
+	if f.Workspace == "" || f.Workspace == DefaultWorkspace {
+		return f.Name()
+	}
+	return fmt.Sprintf("%s [%s]", f.Name(), f.Workspace)
+}
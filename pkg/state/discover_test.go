@@ -0,0 +1,54 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"network/terraform.tfstate",
+		"network/terraform.tfstate.backup",
+		"network/.terraform/terraform.tfstate",
+		"app/terraform.tfstate",
+		"app/terraform.tfstate.d/staging/terraform.tfstate",
+		"app/terraform.tfstate.d/prod/terraform.tfstate",
+		"app/main.tf",
+	} {
+		full := filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		assert.NoError(t, os.WriteFile(full, []byte(`{}`), 0o644))
+	}
+
+	files, err := Discover(root)
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []File{
+		{Path: filepath.Join(root, "network/terraform.tfstate"), Workspace: "default"},
+		{Path: filepath.Join(root, "app/terraform.tfstate"), Workspace: "default"},
+		{Path: filepath.Join(root, "app/terraform.tfstate.d/staging/terraform.tfstate"), Workspace: "staging"},
+		{Path: filepath.Join(root, "app/terraform.tfstate.d/prod/terraform.tfstate"), Workspace: "prod"},
+	}, files)
+
+	t.Run("empty directory", func(t *testing.T) {
+		_, err := Discover(t.TempDir())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "no Terraform state files found")
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := Discover(filepath.Join(root, "missing"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to discover state files")
+	})
+}
+
+func TestFile_Label(t *testing.T) {
+	assert.Equal(t, "app/terraform.tfstate", File{Path: "app/terraform.tfstate", Workspace: "default"}.Label())
+	assert.Equal(t, "app/terraform.tfstate", File{Path: "app/terraform.tfstate"}.Label())
+	assert.Equal(t, "app/x.tfstate [staging]", File{Path: "app/x.tfstate", Workspace: "staging"}.Label())
+}
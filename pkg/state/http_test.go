@@ -0,0 +1,132 @@
+package state
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSource_Open(t *testing.T) {
+	tests := []struct {
+		name             string
+		env              map[string]string
+		userInfo         string
+		lockStatus       int
+		lockBody         string
+		expectedAuth     string
+		expectedWarnings []logger.Fields
+		stateStatus      int
+		expectedErrMsg   string
+	}{
+		{
+			name:        "anonymous",
+			stateStatus: http.StatusOK,
+		},
+		{
+			name:         "basic auth from URL",
+			userInfo:     "alice:secret@",
+			stateStatus:  http.StatusOK,
+			expectedAuth: "Basic YWxpY2U6c2VjcmV0",
+		},
+		{
+			name:         "basic auth from environment",
+			env:          map[string]string{envHTTPUsername: "alice", envHTTPPassword: "secret"},
+			stateStatus:  http.StatusOK,
+			expectedAuth: "Basic YWxpY2U6c2VjcmV0",
+		},
+		{
+			name:         "bearer token wins",
+			env:          map[string]string{envHTTPUsername: "alice", envHTTPToken: "t0ken"},
+			stateStatus:  http.StatusOK,
+			expectedAuth: "Bearer t0ken",
+		},
+		{
+			name:        "unlocked",
+			lockStatus:  http.StatusOK,
+			lockBody:    `{}`,
+			stateStatus: http.StatusOK,
+		},
+		{
+			name:        "locked with lock info",
+			lockStatus:  http.StatusOK,
+			lockBody:    `{"ID":"abc","Operation":"OperationTypeApply","Who":"ci@runner","Created":"2025-05-02T14:58:52Z"}`,
+			stateStatus: http.StatusOK,
+			expectedWarnings: []logger.Fields{{
+				"lock_id":   "abc",
+				"operation": "OperationTypeApply",
+				"who":       "ci@runner",
+				"created":   "2025-05-02T14:58:52Z",
+			}},
+		},
+		{
+			name:        "locked status",
+			lockStatus:  http.StatusLocked,
+			stateStatus: http.StatusOK,
+			expectedWarnings: []logger.Fields{{
+				"lock_id":   "",
+				"operation": "",
+				"who":       "",
+				"created":   "",
+			}},
+		},
+		{
+			name:           "unauthorized",
+			stateStatus:    http.StatusUnauthorized,
+			expectedErrMsg: "unexpected status 401 Unauthorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var gotAuth string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/state/app", func(w http.ResponseWriter, r *http.Request) {
+				gotAuth = r.Header.Get("Authorization")
+				assert.Empty(t, r.URL.Query().Get("lock_address"))
+				w.WriteHeader(tt.stateStatus)
+				_, _ = io.WriteString(w, `{"resources": []}`)
+			})
+			mux.HandleFunc("/state/app/lock", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.lockStatus)
+				_, _ = io.WriteString(w, tt.lockBody)
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			location := "http://" + tt.userInfo + srv.Listener.Addr().String() + "/state/app"
+			lockAddress := srv.URL + "/state/app/lock"
+			if tt.lockStatus != 0 {
+				location += "?lock_address=" + lockAddress
+			}
+			for i := range tt.expectedWarnings {
+				tt.expectedWarnings[i]["lock_address"] = lockAddress
+			}
+
+			log := &mockLogger{}
+			source := NewHTTPSource(srv.Client(), log)
+
+			rc, err := source.Open(context.Background(), location)
+
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(rc)
+			assert.Equal(t, `{"resources": []}`, string(data))
+			assert.Equal(t, tt.expectedAuth, gotAuth)
+			assert.Equal(t, tt.expectedWarnings, log.warnings)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/papidb/drift-detector/pkg/cloud/aws/awssession"
+	"github.com/papidb/drift-detector/pkg/logger"
+)
+
+// defaultWorkspaceKeyPrefix matches the Terraform S3 backend default.
+const defaultWorkspaceKeyPrefix = "env:"
+
+// S3Location identifies a state object stored by the Terraform S3 backend.
+// It is parsed from s3://bucket/key URLs whose query parameters mirror the
+// backend's configuration keys.
+type S3Location struct {
+	Bucket           string
+	Key              string
+	VersionID        string
+	Region           string
+	Endpoint         string
+	LockTable        string
+	DynamoDBEndpoint string
+}
+
+// ParseS3Location parses a location of the form
+//
+//	s3://bucket/path/to/terraform.tfstate?region=us-east-1&workspace=staging
+//
+// Supported query parameters are region, version_id, workspace,
+// workspace_key_prefix, dynamodb_table, endpoint and dynamodb_endpoint.
+// Non-default workspaces resolve to <workspace_key_prefix>/<workspace>/<key>.
+func ParseS3Location(location string) (S3Location, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return S3Location{}, fmt.Errorf("invalid S3 state location %q: %w", location, err)
+	}
+	if u.Scheme != "s3" {
+		return S3Location{}, fmt.Errorf("invalid S3 state location %q: scheme must be s3", location)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return S3Location{}, fmt.Errorf("invalid S3 state location %q: expected s3://bucket/key", location)
+	}
+
+	query := u.Query()
+	if workspace := query.Get("workspace"); workspace != "" && workspace != "default" {
+		prefix := defaultWorkspaceKeyPrefix
+		if query.Has("workspace_key_prefix") {
+			prefix = query.Get("workspace_key_prefix")
+		}
+		key = strings.TrimPrefix(fmt.Sprintf("%s/%s/%s", prefix, workspace, key), "/")
+	}
+
+	loc := S3Location{
+		Bucket:           u.Host,
+		Key:              key,
+		VersionID:        query.Get("version_id"),
+		Region:           query.Get("region"),
+		Endpoint:         query.Get("endpoint"),
+		LockTable:        query.Get("dynamodb_table"),
+		DynamoDBEndpoint: query.Get("dynamodb_endpoint"),
+	}
+	if loc.DynamoDBEndpoint == "" {
+		loc.DynamoDBEndpoint = loc.Endpoint
+	}
+	return loc, nil
+}
+
+// String returns the s3://bucket/key form of the location.
+func (l S3Location) String() string {
+	return fmt.Sprintf("s3://%s/%s", l.Bucket, l.Key)
+}
+
+// lockInfo is the JSON document Terraform stores in the lock table's Info attribute.
+type lockInfo struct {
+	ID        string
+	Operation string
+	Who       string
+	Created   string
+}
+
+type s3Source struct {
+	newS3       func(cfg *aws.Config) (s3iface.S3API, error)
+	newDynamoDB func(cfg *aws.Config) (dynamodbiface.DynamoDBAPI, error)
+	log         logger.Logger
+}
+
+// NewS3Source creates a Source for s3:// locations using the session from
+// sessions, which is only created once an s3:// state is opened.
+func NewS3Source(sessions awssession.Provider, log logger.Logger) Source {
+	return &s3Source{
+		newS3: func(cfg *aws.Config) (s3iface.S3API, error) {
+			sess, err := sessions.Session()
+			if err != nil {
+				return nil, err
+			}
+			return s3.New(sess, cfg), nil
+		},
+		newDynamoDB: func(cfg *aws.Config) (dynamodbiface.DynamoDBAPI, error) {
+			sess, err := sessions.Session()
+			if err != nil {
+				return nil, err
+			}
+			return dynamodb.New(sess, cfg), nil
+		},
+		log: log,
+	}
+}
+
+func (s *s3Source) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	loc, err := ParseS3Location(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if loc.LockTable != "" {
+		s.warnIfLocked(ctx, loc)
+	}
+
+	cfg := aws.NewConfig()
+	if loc.Region != "" {
+		cfg.WithRegion(loc.Region)
+	}
+	if loc.Endpoint != "" {
+		// S3-compatible stand-ins rarely support virtual-hosted bucket names
+		cfg.WithEndpoint(loc.Endpoint).WithS3ForcePathStyle(true)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(loc.Bucket),
+		Key:    aws.String(loc.Key),
+	}
+	if loc.VersionID != "" {
+		input.VersionId = aws.String(loc.VersionID)
+	}
+
+	client, err := s.newS3(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch state from %s: %w", loc, err)
+	}
+	output, err := client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch state from %s: %w", loc, err)
+	}
+	return output.Body, nil
+}
+
+// warnIfLocked logs a warning when the DynamoDB lock table holds a lock for the
+// state, since drift observed while an apply is running is mostly noise.
+func (s *s3Source) warnIfLocked(ctx context.Context, loc S3Location) {
+	cfg := aws.NewConfig()
+	if loc.Region != "" {
+		cfg.WithRegion(loc.Region)
+	}
+	if loc.DynamoDBEndpoint != "" {
+		cfg.WithEndpoint(loc.DynamoDBEndpoint)
+	}
+
+	client, err := s.newDynamoDB(cfg)
+	if err != nil {
+		s.log.Warn(logger.Fields{"state": loc.String(), "table": loc.LockTable}, fmt.Sprintf("Unable to check state lock: %v", err))
+		return
+	}
+
+	lockID := fmt.Sprintf("%s/%s", loc.Bucket, loc.Key)
+	output, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(loc.LockTable),
+		Key:            map[string]*dynamodb.AttributeValue{"LockID": {S: aws.String(lockID)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		s.log.Warn(logger.Fields{"state": loc.String(), "table": loc.LockTable}, fmt.Sprintf("Unable to check state lock: %v", err))
+		return
+	}
+
+	info, ok := output.Item["Info"]
+	if !ok || info.S == nil {
+		return
+	}
+
+	var lock lockInfo
+	_ = json.Unmarshal([]byte(*info.S), &lock)
+	s.log.Warn(logger.Fields{
+		"state":     loc.String(),
+		"lock_id":   lock.ID,
+		"operation": lock.Operation,
+		"who":       lock.Who,
+		"created":   lock.Created,
+	}, "Terraform state is locked, an apply may be in progress; drift reported now may be noise")
+}
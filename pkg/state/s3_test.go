@@ -0,0 +1,218 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockS3Client is a mock implementation of s3iface.S3API for testing
+type mockS3Client struct {
+	s3iface.S3API
+	input *s3.GetObjectInput
+	body  string
+	err   error
+}
+
+func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	m.input = input
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(m.body))}, nil
+}
+
+// mockDynamoDBClient is a mock implementation of dynamodbiface.DynamoDBAPI for testing
+type mockDynamoDBClient struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.GetItemInput
+	item  map[string]*dynamodb.AttributeValue
+	err   error
+}
+
+func (m *mockDynamoDBClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	m.input = input
+	return &dynamodb.GetItemOutput{Item: m.item}, m.err
+}
+
+func TestParseS3Location(t *testing.T) {
+	tests := []struct {
+		name           string
+		location       string
+		expected       S3Location
+		expectedErrMsg string
+	}{
+		{
+			name:     "bucket and key",
+			location: "s3://states/prod/terraform.tfstate",
+			expected: S3Location{Bucket: "states", Key: "prod/terraform.tfstate"},
+		},
+		{
+			name:     "workspace with default prefix",
+			location: "s3://states/app.tfstate?workspace=staging",
+			expected: S3Location{Bucket: "states", Key: "env:/staging/app.tfstate"},
+		},
+		{
+			name:     "workspace with custom prefix",
+			location: "s3://states/app.tfstate?workspace=staging&workspace_key_prefix=workspaces",
+			expected: S3Location{Bucket: "states", Key: "workspaces/staging/app.tfstate"},
+		},
+		{
+			name:     "default workspace keeps the key",
+			location: "s3://states/app.tfstate?workspace=default",
+			expected: S3Location{Bucket: "states", Key: "app.tfstate"},
+		},
+		{
+			name:     "all options",
+			location: "s3://states/app.tfstate?region=eu-west-1&version_id=v1&dynamodb_table=locks&endpoint=http://localhost:4566",
+			expected: S3Location{
+				Bucket:           "states",
+				Key:              "app.tfstate",
+				VersionID:        "v1",
+				Region:           "eu-west-1",
+				Endpoint:         "http://localhost:4566",
+				LockTable:        "locks",
+				DynamoDBEndpoint: "http://localhost:4566",
+			},
+		},
+		{
+			name:           "missing key",
+			location:       "s3://states",
+			expectedErrMsg: "expected s3://bucket/key",
+		},
+		{
+			name:           "wrong scheme",
+			location:       "https://states/app.tfstate",
+			expectedErrMsg: "scheme must be s3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseS3Location(tt.location)
+
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestS3Source_Open(t *testing.T) {
+	lockItem := map[string]*dynamodb.AttributeValue{
+		"LockID": {S: aws.String("states/app.tfstate")},
+		"Info":   {S: aws.String(`{"ID":"abc","Operation":"OperationTypeApply","Who":"ci@runner","Created":"2025-05-02T14:58:52Z"}`)},
+	}
+
+	tests := []struct {
+		name             string
+		location         string
+		s3Err            error
+		lockItem         map[string]*dynamodb.AttributeValue
+		lockErr          error
+		expectedKey      string
+		expectedVersion  *string
+		expectedLockID   string
+		expectedWarnings []logger.Fields
+		expectedErrMsg   string
+	}{
+		{
+			name:        "reads the object",
+			location:    "s3://states/app.tfstate",
+			expectedKey: "app.tfstate",
+		},
+		{
+			name:            "pins the version",
+			location:        "s3://states/app.tfstate?version_id=v42",
+			expectedKey:     "app.tfstate",
+			expectedVersion: aws.String("v42"),
+		},
+		{
+			name:           "unlocked state",
+			location:       "s3://states/app.tfstate?dynamodb_table=locks",
+			expectedKey:    "app.tfstate",
+			expectedLockID: "states/app.tfstate",
+		},
+		{
+			name:           "warns when locked",
+			location:       "s3://states/app.tfstate?dynamodb_table=locks",
+			lockItem:       lockItem,
+			expectedKey:    "app.tfstate",
+			expectedLockID: "states/app.tfstate",
+			expectedWarnings: []logger.Fields{{
+				"state":     "s3://states/app.tfstate",
+				"lock_id":   "abc",
+				"operation": "OperationTypeApply",
+				"who":       "ci@runner",
+				"created":   "2025-05-02T14:58:52Z",
+			}},
+		},
+		{
+			name:           "lock check failure is only a warning",
+			location:       "s3://states/app.tfstate?workspace=dev&dynamodb_table=locks",
+			lockErr:        errors.New("access denied"),
+			expectedKey:    "env:/dev/app.tfstate",
+			expectedLockID: "states/env:/dev/app.tfstate",
+			expectedWarnings: []logger.Fields{{
+				"state": "s3://states/env:/dev/app.tfstate",
+				"table": "locks",
+			}},
+		},
+		{
+			name:           "S3 error",
+			location:       "s3://states/app.tfstate",
+			s3Err:          errors.New("NoSuchKey"),
+			expectedErrMsg: "failed to fetch state from s3://states/app.tfstate: NoSuchKey",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3Client := &mockS3Client{body: `{"resources": []}`, err: tt.s3Err}
+			dynamoClient := &mockDynamoDBClient{item: tt.lockItem, err: tt.lockErr}
+			log := &mockLogger{}
+			source := &s3Source{
+				newS3:       func(*aws.Config) (s3iface.S3API, error) { return s3Client, nil },
+				newDynamoDB: func(*aws.Config) (dynamodbiface.DynamoDBAPI, error) { return dynamoClient, nil },
+				log:         log,
+			}
+
+			rc, err := source.Open(context.Background(), tt.location)
+
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(rc)
+			assert.Equal(t, `{"resources": []}`, string(data))
+			assert.Equal(t, "states", aws.StringValue(s3Client.input.Bucket))
+			assert.Equal(t, tt.expectedKey, aws.StringValue(s3Client.input.Key))
+			assert.Equal(t, tt.expectedVersion, s3Client.input.VersionId)
+			if tt.expectedLockID != "" {
+				assert.Equal(t, tt.expectedLockID, aws.StringValue(dynamoClient.input.Key["LockID"].S))
+			} else {
+				assert.Nil(t, dynamoClient.input)
+			}
+			assert.Equal(t, tt.expectedWarnings, log.warnings)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/papidb/drift-detector/pkg/file"
+)
+
+// Source opens a Terraform state for streaming reads.
+type Source interface {
+	Open(ctx context.Context, location string) (io.ReadCloser, error)
+}
+
+// multiSource reads plain paths from the local filesystem and dispatches
+// URLs to the backend registered for their scheme.
+type multiSource struct {
+	reader   file.FileReader
+	backends map[string]Source
+}
+
+// NewSource creates a Source that reads local state files through reader and
+// remote state through backends, keyed by URL scheme (e.g. "s3").
+func NewSource(reader file.FileReader, backends map[string]Source) Source {
+	return &multiSource{reader: reader, backends: backends}
+}
+
+func (s *multiSource) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	if !strings.Contains(location, "://") {
+		return s.reader.Open(location)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state location %q: %w", location, err)
+	}
+
+	backend, ok := s.backends[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported state backend %q", u.Scheme)
+	}
+	return backend.Open(ctx, location)
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockFileReader is a mock implementation of file.FileReader
+type mockFileReader struct {
+	data map[string][]byte
+}
+
+func (m *mockFileReader) ReadFile(path string) ([]byte, error) {
+	if data, ok := m.data[path]; ok {
+		return data, nil
+	}
+	return nil, errors.New("file not found")
+}
+
+func (m *mockFileReader) Open(path string) (io.ReadCloser, error) {
+	data, err := m.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// mockSource is a mock implementation of Source that records the locations it opens
+type mockSource struct {
+	opened []string
+}
+
+func (m *mockSource) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	m.opened = append(m.opened, location)
+	return io.NopCloser(strings.NewReader("remote")), nil
+}
+
+// mockLogger is a mock implementation of logger.Logger that keeps warnings
+type mockLogger struct {
+	warnings []logger.Fields
+}
+
+func (m *mockLogger) Debug(args ...any) {}
+func (m *mockLogger) Info(args ...any)  {}
+func (m *mockLogger) Error(args ...any) {}
+func (m *mockLogger) Warn(args ...any) {
+	fields, _ := args[0].(logger.Fields)
+	m.warnings = append(m.warnings, fields)
+}
+
+func TestMultiSource_Open(t *testing.T) {
+	reader := &mockFileReader{data: map[string][]byte{"terraform.tfstate": []byte("local")}}
+
+	tests := []struct {
+		name           string
+		location       string
+		expected       string
+		expectedRemote []string
+		expectedErrMsg string
+	}{
+		{
+			name:     "local path",
+			location: "terraform.tfstate",
+			expected: "local",
+		},
+		{
+			name:           "registered scheme",
+			location:       "s3://bucket/terraform.tfstate",
+			expected:       "remote",
+			expectedRemote: []string{"s3://bucket/terraform.tfstate"},
+		},
+		{
+			name:           "missing local file",
+			location:       "missing.tfstate",
+			expectedErrMsg: "file not found",
+		},
+		{
+			name:           "unsupported scheme",
+			location:       "gs://bucket/terraform.tfstate",
+			expectedErrMsg: `unsupported state backend "gs"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := &mockSource{}
+			source := NewSource(reader, map[string]Source{"s3": remote})
+
+			rc, err := source.Open(context.Background(), tt.location)
+
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(rc)
+			assert.Equal(t, tt.expected, string(data))
+			assert.Equal(t, tt.expectedRemote, remote.opened)
+		})
+	}
+}
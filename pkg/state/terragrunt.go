@@ -0,0 +1,496 @@
+package state
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// terragruntConfig is the file name Terragrunt looks for in every stack directory.
+const terragruntConfig = "terragrunt.hcl"
+
+// DiscoverTerragrunt walks a Terragrunt live repository and returns the state
+// location of every stack, resolved from the remote_state block of its
+// terragrunt.hcl or of the parent configuration it includes. Each File is
+// labelled with the stack's directory relative to root. A configuration that
+// other stacks include is not a stack itself. Stacks that cannot be resolved,
+// e.g. because they use a backend without a matching Source or an expression
+// that needs Terragrunt to evaluate, are skipped with a warning.
+func DiscoverTerragrunt(root string, log logger.Logger) ([]File, error) {
+	// Terragrunt resolves includes to absolute paths; do the same so they compare equal
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover Terragrunt stacks under %s: %w", root, err)
+	}
+
+	configs := make(map[string]*hclsyntax.Body)
+	var dirs []string
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case ".terragrunt-cache", ".terraform", ".git":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != terragruntConfig {
+			return nil
+		}
+
+		dirs = append(dirs, filepath.Dir(path))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover Terragrunt stacks under %s: %w", root, err)
+	}
+
+	parents := make(map[string]struct{})
+	resolved := make(map[string]File)
+	for _, dir := range dirs {
+		stack, _ := filepath.Rel(root, dir)
+		file, parentPaths, err := resolveTerragruntStack(dir, configs)
+		for _, parent := range parentPaths {
+			parents[parent] = struct{}{}
+		}
+		if err != nil {
+			log.Warn(logger.Fields{"stack": stack}, fmt.Sprintf("Skipping Terragrunt stack: %v", err))
+			continue
+		}
+		if file != nil {
+			file.Stack = filepath.ToSlash(stack)
+			resolved[dir] = *file
+		}
+	}
+
+	var files []File
+	for _, dir := range dirs {
+		file, ok := resolved[dir]
+		if !ok {
+			continue
+		}
+		if _, isParent := parents[filepath.Join(dir, terragruntConfig)]; isParent {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Stack < files[j].Stack })
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Terragrunt stacks with a remote_state found under %s", root)
+	}
+	return files, nil
+}
+
+// resolveTerragruntStack resolves the state of the stack in dir. It returns
+// the paths of the parent configurations it includes, and a nil File when
+// neither the stack nor its parents configure remote_state. As in Terragrunt,
+// the stack's own remote_state overrides its parents', and a later include
+// overrides an earlier one.
+func resolveTerragruntStack(dir string, configs map[string]*hclsyntax.Body) (*File, []string, error) {
+	child, err := loadTerragruntFile(filepath.Join(dir, terragruntConfig), configs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := &terragruntContext{dir: dir, includes: make(map[string]string)}
+	childEval := ctx.evalContext(child, dir)
+
+	var parentPaths []string
+	var parents []*hclsyntax.Body
+	for _, include := range blocks(child, "include") {
+		path, err := evalString(attributeExpr(include.Body, "path"), childEval)
+		if err != nil {
+			return nil, parentPaths, fmt.Errorf("failed to resolve include path: %w", err)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		parentPaths = append(parentPaths, path)
+
+		label := ""
+		if len(include.Labels) > 0 {
+			label = include.Labels[0]
+		}
+		ctx.includes[label] = filepath.Dir(path)
+	}
+	for _, path := range parentPaths {
+		parent, err := loadTerragruntFile(path, configs)
+		if err != nil {
+			return nil, parentPaths, err
+		}
+		parents = append(parents, parent)
+	}
+
+	remoteState, body, includeDir := firstBlock(child, "remote_state"), child, dir
+	if len(parentPaths) > 0 {
+		includeDir = filepath.Dir(parentPaths[0])
+	}
+	for i := len(parents) - 1; remoteState == nil && i >= 0; i-- {
+		remoteState, body, includeDir = firstBlock(parents[i], "remote_state"), parents[i], filepath.Dir(parentPaths[i])
+	}
+	if remoteState == nil {
+		return nil, parentPaths, nil
+	}
+
+	location, err := ctx.backendLocation(remoteState.Body, ctx.evalContext(body, includeDir))
+	if err != nil {
+		return nil, parentPaths, err
+	}
+	return &File{Path: location, Workspace: DefaultWorkspace}, parentPaths, nil
+}
+
+// loadTerragruntFile parses a configuration once, however many stacks include it.
+func loadTerragruntFile(path string, configs map[string]*hclsyntax.Body) (*hclsyntax.Body, error) {
+	if body, ok := configs[path]; ok {
+		return body, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	file, diags := hclsyntax.ParseConfig(data, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, diags)
+	}
+
+	body := file.Body.(*hclsyntax.Body)
+	configs[path] = body
+	return body, nil
+}
+
+// blocks returns the blocks of the given type in body.
+func blocks(body *hclsyntax.Body, blockType string) []*hclsyntax.Block {
+	var found []*hclsyntax.Block
+	for _, block := range body.Blocks {
+		if block.Type == blockType {
+			found = append(found, block)
+		}
+	}
+	return found
+}
+
+// firstBlock returns the first block of the given type in body, or nil.
+func firstBlock(body *hclsyntax.Body, blockType string) *hclsyntax.Block {
+	if found := blocks(body, blockType); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// attributeExpr returns the expression of an attribute of body, or nil.
+func attributeExpr(body *hclsyntax.Body, name string) hcl.Expression {
+	if attribute, ok := body.Attributes[name]; ok {
+		return attribute.Expr
+	}
+	return nil
+}
+
+// terragruntContext evaluates expressions on behalf of the stack in dir.
+// includes maps the labels of the stack's include blocks to the directories
+// of the configurations they include; an unlabelled include has label "".
+type terragruntContext struct {
+	dir      string
+	includes map[string]string
+}
+
+// evalContext returns what expressions in body can use: its locals and the
+// Terragrunt functions state locations are commonly built from, for body
+// included from the configuration in includeDir. Locals that cannot be
+// evaluated, e.g. because they need Terragrunt itself, are left undefined, so
+// only the expressions using them fail.
+func (c *terragruntContext) evalContext(body *hclsyntax.Body, includeDir string) *hcl.EvalContext {
+	pending := make(map[string]hcl.Expression)
+	for _, block := range blocks(body, "locals") {
+		for name, attribute := range block.Body.Attributes {
+			pending[name] = attribute.Expr
+		}
+	}
+
+	locals := make(map[string]cty.Value)
+	ctx := &hcl.EvalContext{Functions: c.functions(includeDir)}
+	// Locals may refer to each other in any order; evaluate them until no more can be
+	for progress := true; progress; {
+		progress = false
+		ctx.Variables = map[string]cty.Value{"local": cty.ObjectVal(locals)}
+		for name, expr := range pending {
+			value, diags := expr.Value(ctx)
+			if diags.HasErrors() {
+				continue
+			}
+			locals[name] = value
+			delete(pending, name)
+			progress = true
+		}
+	}
+	ctx.Variables = map[string]cty.Value{"local": cty.ObjectVal(locals)}
+	return ctx
+}
+
+// functions returns the Terragrunt built-in functions, for a configuration
+// included from includeDir. Those taking an include name resolve it among the
+// stack's include blocks.
+func (c *terragruntContext) functions(includeDir string) map[string]function.Function {
+	includeDirOf := func(args []string) (string, error) {
+		if len(args) == 0 {
+			return includeDir, nil
+		}
+		dir, ok := c.includes[args[0]]
+		if !ok {
+			return "", fmt.Errorf("no include block named %q", args[0])
+		}
+		return dir, nil
+	}
+
+	return map[string]function.Function{
+		"path_relative_to_include": stringFunction(nil, "name", func(args []string) (string, error) {
+			from, err := includeDirOf(args)
+			if err != nil {
+				return "", err
+			}
+			rel, err := filepath.Rel(from, c.dir)
+			return filepath.ToSlash(rel), err
+		}),
+		"path_relative_from_include": stringFunction(nil, "name", func(args []string) (string, error) {
+			to, err := includeDirOf(args)
+			if err != nil {
+				return "", err
+			}
+			rel, err := filepath.Rel(c.dir, to)
+			return filepath.ToSlash(rel), err
+		}),
+		"get_terragrunt_dir": stringFunction(nil, "", func([]string) (string, error) {
+			return c.dir, nil
+		}),
+		"get_parent_terragrunt_dir": stringFunction(nil, "name", includeDirOf),
+		"get_env": stringFunction([]string{"name"}, "default", func(args []string) (string, error) {
+			if value, ok := os.LookupEnv(args[0]); ok {
+				return value, nil
+			}
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return "", fmt.Errorf("environment variable %s is not set", args[0])
+		}),
+		"find_in_parent_folders": stringFunction(nil, "name", func(args []string) (string, error) {
+			target := terragruntConfig
+			if len(args) > 0 {
+				target = args[0]
+			}
+			for dir := filepath.Dir(c.dir); ; dir = filepath.Dir(dir) {
+				candidate := filepath.Join(dir, target)
+				if _, err := os.Stat(candidate); err == nil {
+					return candidate, nil
+				}
+				if dir == filepath.Dir(dir) {
+					break
+				}
+			}
+			// Like Terragrunt, a second argument is the fallback when nothing is found
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return "", fmt.Errorf("%s not found in parent folders of %s", target, c.dir)
+		}),
+	}
+}
+
+// stringFunction builds a function of the named string parameters, followed
+// by any number of optional ones when optional names them, returning a string.
+func stringFunction(params []string, optional string, impl func(args []string) (string, error)) function.Function {
+	spec := &function.Spec{Type: function.StaticReturnType(cty.String)}
+	for _, name := range params {
+		spec.Params = append(spec.Params, function.Parameter{Name: name, Type: cty.String})
+	}
+	if optional != "" {
+		spec.VarParam = &function.Parameter{Name: optional, Type: cty.String}
+	}
+	spec.Impl = func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			strs[i] = arg.AsString()
+		}
+		result, err := impl(strs)
+		if err != nil {
+			return cty.NilVal, err
+		}
+		return cty.StringVal(result), nil
+	}
+	return function.New(spec)
+}
+
+// evalString evaluates an expression to a string.
+func evalString(expr hcl.Expression, ctx *hcl.EvalContext) (string, error) {
+	if expr == nil {
+		return "", fmt.Errorf("missing value")
+	}
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	value, err := convert.Convert(value, cty.String)
+	if err != nil || value.IsNull() || !value.IsKnown() {
+		return "", fmt.Errorf("%s: expected a string", expr.Range())
+	}
+	return value.AsString(), nil
+}
+
+// objectItems returns the expressions of an object's attributes by name, so
+// each is only evaluated when used. An object built some other way, e.g. taken
+// from a local, is evaluated as a whole.
+func objectItems(expr hcl.Expression, ctx *hcl.EvalContext) (map[string]hcl.Expression, error) {
+	items := make(map[string]hcl.Expression)
+	if expr == nil {
+		return items, nil
+	}
+	if object, ok := expr.(*hclsyntax.ObjectConsExpr); ok {
+		for _, item := range object.Items {
+			key, err := evalString(item.KeyExpr, ctx)
+			if err != nil {
+				return nil, err
+			}
+			items[key] = item.ValueExpr
+		}
+		return items, nil
+	}
+
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	if value.IsNull() || !value.IsKnown() || !(value.Type().IsObjectType() || value.Type().IsMapType()) {
+		return nil, fmt.Errorf("%s: expected an object", expr.Range())
+	}
+	for key, v := range value.AsValueMap() {
+		items[key] = hcl.StaticExpr(v, expr.Range())
+	}
+	return items, nil
+}
+
+// backendLocation turns a remote_state block into a location a Source can open.
+func (c *terragruntContext) backendLocation(remoteState *hclsyntax.Body, ctx *hcl.EvalContext) (string, error) {
+	backend, err := evalString(attributeExpr(remoteState, "backend"), ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve remote_state backend: %w", err)
+	}
+	config, err := objectItems(attributeExpr(remoteState, "config"), ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve remote_state config: %w", err)
+	}
+
+	get := func(key string) (string, error) {
+		expr, ok := config[key]
+		if !ok {
+			return "", nil
+		}
+		s, err := evalString(expr, ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve remote_state config.%s: %w", key, err)
+		}
+		return s, nil
+	}
+
+	switch backend {
+	case "s3":
+		bucket, err := get("bucket")
+		if err != nil {
+			return "", err
+		}
+		key, err := get("key")
+		if err != nil {
+			return "", err
+		}
+		if bucket == "" || key == "" {
+			return "", fmt.Errorf("s3 remote_state needs bucket and key")
+		}
+		query := url.Values{}
+		for _, name := range []string{"region", "dynamodb_table", "endpoint", "workspace_key_prefix"} {
+			value, err := get(name)
+			if err != nil {
+				return "", err
+			}
+			if value != "" {
+				query.Set(name, value)
+			}
+		}
+		location := fmt.Sprintf("s3://%s/%s", bucket, strings.TrimPrefix(key, "/"))
+		if len(query) > 0 {
+			location += "?" + query.Encode()
+		}
+		return location, nil
+
+	case "http":
+		address, err := get("address")
+		if err != nil {
+			return "", err
+		}
+		lockAddress, err := get("lock_address")
+		if err != nil {
+			return "", err
+		}
+		if lockAddress == "" {
+			return address, nil
+		}
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", fmt.Errorf("invalid http remote_state address: %w", err)
+		}
+		query := u.Query()
+		query.Set("lock_address", lockAddress)
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+
+	case "remote":
+		hostname, err := get("hostname")
+		if err != nil {
+			return "", err
+		}
+		if hostname == "" {
+			hostname = "app.terraform.io"
+		}
+		organization, err := get("organization")
+		if err != nil {
+			return "", err
+		}
+		workspaces, err := objectItems(config["workspaces"], ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve remote_state config.workspaces: %w", err)
+		}
+		name, err := evalString(workspaces["name"], ctx)
+		if err != nil || name == "" {
+			return "", fmt.Errorf("remote remote_state needs workspaces.name")
+		}
+		return fmt.Sprintf("tfe://%s/%s/%s", hostname, organization, name), nil
+
+	case "local":
+		path, err := get("path")
+		if err != nil {
+			return "", err
+		}
+		if path == "" {
+			path = "terraform.tfstate"
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.dir, path)
+		}
+		return path, nil
+
+	default:
+		return "", fmt.Errorf("unsupported remote_state backend %q", backend)
+	}
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+const rootTerragrunt = `
+# Shared by every stack
+locals {
+  account = "prod"
+}
+
+remote_state {
+  backend = "s3"
+  generate = {
+    path      = "backend.tf"
+    if_exists = "overwrite_terragrunt"
+  }
+  config = {
+    bucket         = "acme-${get_env("TG_STATE_ENV", "prod")}-state"
+    key            = "${path_relative_to_include()}/terraform.tfstate"
+    region         = "us-east-1"
+    encrypt        = true
+    dynamodb_table = "terraform-locks"
+  }
+}
+`
+
+const childTerragrunt = `
+include "root" {
+  path = find_in_parent_folders()
+}
+
+terraform {
+  source = "git::https://example.com/modules.git//vpc?ref=v1.2.0"
+}
+
+inputs = {
+  cidr = "10.0.0.0/16"
+  azs  = ["us-east-1a", "us-east-1b"]
+}
+`
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		assert.NoError(t, os.WriteFile(full, []byte(content), 0o644))
+	}
+}
+
+func TestDiscoverTerragrunt(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"terragrunt.hcl":                                   rootTerragrunt,
+		"us-east-1/vpc/terragrunt.hcl":                     childTerragrunt,
+		"us-east-1/app/terragrunt.hcl":                     childTerragrunt,
+		"us-east-1/app/.terragrunt-cache/x/terragrunt.hcl": childTerragrunt,
+		"legacy/terragrunt.hcl": `
+remote_state {
+  backend = "local"
+  config = {
+    path = "state/terraform.tfstate"
+  }
+}`,
+		"tfc/terragrunt.hcl": `
+remote_state {
+  backend = "remote"
+  config = {
+    organization = "acme"
+    workspaces = { name = "billing" }
+  }
+}`,
+		"gcs/terragrunt.hcl": `
+remote_state {
+  backend = "gcs"
+  config = { bucket = "b", prefix = "p" }
+}`,
+		"uses-locals/terragrunt.hcl": `
+locals {
+  bucket = "acme-${local.env}-state"
+  env    = "dev"
+}
+
+remote_state {
+  backend = "s3"
+  config = {
+    bucket = local.bucket
+    key    = "${get_env("TG_UNSET_PREFIX", "a,b")}/terraform.tfstate"
+  }
+}
+
+inputs = {
+  user_data = <<EOT
+#!/bin/bash
+echo "${local.env}"
+EOT
+}`,
+		"nested/terragrunt.hcl": `
+locals {
+  env = "qa"
+}
+
+remote_state {
+  backend = "s3"
+  config = {
+    bucket = "${get_env("TG_UNSET_BUCKET", "${local.env}-state")}"
+    key    = "nested.tfstate"
+  }
+}`,
+		"multi/env.hcl": `
+remote_state {
+  backend = "s3"
+  config = {
+    bucket = "env-state"
+    key    = "${path_relative_to_include("root")}/${path_relative_to_include()}.tfstate"
+  }
+}`,
+		"multi/stack/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders()
+}
+
+include "env" {
+  path = find_in_parent_folders("env.hcl")
+}`,
+		"unresolvable/terragrunt.hcl": `
+locals {
+  common = read_terragrunt_config(find_in_parent_folders("common.hcl"))
+}
+
+remote_state {
+  backend = "s3"
+  config = {
+    bucket = local.common.locals.bucket
+    key    = "x"
+  }
+}`,
+		"broken/terragrunt.hcl": "remote_state {\n  backend = \"s3\"\n",
+	})
+	log := &mockLogger{}
+
+	files, err := DiscoverTerragrunt(root, log)
+
+	assert.NoError(t, err)
+	absRoot, _ := filepath.Abs(root)
+	assert.Equal(t, []File{
+		{Path: filepath.Join(absRoot, "legacy/state/terraform.tfstate"), Workspace: DefaultWorkspace, Stack: "legacy"},
+		{Path: "s3://env-state/multi/stack/stack.tfstate", Workspace: DefaultWorkspace, Stack: "multi/stack"},
+		{Path: "s3://qa-state/nested.tfstate", Workspace: DefaultWorkspace, Stack: "nested"},
+		{Path: "tfe://app.terraform.io/acme/billing", Workspace: DefaultWorkspace, Stack: "tfc"},
+		{Path: "s3://acme-prod-state/us-east-1/app/terraform.tfstate?dynamodb_table=terraform-locks&region=us-east-1", Workspace: DefaultWorkspace, Stack: "us-east-1/app"},
+		{Path: "s3://acme-prod-state/us-east-1/vpc/terraform.tfstate?dynamodb_table=terraform-locks&region=us-east-1", Workspace: DefaultWorkspace, Stack: "us-east-1/vpc"},
+		{Path: "s3://acme-dev-state/a,b/terraform.tfstate", Workspace: DefaultWorkspace, Stack: "uses-locals"},
+	}, files)
+	assert.ElementsMatch(t, []logger.Fields{{"stack": "gcs"}, {"stack": "unresolvable"}, {"stack": "broken"}}, log.warnings)
+
+	t.Run("no stacks", func(t *testing.T) {
+		_, err := DiscoverTerragrunt(t.TempDir(), &mockLogger{})
+		assert.ErrorContains(t, err, "no Terragrunt stacks with a remote_state found")
+	})
+}
+
+func TestFile_Name(t *testing.T) {
+	assert.Equal(t, "app/terraform.tfstate", File{Path: "app/terraform.tfstate"}.Name())
+	assert.Equal(t, "prod/vpc", File{Path: "s3://b/prod/vpc/terraform.tfstate", Stack: "prod/vpc"}.Name())
+	assert.Equal(t, "prod/vpc [blue]", File{Path: "s3://b/k", Stack: "prod/vpc", Workspace: "blue"}.Label())
+}
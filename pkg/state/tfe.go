@@ -0,0 +1,156 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/papidb/drift-detector/pkg/logger"
+)
+
+// envTFEToken is the fallback token when no host-specific TF_TOKEN_* variable is set.
+const envTFEToken = "TFE_TOKEN"
+
+// tfeWorkspace is the subset of the workspace API document we need.
+type tfeWorkspace struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Locked bool `json:"locked"`
+		} `json:"attributes"`
+		Relationships struct {
+			LockedBy struct {
+				Data *struct {
+					ID   string `json:"id"`
+					Type string `json:"type"`
+				} `json:"data"`
+			} `json:"locked-by"`
+		} `json:"relationships"`
+	} `json:"data"`
+}
+
+// tfeStateVersion is the subset of the state version API document we need.
+type tfeStateVersion struct {
+	Data struct {
+		Attributes struct {
+			HostedStateDownloadURL string `json:"hosted-state-download-url"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type tfeSource struct {
+	client *http.Client
+	log    logger.Logger
+}
+
+// NewTFESource creates a Source for tfe://hostname/organization/workspace
+// locations, reading the workspace's current state version through the
+// Terraform Cloud/Enterprise API. The API token is taken from the same
+// TF_TOKEN_<hostname> variable the Terraform CLI uses, falling back to TFE_TOKEN.
+func NewTFESource(client *http.Client, log logger.Logger) Source {
+	return &tfeSource{client: client, log: log}
+}
+
+func (s *tfeSource) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state location %q: %w", location, err)
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid state location %q: expected tfe://hostname/organization/workspace", location)
+	}
+	organization, workspaceName := parts[0], parts[1]
+
+	token := tfeToken(u.Hostname())
+	if token == "" {
+		return nil, fmt.Errorf("no API token for %s: set TF_TOKEN_%s or %s", u.Host, tfeTokenSuffix(u.Hostname()), envTFEToken)
+	}
+
+	apiURL := fmt.Sprintf("https://%s/api/v2", u.Host)
+
+	var workspace tfeWorkspace
+	workspaceURL := fmt.Sprintf("%s/organizations/%s/workspaces/%s", apiURL, url.PathEscape(organization), url.PathEscape(workspaceName))
+	if err := s.getJSON(ctx, workspaceURL, token, &workspace); err != nil {
+		return nil, fmt.Errorf("failed to look up workspace %s/%s: %w", organization, workspaceName, err)
+	}
+
+	if workspace.Data.Attributes.Locked {
+		fields := logger.Fields{"workspace": fmt.Sprintf("%s/%s", organization, workspaceName)}
+		if lockedBy := workspace.Data.Relationships.LockedBy.Data; lockedBy != nil {
+			fields["locked_by"] = fmt.Sprintf("%s/%s", lockedBy.Type, lockedBy.ID)
+		}
+		s.log.Warn(fields, "Terraform workspace is locked, a run may be in progress; drift reported now may be noise")
+	}
+
+	var stateVersion tfeStateVersion
+	stateVersionURL := fmt.Sprintf("%s/workspaces/%s/current-state-version", apiURL, url.PathEscape(workspace.Data.ID))
+	if err := s.getJSON(ctx, stateVersionURL, token, &stateVersion); err != nil {
+		return nil, fmt.Errorf("failed to look up current state version of %s/%s: %w", organization, workspaceName, err)
+	}
+
+	downloadURL := stateVersion.Data.Attributes.HostedStateDownloadURL
+	if downloadURL == "" {
+		return nil, fmt.Errorf("workspace %s/%s has no downloadable state", organization, workspaceName)
+	}
+
+	resp, err := s.get(ctx, downloadURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download state of %s/%s: %w", organization, workspaceName, err)
+	}
+	return resp.Body, nil
+}
+
+// getJSON fetches an API document and decodes it into v.
+func (s *tfeSource) getJSON(ctx context.Context, target, token string, v interface{}) error {
+	resp, err := s.get(ctx, target, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// get performs an authenticated GET and fails on any non-200 response.
+func (s *tfeSource) get(ctx context.Context, target, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// tfeToken returns the API token for hostname, preferring TF_TOKEN_<hostname>.
+func tfeToken(hostname string) string {
+	if token := os.Getenv("TF_TOKEN_" + tfeTokenSuffix(hostname)); token != "" {
+		return token
+	}
+	return os.Getenv(envTFEToken)
+}
+
+// tfeTokenSuffix encodes a hostname the way the Terraform CLI does for
+// TF_TOKEN_* variables: dots become underscores and dashes become double underscores.
+func tfeTokenSuffix(hostname string) string {
+	return strings.NewReplacer(".", "_", "-", "__").Replace(hostname)
+}
@@ -0,0 +1,129 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/papidb/drift-detector/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTFESource_Open(t *testing.T) {
+	tests := []struct {
+		name             string
+		env              map[string]string
+		path             string
+		locked           bool
+		stateVersion     bool
+		expectedWarnings []logger.Fields
+		expectedErrMsg   string
+	}{
+		{
+			name:         "host specific token",
+			env:          map[string]string{"TF_TOKEN_127_0_0_1": "t0ken"},
+			path:         "/acme/network",
+			stateVersion: true,
+		},
+		{
+			name:         "fallback token and locked workspace",
+			env:          map[string]string{envTFEToken: "t0ken"},
+			path:         "/acme/network",
+			locked:       true,
+			stateVersion: true,
+			expectedWarnings: []logger.Fields{{
+				"workspace": "acme/network",
+				"locked_by": "runs/run-123",
+			}},
+		},
+		{
+			name:           "missing token",
+			path:           "/acme/network",
+			expectedErrMsg: "no API token for",
+		},
+		{
+			name:           "wrong token",
+			env:            map[string]string{envTFEToken: "wrong"},
+			path:           "/acme/network",
+			expectedErrMsg: "failed to look up workspace acme/network: unexpected status 401 Unauthorized",
+		},
+		{
+			name:           "no state yet",
+			env:            map[string]string{envTFEToken: "t0ken"},
+			path:           "/acme/network",
+			expectedErrMsg: "failed to look up current state version of acme/network: unexpected status 404 Not Found",
+		},
+		{
+			name:           "malformed location",
+			env:            map[string]string{envTFEToken: "t0ken"},
+			path:           "/acme",
+			expectedErrMsg: "expected tfe://hostname/organization/workspace",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envTFEToken, "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var srv *httptest.Server
+			authorized := func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Header.Get("Authorization") != "Bearer t0ken" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return false
+				}
+				return true
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v2/organizations/acme/workspaces/network", func(w http.ResponseWriter, r *http.Request) {
+				if !authorized(w, r) {
+					return
+				}
+				lockedBy := "null"
+				if tt.locked {
+					lockedBy = `{"id": "run-123", "type": "runs"}`
+				}
+				fmt.Fprintf(w, `{"data": {"id": "ws-1", "attributes": {"locked": %t}, "relationships": {"locked-by": {"data": %s}}}}`, tt.locked, lockedBy)
+			})
+			mux.HandleFunc("/api/v2/workspaces/ws-1/current-state-version", func(w http.ResponseWriter, r *http.Request) {
+				if !authorized(w, r) {
+					return
+				}
+				if !tt.stateVersion {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprintf(w, `{"data": {"attributes": {"hosted-state-download-url": "%s/download/sv-1"}}}`, srv.URL)
+			})
+			mux.HandleFunc("/download/sv-1", func(w http.ResponseWriter, r *http.Request) {
+				if !authorized(w, r) {
+					return
+				}
+				_, _ = io.WriteString(w, `{"resources": []}`)
+			})
+			srv = httptest.NewTLSServer(mux)
+			defer srv.Close()
+
+			log := &mockLogger{}
+			source := NewTFESource(srv.Client(), log)
+
+			rc, err := source.Open(context.Background(), "tfe://"+srv.Listener.Addr().String()+tt.path)
+
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(rc)
+			assert.Equal(t, `{"resources": []}`, string(data))
+			assert.Equal(t, tt.expectedWarnings, log.warnings)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package userdata
+
+import (
+	"slices"
+	"strings"
+)
+
+// diffContext is how many unchanged lines are kept around each change.
+const diffContext = 2
+
+// Diff returns a line-level diff of two texts. Lines are prefixed with "- "
+// when only in old, "+ " when only in new and "  " when unchanged; unchanged
+// lines further than diffContext from a change are collapsed into "...".
+// Secret-looking assignments are masked with RedactLine.
+func Diff(old, new string) []string {
+	a, b := strings.Split(old, "\n"), strings.Split(new, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return collapse(lines)
+}
+
+// collapse keeps the changed lines and their context, redacting each line.
+func collapse(lines []string) []string {
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if strings.HasPrefix(line, "  ") {
+			continue
+		}
+		for k := max(0, i-diffContext); k <= min(len(lines)-1, i+diffContext); k++ {
+			keep[k] = true
+		}
+	}
+
+	if !slices.Contains(keep, true) {
+		return nil
+	}
+
+	var collapsed []string
+	skipped := false
+	for i, line := range lines {
+		if !keep[i] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			collapsed = append(collapsed, "...")
+		}
+		skipped = false
+		collapsed = append(collapsed, RedactLine(line))
+	}
+	if skipped {
+		collapsed = append(collapsed, "...")
+	}
+	return collapsed
+}
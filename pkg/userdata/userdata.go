@@ -0,0 +1,149 @@
+package userdata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/papidb/drift-detector/pkg/redact"
+)
+
+// hashPattern matches the SHA-1 hex digest the Terraform AWS provider stores
+// in state instead of the user data itself.
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// IsHash reports whether a state value is a digest rather than user data.
+func IsHash(s string) bool {
+	return hashPattern.MatchString(s)
+}
+
+// Hash returns the digest Terraform stores for raw user data.
+func Hash(raw []byte) string {
+	sum := sha1.Sum(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizedHash fingerprints the decoded and normalized text of raw user
+// data, so encodings and line endings that do not change the script compare equal.
+func NormalizedHash(raw []byte) string {
+	sum := sha256.Sum256([]byte(Normalize(Decode(raw))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Decode returns the text of raw user data: gzip is inflated and a MIME
+// multipart cloud-init archive is flattened to its parts, each introduced by a
+// "# part" line naming its content type and file name. Decoding is best effort;
+// data that cannot be decoded is returned as it is.
+func Decode(raw []byte) string {
+	if isGzip(raw) {
+		if inflated, err := gunzip(raw); err == nil {
+			raw = inflated
+		}
+	}
+	if text, ok := decodeMultipart(raw); ok {
+		return text
+	}
+	return string(raw)
+}
+
+// DecodeBase64 decodes user data given base64-encoded, as AWS returns it and
+// Terraform's user_data_base64 records it.
+func DecodeBase64(encoded string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user data: %w", err)
+	}
+	return raw, nil
+}
+
+// Normalize drops carriage returns, trailing whitespace and trailing blank lines.
+func Normalize(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func isGzip(raw []byte) bool {
+	return len(raw) > 2 && raw[0] == 0x1f && raw[1] == 0x8b
+}
+
+func gunzip(raw []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// decodeMultipart flattens a MIME multipart archive, reporting false when raw is not one.
+func decodeMultipart(raw []byte) (string, bool) {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return "", false
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return "", false
+	}
+
+	var b strings.Builder
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for n := 1; ; n++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", false
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return "", false
+		}
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			if decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), "")); err == nil {
+				body = decoded
+			}
+		}
+		if isGzip(body) {
+			if inflated, err := gunzip(body); err == nil {
+				body = inflated
+			}
+		}
+
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		fmt.Fprintf(&b, "# part %d: %s", n, contentType)
+		if name := part.FileName(); name != "" {
+			fmt.Fprintf(&b, " %s", name)
+		}
+		b.WriteString("\n")
+		b.Write(body)
+		if !bytes.HasSuffix(body, []byte("\n")) {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), true
+}
+
+// secretAssignment matches assignments of secret-looking names, e.g.
+// DB_PASSWORD=hunter2 or "api_key": "abc", keeping the name in group 1.
+var secretAssignment = regexp.MustCompile(`(?i)([A-Za-z0-9_.-]*(?:password|passwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key)[A-Za-z0-9_.-]*["']?\s*[=:]\s*)("[^"]*"|'[^']*'|\S+)`)
+
+// RedactLine masks the values of secret-looking assignments in a line of user data.
+func RedactLine(line string) string {
+	return secretAssignment.ReplaceAllString(line, "${1}"+redact.Mask)
+}
@@ -0,0 +1,111 @@
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(t *testing.T, text string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(text))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestHash(t *testing.T) {
+	// sha1("hello"), as the Terraform AWS provider stores it
+	assert.Equal(t, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", Hash([]byte("hello")))
+	assert.True(t, IsHash(Hash([]byte("hello"))))
+	assert.False(t, IsHash("#!/bin/bash"))
+	assert.False(t, IsHash(""))
+}
+
+func TestDecode(t *testing.T) {
+	multipartArchive := strings.Join([]string{
+		`Content-Type: multipart/mixed; boundary="BOUNDARY"`,
+		"MIME-Version: 1.0",
+		"",
+		"--BOUNDARY",
+		`Content-Type: text/cloud-config; charset="us-ascii"`,
+		"",
+		"packages: [nginx]",
+		"--BOUNDARY",
+		"Content-Type: text/x-shellscript",
+		"Content-Transfer-Encoding: base64",
+		`Content-Disposition: attachment; filename="setup.sh"`,
+		"",
+		"IyEvYmluL2Jhc2gKZWNobyBoaQo=",
+		"--BOUNDARY--",
+		"",
+	}, "\r\n")
+
+	tests := []struct {
+		name     string
+		raw      []byte
+		expected string
+	}{
+		{name: "plain", raw: []byte("#!/bin/bash\necho hi\n"), expected: "#!/bin/bash\necho hi\n"},
+		{name: "gzip", raw: gzipped(t, "#!/bin/bash\necho hi\n"), expected: "#!/bin/bash\necho hi\n"},
+		{
+			name:     "multipart",
+			raw:      []byte(multipartArchive),
+			expected: "# part 1: text/cloud-config\npackages: [nginx]\n# part 2: text/x-shellscript setup.sh\n#!/bin/bash\necho hi\n",
+		},
+		{
+			name:     "gzipped multipart",
+			raw:      gzipped(t, multipartArchive),
+			expected: "# part 1: text/cloud-config\npackages: [nginx]\n# part 2: text/x-shellscript setup.sh\n#!/bin/bash\necho hi\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Decode(tt.raw))
+		})
+	}
+}
+
+func TestNormalizedHash(t *testing.T) {
+	script := "#!/bin/bash\necho hi\n"
+	assert.Equal(t, NormalizedHash([]byte(script)), NormalizedHash(gzipped(t, "#!/bin/bash  \r\necho hi\r\n\r\n")))
+	assert.NotEqual(t, NormalizedHash([]byte(script)), NormalizedHash([]byte("#!/bin/bash\necho bye\n")))
+}
+
+func TestDecodeBase64(t *testing.T) {
+	raw, err := DecodeBase64("aGk=\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(raw))
+
+	_, err = DecodeBase64("not base64!")
+	assert.ErrorContains(t, err, "failed to decode user data")
+}
+
+func TestRedactLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"export DB_PASSWORD=hunter2", "export DB_PASSWORD=[REDACTED]"},
+		{`  "api_key": "abc123",`, `  "api_key": [REDACTED],`},
+		{"GITHUB_TOKEN='ghp_x' ./deploy.sh", "GITHUB_TOKEN=[REDACTED] ./deploy.sh"},
+		{"yum install -y nginx", "yum install -y nginx"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, RedactLine(tt.line))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9"
+	new := "1\n2\n3\n4\nfive\n6\n7\n8\n9"
+
+	assert.Equal(t, []string{"...", "  3", "  4", "- 5", "+ five", "  6", "  7", "..."}, Diff(old, new))
+	assert.Equal(t, []string{"  a", "+ b"}, Diff("a", "a\nb"))
+	assert.Empty(t, Diff("a\nb", "a\nb"))
+}